@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/platform9/cctl/pkg/logrus"
+	"github.com/spf13/cobra"
+)
+
+// adoptCmd represents the adopt command
+var adoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "Used to adopt existing resources into the state",
+	Args:  cobra.MinimumNArgs(1),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		InitState()
+		// PersistentPreRuns are not chained https://github.com/spf13/cobra/issues/216
+		// Therefore LogLevel must be set in all the PersistentPreRuns
+		if err := log.SetLogLevelUsingString(LogLevel); err != nil {
+			log.Fatalf("Unable to parse log level %s", LogLevel)
+		}
+		preflightValidateState()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("adopt called")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(adoptCmd)
+}
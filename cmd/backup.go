@@ -44,7 +44,7 @@ var backupCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Unable to parse `snapshot`: %v", err)
 		}
-		if err := archive.Create(archivePath, stateFilename, snapshotPath); err != nil {
+		if err := archive.Create(archivePath, stateFileStore().Filename, snapshotPath); err != nil {
 			log.Fatalf("Unable to create archive: %v", err)
 		}
 		log.Printf("[backup] Created archive %q", archivePath)
@@ -22,7 +22,9 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"sort"
 	"strings"
+	"text/tabwriter"
 	"text/template"
 
 	log "github.com/platform9/cctl/pkg/logrus"
@@ -34,6 +36,10 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 
 	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/adopt"
+	"github.com/platform9/cctl/pkg/etcdcluster"
+	"github.com/platform9/cctl/pkg/machineclient"
+	"github.com/platform9/cctl/pkg/preflight"
 	"github.com/platform9/cctl/pkg/util/clusterapi"
 	"github.com/platform9/cctl/pkg/util/secret"
 	"github.com/platform9/cctl/semverutil"
@@ -41,6 +47,9 @@ import (
 	spconstants "github.com/platform9/ssh-provider/constants"
 	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
 	sputil "github.com/platform9/ssh-provider/pkg/controller"
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+	"github.com/platform9/ssh-provider/pkg/nodeadm"
+	setsutil "github.com/platform9/ssh-provider/pkg/util/sets"
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
@@ -65,9 +74,23 @@ var clusterCmdCreate = &cobra.Command{
 			if err != nil {
 				log.Fatalf("Unable to parse cluster object %v", err)
 			}
+			// The cluster object names the cluster, unless --cluster is given
+			if len(clusterObj.Name) == 0 {
+				clusterObj.Name = clusterName
+			} else if clusterObj.Name != clusterName {
+				if len(clusterFlag) != 0 {
+					log.Fatalf("Cluster object name %q does not match --cluster %q", clusterObj.Name, clusterFlag)
+				}
+				namespace, err := namespaceForCluster(clusterObj.Name)
+				if err != nil {
+					log.Fatalf("Unable to use cluster %q: %v", clusterObj.Name, err)
+				}
+				clusterName, clusterNamespace = clusterObj.Name, namespace
+			}
+			clusterObj.Namespace = clusterNamespace
 
-			if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Create(clusterObj); err != nil {
-				log.Fatalf("Unable to create cluster %q: %v", common.DefaultClusterName, err)
+			if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Create(clusterObj); err != nil {
+				log.Fatalf("Unable to create cluster %q: %v", clusterName, err)
 			}
 			if err := state.PullFromAPIs(); err != nil {
 				log.Fatalf("Unable to sync on-disk state: %v", err)
@@ -149,27 +172,34 @@ var clusterCmdCreate = &cobra.Command{
 			log.Fatalf("Unable to generate bootstrap token secret: %v", err)
 		}
 
-		newCluster, err := createCluster(common.DefaultClusterName, podsCIDR, servicesCIDR, vipConfig, clusterConfig)
+		newCluster, err := createCluster(clusterName, podsCIDR, servicesCIDR, vipConfig, clusterConfig)
 		if err != nil {
 			log.Fatalf("Unable to create cluster: %v", err)
 		}
-		if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newAPIServerCASecret); err != nil {
+		jumpHosts, err := jumpHostsFromFlag(cmd)
+		if err != nil {
+			log.Fatalf("Unable to load jump hosts: %v", err)
+		}
+		if err := setJumpHostsAnnotation(&newCluster.ObjectMeta, jumpHosts); err != nil {
+			log.Fatalf("Unable to record jump hosts: %v", err)
+		}
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newAPIServerCASecret); err != nil {
 			log.Fatalf("Unable to create API server CA secret: %v", err)
 		}
-		if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newEtcdCASecret); err != nil {
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newEtcdCASecret); err != nil {
 			log.Fatalf("Unable to create etcd CA secret: %v", err)
 		}
-		if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newFrontProxyCASecret); err != nil {
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newFrontProxyCASecret); err != nil {
 			log.Fatalf("Unable to create front proxy CA secret: %v", err)
 		}
-		if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newServiceAccountKeySecret); err != nil {
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newServiceAccountKeySecret); err != nil {
 			log.Fatalf("Unable to create service account secret: %v", err)
 		}
-		if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newBootstrapTokenSecret); err != nil {
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newBootstrapTokenSecret); err != nil {
 			log.Fatalf("Unable to create bootstrap token secret: %v", err)
 		}
-		if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Create(newCluster); err != nil {
-			log.Fatalf("Unable to create cluster %q: %v", common.DefaultClusterName, err)
+		if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Create(newCluster); err != nil {
+			log.Fatalf("Unable to create cluster %q: %v", clusterName, err)
 		}
 		if err := state.PullFromAPIs(); err != nil {
 			log.Fatalf("Unable to sync on-disk state: %v", err)
@@ -268,7 +298,7 @@ func createCluster(clusterName, podsCIDR, servicesCIDR string, vipConfig *spv1.V
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              clusterName,
-			Namespace:         common.DefaultNamespace,
+			Namespace:         clusterNamespace,
 			CreationTimestamp: metav1.Now(),
 		},
 		Spec: clusterv1.ClusterSpec{
@@ -332,12 +362,12 @@ var clusterCmdDelete = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Println("Running cluster delete")
 
-		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
+		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
 		if err != nil {
 			log.Fatalf("Unable to get cluster: %v", err)
 		}
 
-		machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).List(metav1.ListOptions{})
+		machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
 		if err != nil {
 			log.Fatalf("Unable to list machines: %v", err)
 		}
@@ -350,7 +380,7 @@ var clusterCmdDelete = &cobra.Command{
 			if forceDelete {
 				log.Printf("Machines [%s] part of cluster. Deleting them from the state.", machineNames)
 				for _, machine := range machineList.Items {
-					if err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).Delete(machine.Name, &metav1.DeleteOptions{}); err != nil {
+					if err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Delete(machine.Name, &metav1.DeleteOptions{}); err != nil {
 						if !apierrors.IsNotFound(err) {
 							log.Fatalf("Unable to delete machine %q: %v", machine.Name, err)
 						}
@@ -366,48 +396,48 @@ var clusterCmdDelete = &cobra.Command{
 			log.Fatalf("Unable to decode cluster spec: %v", err)
 		}
 		if clusterProviderSpec.APIServerCASecret != nil {
-			if err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Delete(clusterProviderSpec.APIServerCASecret.Name, &metav1.DeleteOptions{}); err != nil {
+			if err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Delete(clusterProviderSpec.APIServerCASecret.Name, &metav1.DeleteOptions{}); err != nil {
 				if !apierrors.IsNotFound(err) {
 					log.Fatalf("Unable to delete API server CA secret: %v", err)
 				}
 			}
 		}
 		if clusterProviderSpec.EtcdCASecret != nil {
-			if err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Delete(clusterProviderSpec.EtcdCASecret.Name, &metav1.DeleteOptions{}); err != nil {
+			if err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Delete(clusterProviderSpec.EtcdCASecret.Name, &metav1.DeleteOptions{}); err != nil {
 				if !apierrors.IsNotFound(err) {
 					log.Fatalf("Unable to delete etcd CA secret: %v", err)
 				}
 			}
 		}
 		if clusterProviderSpec.FrontProxyCASecret != nil {
-			if err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Delete(clusterProviderSpec.FrontProxyCASecret.Name, &metav1.DeleteOptions{}); err != nil {
+			if err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Delete(clusterProviderSpec.FrontProxyCASecret.Name, &metav1.DeleteOptions{}); err != nil {
 				if !apierrors.IsNotFound(err) {
 					log.Fatalf("Unable to delete front proxy CA secret: %v", err)
 				}
 			}
 		}
 		if clusterProviderSpec.ServiceAccountKeySecret != nil {
-			if err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Delete(clusterProviderSpec.ServiceAccountKeySecret.Name, &metav1.DeleteOptions{}); err != nil {
+			if err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Delete(clusterProviderSpec.ServiceAccountKeySecret.Name, &metav1.DeleteOptions{}); err != nil {
 				if !apierrors.IsNotFound(err) {
 					log.Fatalf("Unable to delete service account key secret: %v", err)
 				}
 			}
 		}
 		if clusterProviderSpec.BootstrapTokenSecret != nil {
-			if err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Delete(clusterProviderSpec.BootstrapTokenSecret.Name, &metav1.DeleteOptions{}); err != nil {
+			if err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Delete(clusterProviderSpec.BootstrapTokenSecret.Name, &metav1.DeleteOptions{}); err != nil {
 				if !apierrors.IsNotFound(err) {
 					log.Fatalf("Unable to delete bootstrap token secret: %v", err)
 				}
 			}
 		}
 
-		if err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Delete(common.DefaultAdminConfigSecretName, &metav1.DeleteOptions{}); err != nil {
+		if err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Delete(common.DefaultAdminConfigSecretName, &metav1.DeleteOptions{}); err != nil {
 			if !apierrors.IsNotFound(err) {
 				log.Fatalf("Unable to delete admin kubeconfig secret: %v", err)
 			}
 		}
 
-		if err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Delete(cluster.Name, &metav1.DeleteOptions{}); err != nil {
+		if err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Delete(cluster.Name, &metav1.DeleteOptions{}); err != nil {
 			if !apierrors.IsNotFound(err) {
 				log.Fatalf("Unable to delete cluster: %v", err)
 			}
@@ -416,6 +446,9 @@ var clusterCmdDelete = &cobra.Command{
 		if err := state.PullFromAPIs(); err != nil {
 			log.Fatalf("Unable to sync on-disk state: %v", err)
 		}
+		if loadConfig().CurrentContext == cluster.Name {
+			setCurrentContext("")
+		}
 		log.Println("Cluster deleted successfully")
 	},
 }
@@ -424,7 +457,7 @@ var clusterCmdGet = &cobra.Command{
 	Use:   "cluster",
 	Short: "Get the cluster details",
 	Run: func(cmd *cobra.Command, args []string) {
-		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
+		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
 		if err != nil {
 			log.Fatalf("Unable to get cluster: %v", err)
 		}
@@ -464,8 +497,329 @@ var clusterCmdGet = &cobra.Command{
 	},
 }
 
+var clusterCmdAdopt = &cobra.Command{
+	Use:   "cluster",
+	Short: "Adopts a cluster deployed with nodeadm and etcdadm",
+	Run: func(cmd *cobra.Command, args []string) {
+		masterIPs, err := cmd.Flags().GetStringSlice("master")
+		if err != nil {
+			log.Fatalf("Unable to parse `master`: %v", err)
+		}
+		nodeIPs, err := cmd.Flags().GetStringSlice("node")
+		if err != nil {
+			log.Fatalf("Unable to parse `node`: %v", err)
+		}
+		port, err := cmd.Flags().GetInt("port")
+		if err != nil {
+			log.Fatalf("Unable to parse `port`: %v", err)
+		}
+		iface := cmd.Flag("iface").Value.String()
+		if len(masterIPs) == 0 {
+			log.Fatalf("Must specify at least one --master.")
+		}
+		jumpHosts, err := jumpHostsFromFlag(cmd)
+		if err != nil {
+			log.Fatalf("Unable to load jump hosts: %v", err)
+		}
+		adoptCluster(masterIPs, nodeIPs, port, iface, cmd.Flag("credential").Value.String(), jumpHosts)
+	},
+}
+
+// adoptedMachine is a machine read from a running cluster.
+type adoptedMachine struct {
+	Machine            *clusterv1.Machine
+	ProvisionedMachine *spv1.ProvisionedMachine
+	InitConfiguration  *nodeadm.InitConfiguration
+	Client             sshmachine.Client
+}
+
+// adoptMachine reads the machine, and returns its Machine and
+// ProvisionedMachine objects. If jump hosts are given, the machine is reached
+// through them.
+func adoptMachine(ip string, port int, iface string, role clustercommon.MachineRole, sshCredentialSecret *corev1.Secret, jumpHosts []machineclient.JumpHost) (*adoptedMachine, error) {
+	sshConfig := spv1.SSHConfig{
+		Host:       ip,
+		Port:       port,
+		PublicKeys: []string{},
+		CredentialSecret: corev1.LocalObjectReference{
+			Name: sshCredentialSecret.Name,
+		},
+	}
+	if len(jumpHosts) == 0 {
+		var err error
+		if jumpHosts, err = jumpHostsForSSHConfig(&sshConfig); err != nil {
+			return nil, fmt.Errorf("unable to find jump hosts: %v", err)
+		}
+	}
+	client, err := sshMachineClientWithJumpHosts(&sshConfig, jumpHosts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create machine client: %v", err)
+	}
+	isMaster := role == clustercommon.MasterRole
+	versions, err := adopt.ReadComponentVersions(client, isMaster)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read component versions: %v", err)
+	}
+	var initCfg *nodeadm.InitConfiguration
+	var etcdMember *spv1.EtcdMember
+	if isMaster {
+		if initCfg, err = adopt.ReadInitConfiguration(client); err != nil {
+			return nil, fmt.Errorf("unable to read nodeadm configuration: %v", err)
+		}
+		if etcdMember, err = adopt.ReadEtcdMember(client); err != nil {
+			return nil, fmt.Errorf("unable to read etcd member: %v", err)
+		}
+		if len(iface) == 0 && initCfg != nil && initCfg.VIPConfiguration != nil {
+			iface = initCfg.VIPConfiguration.NetworkInterface
+		}
+	}
+	if len(iface) == 0 {
+		iface = "eth0"
+	}
+	provisionedMachine, machine, err := newProvisionedMachineAndMachine(ip, role, iface, sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	productUUID, err := preflight.ProductUUID(client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read product UUID: %v", err)
+	}
+	metav1.SetMetaDataAnnotation(&machine.ObjectMeta, common.ProductUUIDAnnotationKey, productUUID)
+	machineSpec, err := sputil.GetMachineSpec(*machine)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode machine spec: %v", err)
+	}
+	machineSpec.ComponentVersions = versions
+	if err := sputil.PutMachineSpec(*machineSpec, machine); err != nil {
+		return nil, fmt.Errorf("unable to encode machine spec: %v", err)
+	}
+	machineStatus, err := sputil.GetMachineStatus(*machine)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode machine status: %v", err)
+	}
+	machineStatus.EtcdMember = etcdMember
+	if err := sputil.PutMachineStatus(*machineStatus, machine); err != nil {
+		return nil, fmt.Errorf("unable to encode machine status: %v", err)
+	}
+	return &adoptedMachine{
+		Machine:            machine,
+		ProvisionedMachine: provisionedMachine,
+		InitConfiguration:  initCfg,
+		Client:             client,
+	}, nil
+}
+
+func adoptCluster(masterIPs, nodeIPs []string, port int, iface string, credential string, jumpHosts []machineclient.JumpHost) {
+	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{}); err == nil {
+		log.Fatalf("Cluster %q already exists. Use --cluster to adopt the cluster under a different name.", clusterName)
+	} else if !apierrors.IsNotFound(err) {
+		log.Fatalf("Unable to get cluster: %v", err)
+	}
+	sshCredentialSecret, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(credential, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Fatalf("No SSH credential %q found. Create the credential before adopting a cluster.", credential)
+		}
+		log.Fatalf("Unable to get SSH credential secret: %v", err)
+	}
+
+	var masters, machines []*adoptedMachine
+	for _, ip := range masterIPs {
+		log.Printf("Reading master %q", ip)
+		am, err := adoptMachine(ip, port, iface, clustercommon.MasterRole, sshCredentialSecret, jumpHosts)
+		if err != nil {
+			log.Fatalf("Unable to adopt master %q: %v", ip, err)
+		}
+		masters = append(masters, am)
+	}
+	machines = append(machines, masters...)
+	for _, ip := range nodeIPs {
+		log.Printf("Reading node %q", ip)
+		am, err := adoptMachine(ip, port, iface, clustercommon.NodeRole, sshCredentialSecret, jumpHosts)
+		if err != nil {
+			log.Fatalf("Unable to adopt node %q: %v", ip, err)
+		}
+		machines = append(machines, am)
+	}
+
+	firstMaster := masters[0]
+	log.Printf("Reading cluster configuration from master %q", firstMaster.Machine.Name)
+	kcc, err := adopt.ReadClusterConfiguration(firstMaster.Client)
+	if err != nil {
+		log.Fatalf("Unable to read kubeadm configuration: %v", err)
+	}
+	if len(kcc.Networking.PodSubnet) == 0 || len(kcc.Networking.ServiceSubnet) == 0 {
+		log.Fatalf("Unable to find the pod and service networks in the kubeadm configuration.")
+	}
+	for _, am := range machines {
+		machineSpec, err := sputil.GetMachineSpec(*am.Machine)
+		if err != nil {
+			log.Fatalf("Unable to decode machine %q spec: %v", am.Machine.Name, err)
+		}
+		if v := strings.TrimPrefix(kcc.KubernetesVersion, "v"); machineSpec.ComponentVersions.KubernetesVersion != v {
+			log.Warnf("Machine %q runs Kubernetes %s, but the cluster is configured for %s.", am.Machine.Name, machineSpec.ComponentVersions.KubernetesVersion, v)
+		}
+	}
+
+	// Every etcd member must be one of the adopted masters, so that cctl can
+	// manage etcd membership.
+	etcdMembers, err := etcdcluster.ReadMembers(firstMaster.Client)
+	if err != nil {
+		log.Fatalf("Unable to read etcd members: %v", err)
+	}
+	masterForMember := make(map[uint64]string)
+	for _, am := range masters {
+		machineStatus, err := sputil.GetMachineStatus(*am.Machine)
+		if err != nil {
+			log.Fatalf("Unable to decode machine %q status: %v", am.Machine.Name, err)
+		}
+		masterForMember[machineStatus.EtcdMember.ID] = am.Machine.Name
+	}
+	if len(etcdMembers) != len(masterForMember) {
+		log.Fatalf("Found %d etcd members, but %d masters. Give every master with --master.", len(etcdMembers), len(masterForMember))
+	}
+	for _, member := range etcdMembers {
+		if _, ok := masterForMember[member.ID]; !ok {
+			log.Fatalf("Etcd member %q (%v) is not on any of the given masters. Give every master with --master.", member.Name, member.PeerURLs)
+		}
+	}
+
+	var vipConfig *spv1.VIPConfiguration
+	if cfg := firstMaster.InitConfiguration; cfg != nil && cfg.VIPConfiguration != nil {
+		vipConfig = &spv1.VIPConfiguration{
+			IP:       cfg.VIPConfiguration.IP,
+			RouterID: cfg.VIPConfiguration.RouterID,
+		}
+	} else if len(kcc.ControlPlaneEndpoint) != 0 {
+		log.Warnf("The cluster control plane endpoint is %q, but no VIP configuration was found on master %q. The VIP is not adopted.", kcc.ControlPlaneEndpoint, firstMaster.Machine.Name)
+	}
+	newCluster, err := createCluster(clusterName, kcc.Networking.PodSubnet, kcc.Networking.ServiceSubnet, vipConfig, adopt.ClusterConfig(kcc, firstMaster.InitConfiguration))
+	if err != nil {
+		log.Fatalf("Unable to create cluster: %v", err)
+	}
+	if len(kcc.Networking.DNSDomain) != 0 {
+		newCluster.Spec.ClusterNetwork.ServiceDomain = kcc.Networking.DNSDomain
+	}
+	clusterStatus, err := sputil.GetClusterStatus(*newCluster)
+	if err != nil {
+		log.Fatalf("Unable to decode cluster status: %v", err)
+	}
+	clusterStatus.EtcdMembers = etcdMembers
+	if err := sputil.PutClusterStatus(*clusterStatus, newCluster); err != nil {
+		log.Fatalf("Unable to encode cluster status: %v", err)
+	}
+
+	log.Printf("Reading cluster secrets from master %q", firstMaster.Machine.Name)
+	for name := range adopt.ClusterSecrets {
+		clusterSecret, err := adopt.ReadClusterSecret(firstMaster.Client, name)
+		if err != nil {
+			log.Fatalf("Unable to read secret %q: %v", name, err)
+		}
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(clusterSecret); err != nil {
+			log.Fatalf("Unable to create secret %q: %v", name, err)
+		}
+	}
+	newBootstrapTokenSecret, err := secret.CreateBootstrapTokenSecret(common.DefaultBootstrapTokenSecretName)
+	if err != nil {
+		log.Fatalf("Unable to generate bootstrap token secret: %v", err)
+	}
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newBootstrapTokenSecret); err != nil {
+		log.Fatalf("Unable to create bootstrap token secret: %v", err)
+	}
+	adminKubeConfigSecret, err := createAdminKubeconfigSecret(firstMaster.Machine, firstMaster.ProvisionedMachine)
+	if err != nil {
+		log.Fatalf("Unable to create secret for admin kubeconfig: %v", err)
+	}
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(adminKubeConfigSecret); err != nil {
+		log.Fatalf("Unable to create secret for admin kubeconfig: %v", err)
+	}
+
+	apiEndpointSet := setsutil.NewAPIEndpointSet()
+	for _, am := range masters {
+		apiEndpoint, err := apiEndpointForMaster(am.Machine, am.ProvisionedMachine)
+		if err != nil {
+			log.Fatalf("Unable to get machine %q API endpoint: %v", am.Machine.Name, err)
+		}
+		apiEndpointSet.Insert(*apiEndpoint)
+	}
+	newCluster.Status.APIEndpoints = apiEndpointSet.List()
+
+	for _, am := range machines {
+		if _, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Create(am.ProvisionedMachine); err != nil {
+			log.Fatalf("Unable to create provisioned machine %q: %v", am.ProvisionedMachine.Name, err)
+		}
+		if _, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Create(am.Machine); err != nil {
+			log.Fatalf("Unable to create machine %q: %v", am.Machine.Name, err)
+		}
+	}
+	if err := setJumpHostsAnnotation(&newCluster.ObjectMeta, jumpHosts); err != nil {
+		log.Fatalf("Unable to record jump hosts: %v", err)
+	}
+	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Create(newCluster); err != nil {
+		log.Fatalf("Unable to create cluster %q: %v", clusterName, err)
+	}
+	if err := state.PullFromAPIs(); err != nil {
+		log.Fatalf("Unable to sync on-disk state: %v", err)
+	}
+	log.Printf("Cluster adopted successfully: %d masters and %d nodes.", len(masters), len(machines)-len(masters))
+}
+
+var clusterCmdGetList = &cobra.Command{
+	Use:   "clusters",
+	Short: "List the clusters in the state",
+	Run: func(cmd *cobra.Command, args []string) {
+		clusterList, err := state.ClusterClient.ClusterV1alpha1().Clusters(corev1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			log.Fatalf("Unable to list clusters: %v", err)
+		}
+		switch outputFmt {
+		case "yaml":
+			bytes, err := yaml.Marshal(clusterList.Items)
+			if err != nil {
+				log.Fatalf("Unable to marshal clusters to yaml: %s", err)
+			}
+			os.Stdout.Write(bytes)
+		case "json":
+			bytes, err := json.Marshal(clusterList.Items)
+			if err != nil {
+				log.Fatalf("Unable to marshal clusters to json: %s", err)
+			}
+			os.Stdout.Write(bytes)
+		case "":
+			type clusterSummary struct {
+				Name      string
+				Namespace string
+				Machines  int
+				Current   bool
+			}
+			summaries := []clusterSummary{}
+			for _, cluster := range clusterList.Items {
+				machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(cluster.Namespace).List(metav1.ListOptions{})
+				if err != nil {
+					log.Fatalf("Unable to list machines of cluster %q: %v", cluster.Name, err)
+				}
+				summaries = append(summaries, clusterSummary{
+					Name:      cluster.Name,
+					Namespace: cluster.Namespace,
+					Machines:  len(machineList.Items),
+					Current:   cluster.Name == clusterName && cluster.Namespace == clusterNamespace,
+				})
+			}
+			sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			t := template.Must(template.New("ClusterListV1PrintTemplate").Parse(common.ClusterListV1PrintTemplate))
+			if err := t.Execute(w, summaries); err != nil {
+				log.Fatalf("Could not pretty print clusters: %s", err)
+			}
+			w.Flush()
+		default:
+			log.Fatalf("Unsupported output format %q", outputFmt)
+		}
+	},
+}
+
 func createLocalCopyOfAdminKubeConfig() (string, error) {
-	kubeconfig, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Get(common.DefaultAdminConfigSecretName, metav1.GetOptions{})
+	kubeconfig, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(common.DefaultAdminConfigSecretName, metav1.GetOptions{})
 	if err != nil {
 		return "", fmt.Errorf("unable to get admin kubeconfig from secret: %v", err)
 	}
@@ -509,7 +863,7 @@ func trimVFromVersion(version string) string {
 }
 
 func checkVersionSkew() error {
-	machines, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).List(metav1.ListOptions{})
+	machines, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
 	if err != nil {
 		return fmt.Errorf("unable to get list of machines in the cluster")
 	}
@@ -543,7 +897,7 @@ func upgradeMachines(machines []clusterv1.Machine) error {
 			return fmt.Errorf("unable to decode machine spec: %v", err)
 		}
 		currentProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().
-			ProvisionedMachines(common.DefaultNamespace).
+			ProvisionedMachines(clusterNamespace).
 			Get(machineSpec.ProvisionedMachineName, metav1.GetOptions{})
 		if err != nil {
 			return fmt.Errorf("unable to decode provisioned machine spec: %v", err)
@@ -572,25 +926,25 @@ var clusterCmdUpgrade = &cobra.Command{
 		log.Print("[pre-flight] Preflight check passed")
 		log.Print("Starting cluster upgrade")
 
-		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
+		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
 		if err != nil {
-			log.Fatalf("unable to get cluster %s: %v", common.DefaultClusterName, err)
+			log.Fatalf("unable to get cluster %s: %v", clusterName, err)
 		}
 		clusterSpec, err := sputil.GetClusterSpec(*cluster)
 		if err != nil {
-			log.Fatalf("unable to get cluster spec %s: %v", common.DefaultClusterName, err)
+			log.Fatalf("unable to get cluster spec %s: %v", clusterName, err)
 		}
 		if clusterSpec.ClusterConfig == nil {
 			clusterSpec.ClusterConfig = &spv1.ClusterConfig{}
 			setClusterConfigDefaults(clusterSpec.ClusterConfig)
 		}
 		if err := sputil.PutClusterSpec(*clusterSpec, cluster); err != nil {
-			log.Fatalf("Unable to update cluster spec %s: %v", common.DefaultClusterName, err)
+			log.Fatalf("Unable to update cluster spec %s: %v", clusterName, err)
 		}
-		if _, err = state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Update(cluster); err != nil {
-			log.Fatalf("unable to update cluster spec %s: %v", common.DefaultClusterName, err)
+		if _, err = state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Update(cluster); err != nil {
+			log.Fatalf("unable to update cluster spec %s: %v", clusterName, err)
 		}
-		machines, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).List(metav1.ListOptions{})
+		machines, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
 		if err != nil {
 			log.Fatalf("unable to get list of machines in the cluster")
 		}
@@ -660,12 +1014,21 @@ func init() {
 	clusterCmdCreate.Flags().String("sa-public-key", "", "Location of file containing public key used for signing service account tokens")
 	clusterCmdCreate.Flags().String("cluster-config", "", "Location of file containing configurable parameters for the cluster")
 	clusterCmdCreate.Flags().StringP("file", "f", "", "Location of file containing a cluster object")
+	clusterCmdCreate.Flags().String("jump-hosts-file", "", "File listing the jump hosts through which the machines of the cluster are reached")
 	//clusterCmdCreate.Flags().String("version", "1.10.2", "Kubernetes version")
 
 	deleteCmd.AddCommand(clusterCmdDelete)
 	clusterCmdDelete.Flags().BoolVar(&forceDelete, "force", false, "Force delete a cluster")
 
 	getCmd.AddCommand(clusterCmdGet)
+	adoptCmd.AddCommand(clusterCmdAdopt)
+	clusterCmdAdopt.Flags().StringSlice("master", []string{}, "IP of a master. Provide a comma-separated list, or define multiple flags. Every master in the cluster must be given.")
+	clusterCmdAdopt.Flags().StringSlice("node", []string{}, "IP of a node. Provide a comma-separated list, or define multiple flags.")
+	clusterCmdAdopt.Flags().Int("port", common.DefaultSSHPort, "SSH port")
+	clusterCmdAdopt.Flags().String("jump-hosts-file", "", "File listing the jump hosts through which the machines of the cluster are reached")
+	clusterCmdAdopt.Flags().String("credential", common.DefaultSSHCredentialSecretName, "Name of the SSH credential used to reach the machines")
+	clusterCmdAdopt.Flags().String("iface", "", "Interface that keepalived binds to on masters. Defaults to the interface in the master's nodeadm configuration, or eth0")
+	getCmd.AddCommand(clusterCmdGetList)
 	upgradeCmd.AddCommand(clusterCmdUpgrade)
 	clusterCmdUpgrade.Flags().DurationVar(&drainTimeout, "drain-timeout", common.DrainTimeout, "The length of time to wait before giving up, zero means infinite")
 	clusterCmdUpgrade.Flags().IntVar(&drainGracePeriodSeconds, "drain-grace-period", common.DrainGracePeriodSeconds, "Period of time in seconds given to each pod to terminate gracefully. If negative, the default value specified in the pod will be used.")
@@ -0,0 +1,158 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/config"
+	log "github.com/platform9/cctl/pkg/logrus"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// clusterFlag is the cluster selected with the --cluster flag.
+var clusterFlag string
+
+// configFile is the cctl configuration file.
+var configFile string
+
+// clusterName and clusterNamespace identify the cluster that commands act on.
+// They are set by resolveCluster.
+var clusterName = common.DefaultClusterName
+var clusterNamespace = common.DefaultNamespace
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Modify the cctl configuration",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// PersistentPreRuns are not chained https://github.com/spf13/cobra/issues/216
+		// Therefore LogLevel must be set in all the PersistentPreRuns
+		if err := log.SetLogLevelUsingString(LogLevel); err != nil {
+			log.Fatalf("Unable to parse log level %s", LogLevel)
+		}
+	},
+}
+
+var configCmdUseContext = &cobra.Command{
+	Use:   "use-context CLUSTER",
+	Short: "Set the cluster that commands act on if --cluster is not given",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		clusterFlag = args[0]
+		InitState()
+		if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{}); err != nil {
+			log.Fatalf("Unable to get cluster %q: %v", clusterName, err)
+		}
+		setCurrentContext(clusterName)
+		log.Printf("Switched to cluster %q.", clusterName)
+	},
+}
+
+var configCmdCurrentContext = &cobra.Command{
+	Use:   "current-context",
+	Short: "Print the cluster that commands act on if --cluster is not given",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := loadConfig()
+		if len(cfg.CurrentContext) == 0 {
+			fmt.Println(common.DefaultClusterName)
+			return
+		}
+		fmt.Println(cfg.CurrentContext)
+	},
+}
+
+// loadConfig reads the cctl configuration file, and exits on error.
+func loadConfig() *config.Config {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatalf("Unable to load cctl config: %v", err)
+	}
+	return cfg
+}
+
+// setCurrentContext writes the current context to the cctl configuration
+// file, and exits on error. An empty name clears the current context.
+func setCurrentContext(name string) {
+	cfg := loadConfig()
+	cfg.CurrentContext = name
+	if err := cfg.Save(configFile); err != nil {
+		log.Fatalf("Unable to save cctl config: %v", err)
+	}
+}
+
+// resolveCluster sets the cluster that commands act on. The cluster is the one
+// given with --cluster, or else the current context, or else the default
+// cluster.
+func resolveCluster() {
+	name := clusterFlag
+	if len(name) == 0 {
+		name = loadConfig().CurrentContext
+	}
+	if len(name) == 0 {
+		name = common.DefaultClusterName
+	}
+	namespace, err := namespaceForCluster(name)
+	if err != nil {
+		log.Fatalf("Unable to use cluster %q: %v", name, err)
+	}
+	clusterName = name
+	clusterNamespace = namespace
+	log.Debugf("Using cluster %q in namespace %q", clusterName, clusterNamespace)
+}
+
+// namespaceForCluster returns the namespace of the cluster. A cluster that
+// is not in the state yet is given a namespace of its own, except for the
+// default cluster, which uses the default namespace.
+func namespaceForCluster(name string) (string, error) {
+	clusterList, err := state.ClusterClient.ClusterV1alpha1().Clusters(corev1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to list clusters: %v", err)
+	}
+	namespaces := []string{}
+	for _, cluster := range clusterList.Items {
+		if cluster.Name == name {
+			namespaces = append(namespaces, cluster.Namespace)
+		}
+	}
+	switch {
+	case len(namespaces) == 1:
+		return namespaces[0], nil
+	case len(namespaces) > 1:
+		return "", fmt.Errorf("found more than one cluster with that name, in namespaces %v", namespaces)
+	case name == common.DefaultClusterName:
+		return common.DefaultNamespace, nil
+	}
+	if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+		return "", fmt.Errorf("cluster name is not valid: %v", errs)
+	}
+	return name, nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&clusterFlag, "cluster", "", "cluster to act on. Defaults to the current context")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", config.DefaultFilename(), fmt.Sprintf("cctl config file. The location can also be set with %s", config.EnvVar))
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configCmdUseContext)
+	configCmd.AddCommand(configCmdCurrentContext)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	log "github.com/platform9/cctl/pkg/logrus"
+
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+)
+
+// machinePathPrefix marks a path on the machines, as opposed to a local path.
+const machinePathPrefix = ":"
+
+// pullDestination returns the local file that the file pulled from the
+// machine is written to. If the destination is a directory, the file is
+// written to it, in a subdirectory named for the machine if several machines
+// are selected.
+func pullDestination(dst, src, ip string, machines int) (string, error) {
+	fi, err := os.Stat(dst)
+	switch {
+	case err == nil && fi.IsDir():
+		if machines > 1 {
+			dst = filepath.Join(dst, ip)
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return "", fmt.Errorf("unable to create directory %q: %v", dst, err)
+			}
+		}
+		return filepath.Join(dst, path.Base(src)), nil
+	case machines > 1:
+		return "", fmt.Errorf("destination %q must be a directory when copying from more than one machine", dst)
+	default:
+		return dst, nil
+	}
+}
+
+// cpCmd represents the cp command
+var cpCmd = &cobra.Command{
+	Use:   "cp (--ip IP | --role ROLE | --all) SRC DST",
+	Short: "Copy files to or from machines of the cluster",
+	Long: `Copy a file to or from machines of the cluster, using their stored SSH
+credentials. A path on the machines is prefixed with a colon. For example,
+"cctl cp --role node ./file :/tmp/file" pushes a local file to every node,
+and "cctl cp --all :/etc/hosts ./hosts" pulls a file from every machine into
+a subdirectory of ./hosts named for the machine.`,
+	Args: cobra.ExactArgs(2),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		InitState()
+		// PersistentPreRuns are not chained https://github.com/spf13/cobra/issues/216
+		// Therefore LogLevel must be set in all the PersistentPreRuns
+		if err := log.SetLogLevelUsingString(LogLevel); err != nil {
+			log.Fatalf("Unable to parse log level %s", LogLevel)
+		}
+		preflightValidateState()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		src, dst := args[0], args[1]
+		push := strings.HasPrefix(dst, machinePathPrefix)
+		if push == strings.HasPrefix(src, machinePathPrefix) {
+			log.Fatalf("Exactly one of the source and the destination must be a path on the machines, prefixed with %q", machinePathPrefix)
+		}
+		parallel, err := cmd.Flags().GetInt("parallel")
+		if err != nil {
+			log.Fatalf("Unable to parse --parallel flag: %v", err)
+		}
+		if parallel < 1 {
+			log.Fatalf("Parallel must be at least 1")
+		}
+		machines, err := selectedMachines(cmd)
+		if err != nil {
+			log.Fatalf("Unable to select machines: %v", err)
+		}
+		var results []fanOutResult
+		if push {
+			dst = strings.TrimPrefix(dst, machinePathPrefix)
+			fi, err := os.Stat(src)
+			if err != nil {
+				log.Fatalf("Unable to read %q: %v", src, err)
+			}
+			b, err := ioutil.ReadFile(src)
+			if err != nil {
+				log.Fatalf("Unable to read %q: %v", src, err)
+			}
+			results = fanOut(machines, parallel, sshMachineClientForMachine, func(machineClient sshmachine.Client, result *fanOutResult) {
+				result.Err = machineClient.WriteFile(dst, fi.Mode().Perm(), b)
+			})
+		} else {
+			src = strings.TrimPrefix(src, machinePathPrefix)
+			results = fanOut(machines, parallel, sshMachineClientForMachine, func(machineClient sshmachine.Client, result *fanOutResult) {
+				b, err := machineClient.ReadFile(src)
+				if err != nil {
+					result.Err = err
+					return
+				}
+				filename, err := pullDestination(dst, src, result.IP, len(machines))
+				if err != nil {
+					result.Err = err
+					return
+				}
+				result.Err = ioutil.WriteFile(filename, b, 0600)
+			})
+		}
+		printFanOutResults(results)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cpCmd)
+	addMachineSelectionFlags(cpCmd)
+}
@@ -35,6 +35,7 @@ var createCmd = &cobra.Command{
 		if err := log.SetLogLevelUsingString(LogLevel); err != nil {
 			log.Fatalf("Unable to parse log level %s", LogLevel)
 		}
+		preflightValidateState()
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("create called")
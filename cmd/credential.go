@@ -17,66 +17,223 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
 
 	log "github.com/platform9/cctl/pkg/logrus"
 
 	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/credential"
+	"github.com/platform9/cctl/pkg/machineclient"
 
 	"github.com/spf13/cobra"
-	corev1 "k8s.io/api/core/v1"
+	"golang.org/x/crypto/ssh"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// passphrases and sudoPasswords hold the passphrases of encrypted private
+// keys and the sudo passwords of credentials in memory for the lifetime of
+// the command. They are never written to the state file.
+var (
+	passphrases   = &credential.Passphrases{}
+	sudoPasswords = &credential.Passphrases{EnvVar: credential.SudoPasswordEnvVar, Description: "sudo password"}
+)
+
+// getCredential returns the named SSH credential.
+func getCredential(name string) (*credential.Credential, error) {
+	secret, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("unable to find SSH credential %q", name)
+		}
+		return nil, fmt.Errorf("unable to get SSH credential secret: %v", err)
+	}
+	return credential.FromSecret(secret)
+}
+
+// credentialEndpoint returns an endpoint that logs in, and gains root
+// privileges, with the named SSH credential. The caller sets the host and
+// its keys.
+func credentialEndpoint(name string) (machineclient.Endpoint, error) {
+	c, err := getCredential(name)
+	if err != nil {
+		return machineclient.Endpoint{}, err
+	}
+	auth, err := c.AuthMethod(passphrases)
+	if err != nil {
+		return machineclient.Endpoint{}, err
+	}
+	return machineclient.Endpoint{
+		Username:   c.Username,
+		Auth:       []ssh.AuthMethod{auth},
+		Escalation: c.Escalation(sudoPasswords),
+	}, nil
+}
+
+// provisionedMachinesUsingCredential returns the names of the provisioned
+// machines that reach their hosts using the named credential.
+func provisionedMachinesUsingCredential(name string) ([]string, error) {
+	pmList, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list provisioned machines: %v", err)
+	}
+	var names []string
+	for _, pm := range pmList.Items {
+		if pm.Spec.SSHConfig != nil && pm.Spec.SSHConfig.CredentialSecret.Name == name {
+			names = append(names, pm.Name)
+		}
+	}
+	return names, nil
+}
+
 var credentialCmdCreate = &cobra.Command{
 	Use:   "credential",
 	Short: "Create new SSH credential",
 	Run: func(cmd *cobra.Command, args []string) {
+		name := cmd.Flag("name").Value.String()
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+			log.Fatalf("Invalid credential name %q: %s", name, strings.Join(errs, ", "))
+		}
 		privateKeyFilename := cmd.Flag("private-key").Value.String()
-		privateKeyBytes, err := ioutil.ReadFile(privateKeyFilename)
+		useAgent, err := cmd.Flags().GetBool("agent")
 		if err != nil {
-			log.Fatalf("Failed to read private key from %q: %v", privateKeyFilename, err)
-		}
-		secret := corev1.Secret{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "Secret",
-				APIVersion: "v1",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name:              common.DefaultSSHCredentialSecretName,
-				Namespace:         common.DefaultNamespace,
-				CreationTimestamp: metav1.Now(),
-			},
-			Data: map[string][]byte{
-				"username":       []byte(cmd.Flag("user").Value.String()),
-				"ssh-privatekey": privateKeyBytes,
-			},
-		}
-		if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(&secret); err != nil {
+			log.Fatalf("Unable to parse --agent: %v", err)
+		}
+		if useAgent == (len(privateKeyFilename) != 0) {
+			log.Fatalf("Specify exactly one of --private-key or --agent.")
+		}
+		c := credential.Credential{
+			Name:     name,
+			Username: cmd.Flag("user").Value.String(),
+			Type:     credential.TypeAgent,
+			Become:   cmd.Flag("become").Value.String(),
+		}
+		if err := machineclient.ValidateEscalationMethod(c.Become); err != nil {
+			log.Fatalf("Invalid --become: %v", err)
+		}
+		if !useAgent {
+			c.Type = credential.TypePrivateKey
+			if c.PrivateKey, err = ioutil.ReadFile(privateKeyFilename); err != nil {
+				log.Fatalf("Failed to read private key from %q: %v", privateKeyFilename, err)
+			}
+			// Check that the key can be used, e.g. that the passphrase of an
+			// encrypted key is correct, before it is stored.
+			if _, err := c.Signers(passphrases); err != nil {
+				log.Fatalf("Unable to use private key %q: %v", privateKeyFilename, err)
+			}
+		}
+		secret := c.Secret(clusterNamespace)
+		jumpHosts, err := jumpHostsFromFlag(cmd)
+		if err != nil {
+			log.Fatalf("Unable to load jump hosts: %v", err)
+		}
+		if err := setJumpHostsAnnotation(&secret.ObjectMeta, jumpHosts); err != nil {
+			log.Fatalf("Unable to record jump hosts: %v", err)
+		}
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(secret); err != nil {
 			if apierrors.IsAlreadyExists(err) {
-				log.Fatalf("Credential already exists. To create a new credential, first delete the existing one.")
+				log.Fatalf("Credential %q already exists. To replace it, first delete the existing one, or create a credential with another name.", name)
 			}
 			log.Fatalf("Unable to create ssh credential secret: %v", err)
 		}
-		log.Printf("Created ssh credential: user %q and private key %q", cmd.Flag("user").Value.String(), cmd.Flag("private-key").Value.String())
+		if useAgent {
+			log.Printf("Created ssh credential %q: user %q and keys of the SSH agent", name, c.Username)
+		} else {
+			log.Printf("Created ssh credential %q: user %q and private key %q", name, c.Username, privateKeyFilename)
+		}
 		if err := state.PullFromAPIs(); err != nil {
 			log.Fatalf("Unable to sync on-disk state: %v", err)
 		}
 	},
 }
 
+var credentialCmdGet = &cobra.Command{
+	Use:   "credentials",
+	Short: "List SSH credentials",
+	Run: func(cmd *cobra.Command, args []string) {
+		secretList, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			log.Fatalf("Unable to list secrets: %v", err)
+		}
+		type credentialSummary struct {
+			Name     string
+			User     string
+			Type     string
+			Become   string
+			Machines int
+		}
+		summaries := []credentialSummary{}
+		for i := range secretList.Items {
+			secret := &secretList.Items[i]
+			if !credential.IsCredential(secret) {
+				continue
+			}
+			c, err := credential.FromSecret(secret)
+			if err != nil {
+				log.Fatalf("Unable to read SSH credential: %v", err)
+			}
+			machines, err := provisionedMachinesUsingCredential(secret.Name)
+			if err != nil {
+				log.Fatalf("Unable to find machines using credential %q: %v", secret.Name, err)
+			}
+			summaries = append(summaries, credentialSummary{
+				Name:     c.Name,
+				User:     c.Username,
+				Type:     c.Type,
+				Become:   c.Become,
+				Machines: len(machines),
+			})
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		t := template.Must(template.New("CredentialListPrintTemplate").Parse(common.CredentialListPrintTemplate))
+		if err := t.Execute(w, summaries); err != nil {
+			log.Fatalf("Could not pretty print credentials: %s", err)
+		}
+		w.Flush()
+	},
+}
+
 var credentialCmdDelete = &cobra.Command{
 	Use:   "credential",
 	Short: "Delete SSH credential",
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Delete(common.DefaultSSHCredentialSecretName, &metav1.DeleteOptions{}); err != nil {
+		name := cmd.Flag("name").Value.String()
+		secret, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(name, metav1.GetOptions{})
+		if err != nil {
 			if apierrors.IsNotFound(err) {
-				log.Fatal("SSH credential dooes not exist.")
+				log.Fatalf("SSH credential %q does not exist.", name)
 			}
+			log.Fatalf("Unable to get ssh credential secret: %v", err)
+		}
+		if !credential.IsCredential(secret) {
+			log.Fatalf("Secret %q is not an SSH credential.", name)
+		}
+		machines, err := provisionedMachinesUsingCredential(name)
+		if err != nil {
+			log.Fatalf("Unable to find machines using credential %q: %v", name, err)
+		}
+		if len(machines) != 0 {
+			log.Fatalf("SSH credential %q is used by machines %s. Delete the machines before deleting the credential.", name, strings.Join(machines, ", "))
+		}
+		jumpHostUsers, err := jumpHostUsersOfCredential(name)
+		if err != nil {
+			log.Fatalf("Unable to find jump hosts using credential %q: %v", name, err)
+		}
+		if len(jumpHostUsers) != 0 {
+			log.Fatalf("SSH credential %q is used by the jump hosts of %s. Machines behind these jump hosts would become unreachable. Remove the jump hosts before deleting the credential.", name, strings.Join(jumpHostUsers, ", "))
+		}
+		if err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
 			log.Fatalf("Unable to delete ssh credential secret: %v", err)
 		}
-		log.Println("Deleted ssh credential")
+		log.Printf("Deleted ssh credential %q", name)
 		if err := state.PullFromAPIs(); err != nil {
 			log.Fatalf("Unable to sync on-disk state: %v", err)
 		}
@@ -85,9 +242,15 @@ var credentialCmdDelete = &cobra.Command{
 
 func init() {
 	createCmd.AddCommand(credentialCmdCreate)
+	credentialCmdCreate.Flags().String("name", common.DefaultSSHCredentialSecretName, "Name of the credential")
 	credentialCmdCreate.Flags().String("user", "root", "SSH username")
-	credentialCmdCreate.Flags().String("private-key", "", "SSH privateKey file location")
-	credentialCmdCreate.MarkFlagRequired("private-key")
+	credentialCmdCreate.Flags().String("private-key", "", "SSH privateKey file location. If the key is encrypted, its passphrase is read from $"+credential.PassphraseEnvVar+", or else prompted for, and is not stored")
+	credentialCmdCreate.Flags().Bool("agent", false, "Authenticate with the keys of the SSH agent at $"+credential.AgentSocketEnvVar+", and store no key")
+	credentialCmdCreate.Flags().String("become", machineclient.EscalationSudo, fmt.Sprintf("How the user gains root privileges: %s, %s (sudo without a password), or %s (sudo with a password read from $%s, or else prompted for, and not stored)", machineclient.EscalationNone, machineclient.EscalationSudo, machineclient.EscalationSudoPassword, credential.SudoPasswordEnvVar))
+	credentialCmdCreate.Flags().String("jump-hosts-file", "", "File listing the jump hosts through which machines using this credential are reached")
+
+	getCmd.AddCommand(credentialCmdGet)
 
 	deleteCmd.AddCommand(credentialCmdDelete)
+	credentialCmdDelete.Flags().String("name", common.DefaultSSHCredentialSecretName, "Name of the credential")
 }
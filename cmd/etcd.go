@@ -40,6 +40,7 @@ import (
 	setsutil "github.com/platform9/ssh-provider/pkg/util/sets"
 
 	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/etcdcluster"
 	capiutil "github.com/platform9/cctl/pkg/util/clusterapi"
 )
 
@@ -53,7 +54,7 @@ var recoverEtcdCmd = &cobra.Command{
 		}
 		remotePath := fmt.Sprintf("%s-%s", "/tmp/cctl-etcd-snapshot", uuid.NewV4().String())
 
-		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
+		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				log.Fatalf("No cluster found. Create a cluster before creating a machine.")
@@ -64,14 +65,14 @@ var recoverEtcdCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Unable to decode cluster spec: %v", err)
 		}
-		etcdCASecret, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Get(clusterProviderSpec.EtcdCASecret.Name, metav1.GetOptions{})
+		etcdCASecret, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(clusterProviderSpec.EtcdCASecret.Name, metav1.GetOptions{})
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				log.Fatalf("Unable to get etcd CA secret: %v", err)
 			}
 		}
 
-		machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).List(metav1.ListOptions{})
+		machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
 		if err != nil {
 			log.Fatalf("Unable to list machines: %v", err)
 		}
@@ -154,10 +155,10 @@ func recoverEtcd(localPath, remotePath string, etcdCASecret *corev1.Secret, clus
 		return fmt.Errorf("error reading etcd member data from machine %q: %v", firstMWC.Machine.Name, err)
 	}
 	if err := updateMachineEtcdMember(firstEtcdMember, &firstMWC.Machine); err != nil {
-		return fmt.Errorf("unable to update machine %q status with etcd member %q: %v", firstMWC.Machine.Name, firstEtcdMember, err)
+		return fmt.Errorf("unable to update machine %q status with etcd member %q: %v", firstMWC.Machine.Name, firstEtcdMember.Name, err)
 	}
 	if err := insertClusterEtcdMember(firstEtcdMember, cluster); err != nil {
-		return fmt.Errorf("unable to update cluster status with etcd member %q: %v", firstEtcdMember, err)
+		return fmt.Errorf("unable to update cluster status with etcd member %q: %v", firstEtcdMember.Name, err)
 	}
 
 	// Delete the temporary file
@@ -181,10 +182,10 @@ func recoverEtcd(localPath, remotePath string, etcdCASecret *corev1.Secret, clus
 			return fmt.Errorf("error reading etcd member data from machine %q: %v", mwc.Machine.Name, err)
 		}
 		if err := updateMachineEtcdMember(etcdMember, &mwc.Machine); err != nil {
-			return fmt.Errorf("unable to update machine %q status with etcd member %q: %v", mwc.Machine.Name, etcdMember, err)
+			return fmt.Errorf("unable to update machine %q status with etcd member %q: %v", mwc.Machine.Name, etcdMember.Name, err)
 		}
 		if err := insertClusterEtcdMember(etcdMember, cluster); err != nil {
-			return fmt.Errorf("unable to update cluster status with etcd member %q: %v", etcdMember, err)
+			return fmt.Errorf("unable to update cluster status with etcd member %q: %v", etcdMember.Name, err)
 		}
 	}
 
@@ -224,7 +225,7 @@ func insertClusterEtcdMember(etcdMember spv1.EtcdMember, cluster *clusterv1.Clus
 	if err := sputil.PutClusterStatus(*clusterStatus, cluster); err != nil {
 		return fmt.Errorf("unable to encode cluster status: %v", err)
 	}
-	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).UpdateStatus(cluster); err != nil {
+	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).UpdateStatus(cluster); err != nil {
 		return fmt.Errorf("unable to update cluster: %v", err)
 	}
 	return nil
@@ -241,7 +242,7 @@ func removeClusterEtcdMember(etcdMember spv1.EtcdMember, cluster *clusterv1.Clus
 	if err := sputil.PutClusterStatus(*clusterStatus, cluster); err != nil {
 		return fmt.Errorf("unable to encode cluster status: %v", err)
 	}
-	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).UpdateStatus(cluster); err != nil {
+	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).UpdateStatus(cluster); err != nil {
 		return fmt.Errorf("unable to update cluster: %v", err)
 	}
 	return nil
@@ -409,7 +410,7 @@ var snapshotEtcdCmd = &cobra.Command{
 		}
 		remotePath := fmt.Sprintf("%s-%s", "/tmp/cctl-etcd-snapshot", uuid.NewV4().String())
 
-		machine, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).Get(ip, metav1.GetOptions{})
+		machine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(ip, metav1.GetOptions{})
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				log.Fatalf("Machine %q not found", ip)
@@ -443,7 +444,7 @@ var snapshotEtcdCmd = &cobra.Command{
 }
 
 func createSnapshot(remotePath string, client sshmachine.Client) error {
-	cmd := fmt.Sprintf("%s snapshot save %s", "/opt/bin/etcdctl.sh", remotePath)
+	cmd := fmt.Sprintf("%s snapshot save %s", etcdcluster.EtcdctlPath, remotePath)
 	stdOut, stdErr, err := client.RunCommand(cmd)
 	if err != nil {
 		return fmt.Errorf("error running %q: %v (stdout: %q, stderr: %q)", cmd, err, string(stdOut), string(stdErr))
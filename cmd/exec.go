@@ -0,0 +1,287 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"github.com/platform9/cctl/common"
+	log "github.com/platform9/cctl/pkg/logrus"
+	"github.com/platform9/cctl/pkg/machineclient"
+
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+
+	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// addMachineSelectionFlags adds the flags that select the machines a command
+// runs on.
+func addMachineSelectionFlags(cmd *cobra.Command) {
+	cmd.Flags().String("ip", "", "IP of the machine")
+	cmd.Flags().String("role", "", fmt.Sprintf("Role of the machines, %q or %q", strings.ToLower(string(clustercommon.MasterRole)), strings.ToLower(string(clustercommon.NodeRole))))
+	cmd.Flags().Bool("all", false, "All machines of the cluster")
+	cmd.Flags().Int("parallel", common.DefaultMachineParallelism, "Maximum number of machines to run on at a time")
+}
+
+// selectedMachines returns the machines chosen with the --ip, --role or --all
+// flag of the command, sorted by name.
+func selectedMachines(cmd *cobra.Command) ([]clusterv1.Machine, error) {
+	ip := cmd.Flag("ip").Value.String()
+	role := cmd.Flag("role").Value.String()
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse --all flag: %v", err)
+	}
+	selections := 0
+	for _, selected := range []bool{len(ip) != 0, len(role) != 0, all} {
+		if selected {
+			selections++
+		}
+	}
+	if selections != 1 {
+		return nil, fmt.Errorf("exactly one of --ip, --role and --all must be given")
+	}
+	if len(ip) != 0 {
+		machine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(ip, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get machine %q: %v", ip, err)
+		}
+		return []clusterv1.Machine{*machine}, nil
+	}
+	machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list machines: %v", err)
+	}
+	machines := machineList.Items
+	if len(role) != 0 {
+		var machineRole clustercommon.MachineRole
+		switch {
+		case strings.EqualFold(role, string(clustercommon.MasterRole)):
+			machineRole = clustercommon.MasterRole
+		case strings.EqualFold(role, string(clustercommon.NodeRole)):
+			machineRole = clustercommon.NodeRole
+		default:
+			return nil, fmt.Errorf("machine role %q is not supported, must be %q or %q", role, strings.ToLower(string(clustercommon.MasterRole)), strings.ToLower(string(clustercommon.NodeRole)))
+		}
+		machines = nil
+		for _, machine := range machineList.Items {
+			if machineHasRole(machine, machineRole) {
+				machines = append(machines, machine)
+			}
+		}
+	}
+	if len(machines) == 0 {
+		return nil, fmt.Errorf("no machines selected")
+	}
+	sort.Slice(machines, func(i, j int) bool { return machines[i].Name < machines[j].Name })
+	return machines, nil
+}
+
+func machineHasRole(machine clusterv1.Machine, role clustercommon.MachineRole) bool {
+	for _, r := range machine.Spec.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// sshMachineClientForMachine returns a client of the machine, using the SSH
+// configuration of its provisioned machine.
+func sshMachineClientForMachine(machine *clusterv1.Machine) (sshmachine.Client, error) {
+	machineSpec, err := sputil.GetMachineSpec(*machine)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode machine %q spec: %v", machine.Name, err)
+	}
+	pm, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(machineSpec.ProvisionedMachineName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get provisioned machine %q: %v", machineSpec.ProvisionedMachineName, err)
+	}
+	machineClient, err := sshMachineClientFromSSHConfig(pm.Spec.SSHConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create machine client for machine %q: %v", machine.Name, err)
+	}
+	return machineClient, nil
+}
+
+// fanOutResult is the outcome of running on one machine.
+type fanOutResult struct {
+	IP     string
+	Roles  string
+	Stdout []byte
+	Stderr []byte
+	Err    error
+}
+
+// Exit is the exit status of the command, or a dash if the command did not
+// run to completion.
+func (r fanOutResult) Exit() string {
+	if r.Err == nil {
+		return "0"
+	}
+	if commandErr, ok := r.Err.(*machineclient.CommandError); ok {
+		return fmt.Sprintf("%d", commandErr.ExitStatus)
+	}
+	return "-"
+}
+
+// Result describes the outcome for the summary table.
+func (r fanOutResult) Result() string {
+	if r.Err != nil {
+		return fmt.Sprintf("failed: %v", r.Err)
+	}
+	return "succeeded"
+}
+
+// fanOut connects to every machine with newClient, and calls run with the
+// client of the machine, on at most parallel machines at a time. The results
+// are in the order of the machines.
+func fanOut(machines []clusterv1.Machine, parallel int, newClient func(machine *clusterv1.Machine) (sshmachine.Client, error), run func(machineClient sshmachine.Client, result *fanOutResult)) []fanOutResult {
+	results := make([]fanOutResult, len(machines))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := range machines {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			machine := &machines[i]
+			roles := []string{}
+			for _, role := range machine.Spec.Roles {
+				roles = append(roles, string(role))
+			}
+			results[i] = fanOutResult{IP: machine.Name, Roles: strings.Join(roles, ",")}
+			machineClient, err := newClient(machine)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			run(machineClient, &results[i])
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// writePrefixed writes every line of the output, prefixed with the IP of the
+// machine.
+func writePrefixed(w io.Writer, ip string, output []byte) {
+	if len(output) == 0 {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(output), "\n"), "\n") {
+		fmt.Fprintf(w, "%s: %s\n", ip, line)
+	}
+}
+
+// printFanOutResults prints the output of every machine, then a summary, and
+// exits with a non-zero status if the command failed on any machine.
+func printFanOutResults(results []fanOutResult) {
+	if !writeFanOutResults(os.Stdout, os.Stderr, results) {
+		os.Exit(1)
+	}
+}
+
+// writeFanOutResults writes the output of every machine, then a summary. It
+// returns false if the command failed on any machine.
+func writeFanOutResults(stdout, stderr io.Writer, results []fanOutResult) bool {
+	for _, result := range results {
+		writePrefixed(stdout, result.IP, result.Stdout)
+		writePrefixed(stderr, result.IP, result.Stderr)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 3, ' ', 0)
+	t := template.Must(template.New("FanOutResultPrintTemplate").Parse(common.FanOutResultPrintTemplate))
+	if err := t.Execute(w, results); err != nil {
+		log.Fatalf("Could not pretty print results: %s", err)
+	}
+	w.Flush()
+	for _, result := range results {
+		if result.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// execCommand returns the shell command that runs the arguments, each as one
+// word.
+func execCommand(args []string) string {
+	words := make([]string, len(args))
+	for i, arg := range args {
+		words[i] = machineclient.ShellQuote(arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec (--ip IP | --role ROLE | --all) -- COMMAND [ARGS...]",
+	Short: "Run a command on machines of the cluster",
+	Long: `Run a command with root privileges on machines of the cluster, using their
+stored SSH credentials. The output of every machine is prefixed with its IP,
+followed by the exit status of the command on every machine.
+
+Every argument is passed to the command as one word. To use pipes, redirection
+or other shell syntax, run a shell, e.g. -- sh -c 'dmesg | tail'.`,
+	Args: cobra.MinimumNArgs(1),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		InitState()
+		// PersistentPreRuns are not chained https://github.com/spf13/cobra/issues/216
+		// Therefore LogLevel must be set in all the PersistentPreRuns
+		if err := log.SetLogLevelUsingString(LogLevel); err != nil {
+			log.Fatalf("Unable to parse log level %s", LogLevel)
+		}
+		preflightValidateState()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		parallel, err := cmd.Flags().GetInt("parallel")
+		if err != nil {
+			log.Fatalf("Unable to parse --parallel flag: %v", err)
+		}
+		if parallel < 1 {
+			log.Fatalf("Parallel must be at least 1")
+		}
+		machines, err := selectedMachines(cmd)
+		if err != nil {
+			log.Fatalf("Unable to select machines: %v", err)
+		}
+		command := execCommand(args)
+		results := fanOut(machines, parallel, sshMachineClientForMachine, func(machineClient sshmachine.Client, result *fanOutResult) {
+			result.Stdout, result.Stderr, result.Err = machineClient.RunCommand(command)
+		})
+		printFanOutResults(results)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	addMachineSelectionFlags(execCmd)
+}
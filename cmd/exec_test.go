@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"github.com/platform9/cctl/pkg/machineclient"
+)
+
+// fakeMachineClient runs commands by returning a fixed result.
+type fakeMachineClient struct {
+	sshmachine.Client
+	stdout, stderr []byte
+	err            error
+}
+
+func (c *fakeMachineClient) RunCommand(cmd string) ([]byte, []byte, error) {
+	return c.stdout, c.stderr, c.err
+}
+
+func TestExecCommand(t *testing.T) {
+	command := execCommand([]string{"grep", "a b", "/etc/hosts", "it's"})
+	expected := `'grep' 'a b' '/etc/hosts' 'it'"'"'s'`
+	if command != expected {
+		t.Fatalf("Expected command %s, found %s", expected, command)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	clients := map[string]*fakeMachineClient{
+		"10.0.0.1": {stdout: []byte("hello\nworld\n")},
+		"10.0.0.2": {stderr: []byte("oops\n"), err: &machineclient.CommandError{ExitStatus: 2, Err: fmt.Errorf("exit status 2")}},
+	}
+	newClient := func(machine *clusterv1.Machine) (sshmachine.Client, error) {
+		if client, ok := clients[machine.Name]; ok {
+			return client, nil
+		}
+		return nil, fmt.Errorf("unable to connect")
+	}
+	machines := []clusterv1.Machine{}
+	for _, name := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		machines = append(machines, clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       clusterv1.MachineSpec{Roles: []clustercommon.MachineRole{clustercommon.NodeRole}},
+		})
+	}
+	results := fanOut(machines, 2, newClient, func(machineClient sshmachine.Client, result *fanOutResult) {
+		result.Stdout, result.Stderr, result.Err = machineClient.RunCommand("hostname")
+	})
+	if len(results) != len(machines) {
+		t.Fatalf("Expected %d results, found %d", len(machines), len(results))
+	}
+	for i, exit := range []string{"0", "2", "-"} {
+		if results[i].IP != machines[i].Name || results[i].Roles != "Node" {
+			t.Errorf("Expected result %d for machine %q, found %+v", i, machines[i].Name, results[i])
+		}
+		if results[i].Exit() != exit {
+			t.Errorf("Expected exit %q for machine %q, found %q", exit, results[i].IP, results[i].Exit())
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if writeFanOutResults(&stdout, &stderr, results) {
+		t.Errorf("Expected the fan out to fail when the command fails on any machine")
+	}
+	for _, line := range []string{"10.0.0.1: hello", "10.0.0.1: world", "10.0.0.2   Node    2      failed: command failed: exit status 2", "10.0.0.3   Node    -      failed: unable to connect"} {
+		if !strings.Contains(stdout.String(), line) {
+			t.Errorf("Expected output to contain %q, found:\n%s", line, stdout.String())
+		}
+	}
+	if stderr.String() != "10.0.0.2: oops\n" {
+		t.Errorf("Expected stderr %q, found %q", "10.0.0.2: oops\n", stderr.String())
+	}
+
+	stdout.Reset()
+	if !writeFanOutResults(&stdout, os.Stderr, results[:1]) {
+		t.Errorf("Expected the fan out to succeed when the command succeeds on every machine")
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	log "github.com/platform9/cctl/pkg/logrus"
+	"github.com/platform9/cctl/pkg/manifests"
+	cctlstate "github.com/platform9/cctl/pkg/state/v2"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Used to export resources from the state",
+	Args:  cobra.MinimumNArgs(1),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		InitState()
+		// PersistentPreRuns are not chained https://github.com/spf13/cobra/issues/216
+		// Therefore LogLevel must be set in all the PersistentPreRuns
+		if err := log.SetLogLevelUsingString(LogLevel); err != nil {
+			log.Fatalf("Unable to parse log level %s", LogLevel)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Printf("Unknown resource %q. Use --help to print available options", args[0])
+	},
+}
+
+var manifestsCmdExport = &cobra.Command{
+	Use:   "manifests",
+	Short: "Write the cluster objects as Cluster API manifests",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := cmd.Flag("dir").Value.String()
+		redactSecrets, err := cmd.Flags().GetBool("redact-secrets")
+		if err != nil {
+			log.Fatalf("Unable to parse `redact-secrets`: %v", err)
+		}
+
+		objs := manifests.Objects{Namespace: clusterNamespace}
+		clusterList, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			log.Fatalf("Unable to list clusters: %v", err)
+		}
+		objs.Clusters = clusterList.Items
+		machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			log.Fatalf("Unable to list machines: %v", err)
+		}
+		objs.Machines = machineList.Items
+		pmList, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			log.Fatalf("Unable to list provisioned machines: %v", err)
+		}
+		objs.ProvisionedMachines = pmList.Items
+		secretList, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			log.Fatalf("Unable to list secrets: %v", err)
+		}
+		objs.Secrets = secretList.Items
+		if len(objs.Clusters) == 0 {
+			log.Fatalf("No cluster found in namespace %q.", clusterNamespace)
+		}
+
+		ms, err := manifests.Generate(objs, manifests.Options{RedactSecrets: redactSecrets})
+		if err != nil {
+			log.Fatalf("Unable to generate manifests: %v", err)
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Fatalf("Unable to create directory %q: %v", dir, err)
+		}
+		for _, m := range ms {
+			filename := filepath.Join(dir, m.Filename)
+			if err := writeFileAtomic(filename, m.Content); err != nil {
+				log.Fatalf("Unable to write manifest %q: %v", filename, err)
+			}
+		}
+		if !redactSecrets && len(objs.Secrets) != 0 {
+			log.Warnf("The manifests in %q include secrets in plain text. Use --redact-secrets to omit them.", dir)
+		}
+		log.Printf("Wrote %d manifests to %q.", len(ms), dir)
+	},
+}
+
+// writeFileAtomic writes the file, readable only by its owner, the way the
+// state file is written: through a temporary file that is synced to disk and
+// renamed over the file.
+func writeFileAtomic(filename string, data []byte) error {
+	store := cctlstate.NewFileStore(filename)
+	store.Backups = 0
+	return store.Write(data)
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(manifestsCmdExport)
+	manifestsCmdExport.Flags().String("dir", "", "Directory to write the manifests to")
+	manifestsCmdExport.MarkFlagRequired("dir")
+	manifestsCmdExport.Flags().Bool("redact-secrets", false, "Omit the values of secrets from the manifests")
+}
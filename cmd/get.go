@@ -20,6 +20,7 @@ import (
 	log "github.com/platform9/cctl/pkg/logrus"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var outputFmt string
@@ -44,5 +45,12 @@ var getCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(getCmd)
-	getCmd.PersistentFlags().StringVar(&outputFmt, "o", "", "Output format yaml|json")
+	getCmd.PersistentFlags().StringVarP(&outputFmt, "output", "o", "", "Output format yaml|json; machines also support wide|jsonpath=<template>|go-template=<template>")
+	// Keep accepting --o, the name of the flag before it had a shorthand
+	getCmd.SetGlobalNormalizationFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "o" {
+			name = "output"
+		}
+		return pflag.NormalizedName(name)
+	})
 }
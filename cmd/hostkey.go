@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	log "github.com/platform9/cctl/pkg/logrus"
+	"github.com/platform9/cctl/pkg/machineclient"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	knownHostsFile  string
+	trustOnFirstUse bool
+
+	knownHostsOnce sync.Once
+	knownHosts     *machineclient.KnownHosts
+	knownHostsErr  error
+)
+
+// loadKnownHosts returns the known_hosts file given with --known-hosts, or
+// nil if the flag is not given.
+func loadKnownHosts() (*machineclient.KnownHosts, error) {
+	if len(knownHostsFile) == 0 {
+		return nil, nil
+	}
+	knownHostsOnce.Do(func() {
+		knownHosts, knownHostsErr = machineclient.LoadKnownHosts(knownHostsFile)
+	})
+	return knownHosts, knownHostsErr
+}
+
+// setHostKeyVerification configures how the endpoint verifies its host key.
+// An endpoint without public keys is verified against the known_hosts file,
+// if one is given, or else not at all.
+func setHostKeyVerification(e *machineclient.Endpoint, description string) error {
+	if len(e.PublicKeys) != 0 {
+		return nil
+	}
+	kh, err := loadKnownHosts()
+	if err != nil {
+		return err
+	}
+	if kh != nil {
+		e.KnownHosts = kh
+		return nil
+	}
+	e.InsecureIgnoreHostKey = true
+	log.Printf("Not able to verify %s SSH identity: No public keys given. Continuing...", description)
+	return nil
+}
+
+// hostKeyFingerprints returns the fingerprints of the public keys, which are
+// in authorized_keys format.
+func hostKeyFingerprints(publicKeys []string) []string {
+	fingerprints := []string{}
+	for _, publicKey := range publicKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+		if err != nil {
+			fingerprints = append(fingerprints, fmt.Sprintf("unparseable key %q", publicKey))
+			continue
+		}
+		fingerprints = append(fingerprints, machineclient.Fingerprint(key))
+	}
+	return fingerprints
+}
+
+// trustHostKeysOnFirstUse scans the host keys of the machine, and records
+// them in the SSH config and in the provisioned machine of the host, if one
+// exists. Later connections then accept only these keys.
+func trustHostKeysOnFirstUse(sshConfig *spv1.SSHConfig, jumpHostEndpoints []machineclient.Endpoint) error {
+	keys, err := machineclient.ScanHostKeys(machineclient.Endpoint{Host: sshConfig.Host, Port: sshConfig.Port}, jumpHostEndpoints)
+	if err != nil {
+		return err
+	}
+	publicKeys := make([]string, len(keys))
+	for i, key := range keys {
+		publicKeys[i] = string(ssh.MarshalAuthorizedKey(key))
+	}
+	log.Printf("Trusting SSH host keys of %q on first use: %s", sshConfig.Host, strings.Join(hostKeyFingerprints(publicKeys), ", "))
+	sshConfig.PublicKeys = publicKeys
+	return recordHostKeys(sshConfig.Host, sshConfig.Port, publicKeys)
+}
+
+// recordHostKeys records the public keys in the provisioned machine of the
+// host, if one exists.
+func recordHostKeys(host string, port int, publicKeys []string) error {
+	pm, err := provisionedMachineForHost(host, port)
+	if err != nil || pm == nil {
+		return err
+	}
+	pm.Spec.SSHConfig.PublicKeys = publicKeys
+	if _, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Update(pm); err != nil {
+		return fmt.Errorf("unable to update provisioned machine %q: %v", pm.Name, err)
+	}
+	return syncState()
+}
+
+// provisionedMachineForHost returns the provisioned machine reached at the
+// host and port, or nil if there is none.
+func provisionedMachineForHost(host string, port int) (*spv1.ProvisionedMachine, error) {
+	pmList, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list provisioned machines: %v", err)
+	}
+	for i := range pmList.Items {
+		pm := &pmList.Items[i]
+		if pm.Spec.SSHConfig != nil && pm.Spec.SSHConfig.Host == host && pm.Spec.SSHConfig.Port == port {
+			return pm, nil
+		}
+	}
+	return nil, nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&knownHostsFile, "known-hosts", "", "OpenSSH known_hosts file used to verify the SSH host keys of machines and jump hosts that have no recorded public keys")
+	rootCmd.PersistentFlags().BoolVar(&trustOnFirstUse, "trust-on-first-use", false, "record the SSH host keys of a machine that has no recorded public keys on first contact, and accept only those keys afterwards")
+}
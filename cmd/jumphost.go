@@ -0,0 +1,219 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/platform9/cctl/pkg/machineclient"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// jumpHostsFromFlag returns the jump hosts listed in the file given with
+// --jump-hosts-file, or nil if the flag is not given.
+func jumpHostsFromFlag(cmd *cobra.Command) ([]machineclient.JumpHost, error) {
+	filename := cmd.Flag("jump-hosts-file").Value.String()
+	if len(filename) == 0 {
+		return nil, nil
+	}
+	return machineclient.LoadJumpHosts(filename)
+}
+
+// setJumpHostsAnnotation records the jump hosts in the annotations of the
+// object, unless there are none.
+func setJumpHostsAnnotation(objectMeta *metav1.ObjectMeta, jumpHosts []machineclient.JumpHost) error {
+	if len(jumpHosts) == 0 {
+		return nil
+	}
+	value, err := machineclient.JumpHostsAnnotation(jumpHosts)
+	if err != nil {
+		return err
+	}
+	metav1.SetMetaDataAnnotation(objectMeta, machineclient.JumpHostsAnnotationKey, value)
+	return nil
+}
+
+// jumpHostsForSSHConfig returns the jump hosts used to reach the host of the
+// SSH config: those of its provisioned machine, or else those of its
+// credential, or else those of the cluster. It returns nil if the host is
+// reached directly.
+func jumpHostsForSSHConfig(sshConfig *spv1.SSHConfig) ([]machineclient.JumpHost, error) {
+	credentialName := sshConfig.CredentialSecret.Name
+	pmList, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list provisioned machines: %v", err)
+	}
+	for _, pm := range pmList.Items {
+		if pm.Spec.SSHConfig == nil || pm.Spec.SSHConfig.Host != sshConfig.Host || pm.Spec.SSHConfig.Port != sshConfig.Port {
+			continue
+		}
+		jumpHosts, err := machineclient.JumpHostsFromAnnotations(pm.Annotations)
+		if err != nil || jumpHosts != nil {
+			return jumpHosts, err
+		}
+		if len(credentialName) == 0 {
+			credentialName = pm.Spec.SSHConfig.CredentialSecret.Name
+		}
+		break
+	}
+	if len(credentialName) != 0 {
+		credential, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(credentialName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("unable to get SSH credential secret: %v", err)
+		}
+		if err == nil {
+			jumpHosts, err := machineclient.JumpHostsFromAnnotations(credential.Annotations)
+			if err != nil || jumpHosts != nil {
+				return jumpHosts, err
+			}
+		}
+	}
+	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get cluster: %v", err)
+	}
+	return machineclient.JumpHostsFromAnnotations(cluster.Annotations)
+}
+
+// jumpHostUsersOfCredential returns the provisioned machines, credentials and
+// clusters whose jump hosts log in with the named credential.
+func jumpHostUsersOfCredential(name string) ([]string, error) {
+	usesCredential := func(annotations map[string]string) (bool, error) {
+		jumpHosts, err := machineclient.JumpHostsFromAnnotations(annotations)
+		if err != nil {
+			return false, err
+		}
+		for _, jh := range jumpHosts {
+			if jh.Credential == name {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	var users []string
+	pmList, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list provisioned machines: %v", err)
+	}
+	for _, pm := range pmList.Items {
+		used, err := usesCredential(pm.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read jump hosts of provisioned machine %q: %v", pm.Name, err)
+		}
+		if used {
+			users = append(users, fmt.Sprintf("machine %q", pm.Name))
+		}
+	}
+	secretList, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list secrets: %v", err)
+	}
+	for _, secret := range secretList.Items {
+		// A credential that is deleted no longer needs its jump hosts.
+		if secret.Name == name {
+			continue
+		}
+		used, err := usesCredential(secret.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read jump hosts of credential %q: %v", secret.Name, err)
+		}
+		if used {
+			users = append(users, fmt.Sprintf("credential %q", secret.Name))
+		}
+	}
+	clusterList, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list clusters: %v", err)
+	}
+	for _, cluster := range clusterList.Items {
+		used, err := usesCredential(cluster.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read jump hosts of cluster %q: %v", cluster.Name, err)
+		}
+		if used {
+			users = append(users, fmt.Sprintf("cluster %q", cluster.Name))
+		}
+	}
+	return users, nil
+}
+
+// jumpHostEndpoints returns the endpoints of the jump hosts, with the
+// usernames and keys of their credentials.
+func jumpHostEndpoints(jumpHosts []machineclient.JumpHost) ([]machineclient.Endpoint, error) {
+	endpoints := make([]machineclient.Endpoint, 0, len(jumpHosts))
+	for _, jh := range jumpHosts {
+		e, err := credentialEndpoint(jh.Credential)
+		if err != nil {
+			return nil, fmt.Errorf("unable to use SSH credential %q of jump host %q: %v", jh.Credential, jh.Host, err)
+		}
+		e.Host, e.Port, e.PublicKeys = jh.Host, jh.Port, jh.PublicKeys
+		if err := setHostKeyVerification(&e, fmt.Sprintf("jump host %q", jh.Host)); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+// sshMachineClient builds machine clients for the actuator. The machine is
+// reached through the jump hosts configured for it, and authenticated with
+// its credential, so that encrypted and agent credentials work. The private
+// key given by the actuator is used only for a host that is not a
+// provisioned machine.
+func sshMachineClient(host string, port int, username string, privateKey string, publicKeys []string, insecureIgnoreHostKey bool) (sshmachine.Client, error) {
+	pm, err := provisionedMachineForHost(host, port)
+	if err != nil {
+		return nil, err
+	}
+	if pm != nil {
+		return sshMachineClientFromSSHConfig(pm.Spec.SSHConfig)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %v", err)
+	}
+	jumpHosts, err := jumpHostsForSSHConfig(&spv1.SSHConfig{Host: host, Port: port})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find jump hosts: %v", err)
+	}
+	endpoints, err := jumpHostEndpoints(jumpHosts)
+	if err != nil {
+		return nil, err
+	}
+	target := machineclient.Endpoint{
+		Host:       host,
+		Port:       port,
+		Username:   username,
+		Auth:       []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		PublicKeys: publicKeys,
+	}
+	if err := setHostKeyVerification(&target, "machine"); err != nil {
+		return nil, err
+	}
+	return machineclient.New(target, endpoints)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/platform9/cctl/common"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// kubeletConfigOverrideFromFile reads the kubelet configuration that
+// overrides the kubelet configuration of the cluster on a machine, and
+// returns it as JSON. Only the fields set in the file are overridden.
+func kubeletConfigOverrideFromFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("unable to read kubelet configuration %q: %v", filename, err)
+	}
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode kubelet configuration %q: %v", filename, err)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(jsonData, &fields); err != nil {
+		return "", fmt.Errorf("unable to decode kubelet configuration %q: %v", filename, err)
+	}
+	// The override is merged into the configuration of the cluster, which
+	// keeps its own type
+	delete(fields, "apiVersion")
+	delete(fields, "kind")
+	override, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode kubelet configuration %q: %v", filename, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(override))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spv1.KubeletConfiguration{}); err != nil {
+		return "", fmt.Errorf("invalid kubelet configuration %q: %v", filename, err)
+	}
+	return string(override), nil
+}
+
+// clusterForMachine returns the cluster as the actuator must see it when it
+// provisions the machine: if the machine overrides the kubelet configuration,
+// a copy of the cluster with the override merged into its kubelet
+// configuration, and the cluster itself otherwise.
+func clusterForMachine(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*clusterv1.Cluster, error) {
+	override, ok := machine.Spec.ObjectMeta.Annotations[common.KubeletConfigOverrideAnnotationKey]
+	if !ok {
+		return cluster, nil
+	}
+	clusterSpec, err := sputil.GetClusterSpec(*cluster)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode cluster spec: %v", err)
+	}
+	if clusterSpec.ClusterConfig == nil {
+		clusterSpec.ClusterConfig = &spv1.ClusterConfig{}
+	}
+	if clusterSpec.ClusterConfig.Kubelet == nil {
+		clusterSpec.ClusterConfig.Kubelet = &spv1.KubeletConfiguration{}
+	}
+	// Decoding into the configuration of the cluster replaces only the fields
+	// set in the override, and merges maps
+	if err := json.Unmarshal([]byte(override), clusterSpec.ClusterConfig.Kubelet); err != nil {
+		return nil, fmt.Errorf("unable to apply kubelet configuration override of machine %q: %v", machine.Name, err)
+	}
+	machineCluster := cluster.DeepCopy()
+	if err := sputil.PutClusterSpec(*clusterSpec, machineCluster); err != nil {
+		return nil, fmt.Errorf("unable to encode cluster spec: %v", err)
+	}
+	return machineCluster, nil
+}
@@ -17,13 +17,15 @@ limitations under the License.
 package cmd
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
 	"net"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"text/template"
 	"time"
 
@@ -32,11 +34,16 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 
 	setsutil "github.com/platform9/ssh-provider/pkg/util/sets"
 
 	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/etcdcluster"
+	"github.com/platform9/cctl/pkg/inventory"
 	log "github.com/platform9/cctl/pkg/logrus"
+	"github.com/platform9/cctl/pkg/machineclient"
+	"github.com/platform9/cctl/pkg/preflight"
 	"github.com/platform9/cctl/pkg/util/clusterapi"
 	kubeadmutil "github.com/platform9/cctl/pkg/util/kubeadm"
 	sshutil "github.com/platform9/cctl/pkg/util/ssh"
@@ -56,6 +63,7 @@ import (
 )
 
 var (
+	skipPreflight           bool
 	drainTimeout            time.Duration
 	drainGracePeriodSeconds int
 	drainDeleteLocalData    bool
@@ -68,15 +76,15 @@ func updateBootstrapToken(masterMachine *clusterv1.Machine, masterProvisionedMac
 	if err != nil {
 		return fmt.Errorf("Unable to read bootstrap token from master: %v", err)
 	}
-	if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Get(common.DefaultBootstrapTokenSecretName, metav1.GetOptions{}); err != nil {
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(common.DefaultBootstrapTokenSecretName, metav1.GetOptions{}); err != nil {
 		if !apierrors.IsNotFound(err) {
 			return fmt.Errorf("Unable to get bootstrap token secret: %v", err)
 		}
-		if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newBootstrapTokenSecret); err != nil {
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newBootstrapTokenSecret); err != nil {
 			return fmt.Errorf("Unable to create bootstrap token secret: %v", err)
 		}
 	} else {
-		if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Update(newBootstrapTokenSecret); err != nil {
+		if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Update(newBootstrapTokenSecret); err != nil {
 			return fmt.Errorf("Unable to update bootstrap token secret: %v", err)
 		}
 	}
@@ -95,7 +103,7 @@ func createAdminKubeconfigSecret(machine *clusterv1.Machine, provisionedMachine
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              common.DefaultAdminConfigSecretName,
-			Namespace:         common.DefaultNamespace,
+			Namespace:         clusterNamespace,
 			CreationTimestamp: metav1.Now(),
 		},
 		Data: make(map[string][]byte),
@@ -107,7 +115,7 @@ func createAdminKubeconfigSecret(machine *clusterv1.Machine, provisionedMachine
 func copyAdminConfigFromSecret(masterMachine *clusterv1.Machine, masterProvisionedMachine *spv1.ProvisionedMachine,
 	newMachine *clusterv1.Machine, newProvisionedMachine *spv1.ProvisionedMachine) error {
 	log.Println("Writing admin kubeconfig to machine")
-	kubeconfig, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Get(common.DefaultAdminConfigSecretName, metav1.GetOptions{})
+	kubeconfig, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(common.DefaultAdminConfigSecretName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("Unable to get admin kubeconfig from secret: %v", err)
 	}
@@ -129,13 +137,13 @@ func createAdminKubeConfigSecretIfNotPresent() error {
 	if err != nil {
 		return fmt.Errorf("unable to get master machine and provisioned machine: %v", err)
 	}
-	if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Get(common.DefaultAdminConfigSecretName, metav1.GetOptions{}); err != nil {
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(common.DefaultAdminConfigSecretName, metav1.GetOptions{}); err != nil {
 		if apierrors.IsNotFound(err) {
 			adminKubeConfigSecret, err := createAdminKubeconfigSecret(machine, provisionedMachine)
 			if err != nil {
 				return fmt.Errorf("unable to create secret for admin kubeconfig: %v", err)
 			}
-			if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(adminKubeConfigSecret); err != nil {
+			if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(adminKubeConfigSecret); err != nil {
 				return fmt.Errorf("unable to create secret for admin kubeconfig: %v", err)
 			}
 		} else {
@@ -145,86 +153,294 @@ func createAdminKubeConfigSecretIfNotPresent() error {
 	return nil
 }
 
-func createMachine(ip string, port int, iface string, roleString string, publicKeyFiles []string) {
-	role := clustercommon.MachineRole(roleString)
+// creationPhase is a step of creating a machine. The last completed phase is
+// recorded in an annotation of the machine, so that creation can resume
+// after a failure.
+type creationPhase string
+
+const (
+	phaseRegistered              creationPhase = "Registered"
+	phaseBootstrapTokenRefreshed creationPhase = "BootstrapTokenRefreshed"
+	phaseActuatorCreated         creationPhase = "ActuatorCreated"
+	phaseKubeconfigCopied        creationPhase = "KubeconfigCopied"
+	phaseNodeLabeled             creationPhase = "NodeLabeled"
+	phaseClusterStatusUpdated    creationPhase = "ClusterStatusUpdated"
+)
+
+// creationPhases are the phases of creating a machine, in order.
+var creationPhases = []creationPhase{
+	phaseRegistered,
+	phaseBootstrapTokenRefreshed,
+	phaseActuatorCreated,
+	phaseKubeconfigCopied,
+	phaseNodeLabeled,
+	phaseClusterStatusUpdated,
+}
+
+// creationPhaseOf returns the last completed creation phase of the machine.
+// A machine without the annotation was created before phases were recorded,
+// and is considered created.
+func creationPhaseOf(machine *clusterv1.Machine) creationPhase {
+	if phase, ok := machine.Annotations[common.CreationPhaseAnnotationKey]; ok {
+		return creationPhase(phase)
+	}
+	return creationPhases[len(creationPhases)-1]
+}
+
+// isCreated returns true if all creation phases of the machine completed.
+func isCreated(machine *clusterv1.Machine) bool {
+	return creationPhaseOf(machine) == creationPhases[len(creationPhases)-1]
+}
+
+// machineCreation holds what the phases of creating a machine share.
+type machineCreation struct {
+	cluster               *clusterv1.Cluster
+	machine               *clusterv1.Machine
+	provisionedMachine    *spv1.ProvisionedMachine
+	refreshBootstrapToken bool
+	// template is the machine whose labels, taints and kubelet configuration
+	// override are given to the new machine, if it is not nil.
+	template *clusterv1.Machine
+}
+
+// createMachine registers the machine in the state, and runs the phases of
+// creating it, recording each completed phase in the state. Before a node is
+// created, the bootstrap token is refreshed, unless refreshBootstrapToken is
+// false because the caller has refreshed it. If resume is true, the machine
+// must be registered, and creation continues after its last completed phase.
+func createMachine(m inventory.Machine, refreshBootstrapToken bool, resume bool) error {
+	return createMachineLike(m, nil, refreshBootstrapToken, resume)
+}
+
+// createMachineLike creates the machine like createMachine does. If the
+// template is not nil, the labels, taints and kubelet configuration override
+// of the template are used instead of those of m.
+func createMachineLike(m inventory.Machine, template *clusterv1.Machine, refreshBootstrapToken bool, resume bool) error {
+	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("no cluster found. Create a cluster before creating a machine")
+		}
+		return fmt.Errorf("unable to get cluster: %v", err)
+	}
+	mc := &machineCreation{
+		cluster:               cluster,
+		refreshBootstrapToken: refreshBootstrapToken,
+		template:              template,
+	}
+
+	existingMachine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(m.IP, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if isCreated(existingMachine) {
+			return fmt.Errorf("machine %q is already created", m.IP)
+		}
+		if !resume {
+			return fmt.Errorf("creation of machine %q stopped after phase %q. Use --resume to continue", m.IP, creationPhaseOf(existingMachine))
+		}
+		existingProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(m.IP, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get provisioned machine %q: %v", m.IP, err)
+		}
+		mc.machine = existingMachine
+		mc.provisionedMachine = existingProvisionedMachine
+		log.Printf("Resuming creation of machine %q after phase %q", m.IP, creationPhaseOf(existingMachine))
+	case apierrors.IsNotFound(err):
+		if resume {
+			return fmt.Errorf("machine %q is not registered, cannot resume its creation", m.IP)
+		}
+		if err := mc.register(m); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unable to get machine %q: %v", m.IP, err)
+	}
+
+	steps := map[creationPhase]func() error{
+		phaseBootstrapTokenRefreshed: mc.refreshToken,
+		phaseActuatorCreated:         mc.runActuator,
+		phaseKubeconfigCopied:        mc.copyKubeconfig,
+		phaseNodeLabeled:             mc.labelNode,
+		phaseClusterStatusUpdated:    mc.updateClusterStatus,
+	}
+	completed := creationPhaseOf(mc.machine)
+	if _, ok := steps[completed]; !ok && completed != phaseRegistered {
+		return fmt.Errorf("machine %q has unknown creation phase %q", mc.machine.Name, completed)
+	}
+	pending := false
+	for _, phase := range creationPhases {
+		if pending {
+			log.Printf("[%s] Running creation phase of machine %q", phase, mc.machine.Name)
+			if err := steps[phase](); err != nil {
+				return fmt.Errorf("creation phase %q failed: %v. Fix the failure, and use --resume to continue", phase, err)
+			}
+			if err := mc.recordPhase(phase); err != nil {
+				return err
+			}
+		}
+		if phase == completed {
+			pending = true
+		}
+	}
+	return nil
+}
+
+// register checks the machine, and records it in the state.
+func (mc *machineCreation) register(m inventory.Machine) error {
+	role := clustercommon.MachineRole(strings.Title(m.Role))
 	// TODO(dlipovetsky) Move to master validation code
 	if role != clustercommon.MasterRole && role != clustercommon.NodeRole {
-		log.Fatalf("Machine role %q is not supported, must be %q or %q.", role, clustercommon.MasterRole, clustercommon.NodeRole)
+		return fmt.Errorf("machine role %q is not supported, must be %q or %q", role, clustercommon.MasterRole, clustercommon.NodeRole)
+	}
+	taints, err := inventory.ParseTaints(m.Taints)
+	if err != nil {
+		return fmt.Errorf("invalid taints: %v", err)
+	}
+	var kubeletConfigOverride string
+	if len(m.KubeletConfigFile) != 0 {
+		if kubeletConfigOverride, err = kubeletConfigOverrideFromFile(m.KubeletConfigFile); err != nil {
+			return err
+		}
 	}
 	var publicKeys []string
-	for _, file := range publicKeyFiles {
+	for _, file := range m.PublicKeys {
 		publicKey, err := sshutil.PublicKeyFromFile(file)
 		if err != nil {
-			log.Fatalf("Unable to parse SSH public key from %q: %v", file, err)
+			return fmt.Errorf("unable to parse SSH public key from %q: %v", file, err)
 		}
 		publicKeys = append(publicKeys, string(ssh.MarshalAuthorizedKey(publicKey)))
 	}
 
-	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			log.Fatalf("No cluster found. Create a cluster before creating a machine.")
-		}
-		log.Fatalf("Unable to get cluster: %v", err)
-	}
-
-	cspec, err := sputil.GetClusterSpec(*cluster)
+	cspec, err := sputil.GetClusterSpec(*mc.cluster)
 	if err != nil {
-		log.Fatalf("Unable to decode cluster spec: %v", err)
+		return fmt.Errorf("unable to decode cluster spec: %v", err)
 	}
 	// If no vip exists, check if other masters exist before creating a new one.
 	if cspec.VIPConfiguration == nil {
 		if role == clustercommon.MasterRole {
-			_, _, err = masterMachineAndProvisionedMachine()
-			if err == nil {
-				log.Fatal("Creating a master is not allowed: this cluster already has one master and has no VIP configured.")
+			machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
+			if err != nil {
+				return fmt.Errorf("unable to list machines: %v", err)
+			}
+			for _, machine := range machineList.Items {
+				if clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles) {
+					return fmt.Errorf("creating a master is not allowed: this cluster already has one master and has no VIP configured")
+				}
 			}
 		}
 	}
 
-	sshCredentialSecret, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Get(common.DefaultSSHCredentialSecretName, metav1.GetOptions{})
+	sshCredentialSecret, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(m.Credential, metav1.GetOptions{})
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			log.Fatalf("No SSH credential found. Create a credential before creating a machine.")
+			return fmt.Errorf("no SSH credential %q found. Create the credential before creating a machine", m.Credential)
 		}
-		log.Fatalf("Unable to get SSH credential secret: %v", err)
+		return fmt.Errorf("unable to get SSH credential secret: %v", err)
 	}
 
 	newSSHConfig := spv1.SSHConfig{
-		Host:       ip,
-		Port:       port,
+		Host:       m.IP,
+		Port:       m.Port,
 		PublicKeys: publicKeys,
 		CredentialSecret: corev1.LocalObjectReference{
 			Name: sshCredentialSecret.Name,
 		},
 	}
 
-	newProvisionedMachine, newMachine, err := newProvisionedMachineAndMachine(ip, role, iface, newSSHConfig)
-	if _, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).Create(newProvisionedMachine); err != nil {
-		log.Fatalf("Unable to create provisioned machine: %v", err)
+	jumpHosts := m.JumpHosts
+	if len(jumpHosts) == 0 {
+		if jumpHosts, err = jumpHostsForSSHConfig(&newSSHConfig); err != nil {
+			return fmt.Errorf("unable to find jump hosts: %v", err)
+		}
 	}
-	if _, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).Create(newMachine); err != nil {
-		log.Fatalf("Unable to create machine: %v", err)
+	machineClient, err := sshMachineClientWithJumpHosts(&newSSHConfig, jumpHosts)
+	if err != nil {
+		return fmt.Errorf("unable to create machine client: %v", err)
 	}
-
-	var masterMachine *clusterv1.Machine
-	var masterProvisionedMachine *spv1.ProvisionedMachine
-	if clusterutil.RoleContains(clustercommon.NodeRole, newMachine.Spec.Roles) {
-		var err error
-		masterMachine, masterProvisionedMachine, err = masterMachineAndProvisionedMachine()
+	if !skipPreflight {
+		results, err := runPreflightChecks(machineClient, role, mc.cluster, m.IP)
 		if err != nil {
-			log.Fatalf("Unable to get a master machine and provisioned machine: %v", err)
+			return fmt.Errorf("unable to run preflight checks: %v", err)
 		}
-		if err := updateBootstrapToken(masterMachine, masterProvisionedMachine); err != nil {
-			log.Fatalf("Unable to update bootstrap token: %v", err)
+		if preflight.Failed(results) {
+			return fmt.Errorf("preflight checks failed. Fix the failures, or use --skip-preflight")
 		}
 	}
-	machineClientBuilder := sshmachine.NewClient
-	insecureIgnoreHostKey := false
-	if len(publicKeys) == 0 {
-		insecureIgnoreHostKey = true
-		log.Printf("Not able to verify machine SSH identity: No public keys given. Continuing...")
+	productUUID, err := preflight.ProductUUID(machineClient)
+	if err != nil {
+		return fmt.Errorf("unable to read product UUID: %v", err)
 	}
+
+	newProvisionedMachine, newMachine, err := newProvisionedMachineAndMachine(m.IP, role, m.Iface, newSSHConfig)
+	if err != nil {
+		return fmt.Errorf("unable to create machine objects: %v", err)
+	}
+	if mc.template != nil {
+		newMachine.Spec.ObjectMeta = *mc.template.Spec.ObjectMeta.DeepCopy()
+		newMachine.Spec.Taints = append([]corev1.Taint{}, mc.template.Spec.Taints...)
+	} else {
+		newMachine.Spec.ObjectMeta.Labels = m.Labels
+		newMachine.Spec.Taints = append(newMachine.Spec.Taints, taints...)
+		if len(kubeletConfigOverride) != 0 {
+			metav1.SetMetaDataAnnotation(&newMachine.Spec.ObjectMeta, common.KubeletConfigOverrideAnnotationKey, kubeletConfigOverride)
+		}
+	}
+	if err := setJumpHostsAnnotation(&newProvisionedMachine.ObjectMeta, m.JumpHosts); err != nil {
+		return err
+	}
+	metav1.SetMetaDataAnnotation(&newMachine.ObjectMeta, common.ProductUUIDAnnotationKey, productUUID)
+	metav1.SetMetaDataAnnotation(&newMachine.ObjectMeta, common.CreationPhaseAnnotationKey, string(phaseRegistered))
+	if _, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Create(newProvisionedMachine); err != nil {
+		return fmt.Errorf("unable to create provisioned machine: %v", err)
+	}
+	if _, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Create(newMachine); err != nil {
+		state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Delete(newProvisionedMachine.Name, &metav1.DeleteOptions{})
+		return fmt.Errorf("unable to create machine: %v", err)
+	}
+	if err := syncState(); err != nil {
+		return fmt.Errorf("unable to sync on-disk state: %v", err)
+	}
+	mc.machine = newMachine
+	mc.provisionedMachine = newProvisionedMachine
+	return nil
+}
+
+// recordPhase records the completed phase in the machine annotations, and
+// syncs the state.
+func (mc *machineCreation) recordPhase(phase creationPhase) error {
+	metav1.SetMetaDataAnnotation(&mc.machine.ObjectMeta, common.CreationPhaseAnnotationKey, string(phase))
+	updatedMachine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Update(mc.machine)
+	if err != nil {
+		return fmt.Errorf("unable to record creation phase %q of machine %q: %v", phase, mc.machine.Name, err)
+	}
+	mc.machine = updatedMachine
+	if err := syncState(); err != nil {
+		return fmt.Errorf("unable to sync on-disk state: %v", err)
+	}
+	return nil
+}
+
+func (mc *machineCreation) isNode() bool {
+	return clusterutil.RoleContains(clustercommon.NodeRole, mc.machine.Spec.Roles)
+}
+
+func (mc *machineCreation) refreshToken() error {
+	if !mc.isNode() || !mc.refreshBootstrapToken {
+		return nil
+	}
+	masterMachine, masterProvisionedMachine, err := masterMachineAndProvisionedMachine()
+	if err != nil {
+		return fmt.Errorf("unable to get a master machine and provisioned machine: %v", err)
+	}
+	if err := updateBootstrapToken(masterMachine, masterProvisionedMachine); err != nil {
+		return fmt.Errorf("unable to update bootstrap token: %v", err)
+	}
+	return nil
+}
+
+func (mc *machineCreation) runActuator() error {
+	machineClientBuilder := sshMachineClient
+	insecureIgnoreHostKey := len(mc.provisionedMachine.Spec.SSHConfig.PublicKeys) == 0
 	actuator := machineActuator.NewActuator(
 		state.KubeClient,
 		state.ClusterClient,
@@ -233,60 +449,125 @@ func createMachine(ip string, port int, iface string, roleString string, publicK
 		insecureIgnoreHostKey,
 		log.LogLevel(),
 	)
-	if err = actuator.Create(cluster, newMachine); err != nil {
-		log.Fatalf("Unable to create machine: %v", err)
+	cluster, err := clusterForMachine(mc.cluster, mc.machine)
+	if err != nil {
+		return err
 	}
+	return actuator.Create(cluster, mc.machine)
+}
 
-	if clusterutil.RoleContains(clustercommon.NodeRole, newMachine.Spec.Roles) {
-		if err := createAdminKubeConfigSecretIfNotPresent(); err != nil {
-			log.Fatalf("Unable to create admin kubeconfig secret: %v", err)
-		}
-		if err := copyAdminConfigFromSecret(masterMachine, masterProvisionedMachine, newMachine, newProvisionedMachine); err != nil {
-			log.Fatalf("Unable to place admin kubeconfig on the node: %v", err)
-		}
+func (mc *machineCreation) copyKubeconfig() error {
+	if !mc.isNode() {
+		return nil
 	}
+	masterMachine, masterProvisionedMachine, err := masterMachineAndProvisionedMachine()
+	if err != nil {
+		return fmt.Errorf("unable to get a master machine and provisioned machine: %v", err)
+	}
+	if err := createAdminKubeConfigSecretIfNotPresent(); err != nil {
+		return fmt.Errorf("unable to create admin kubeconfig secret: %v", err)
+	}
+	if err := copyAdminConfigFromSecret(masterMachine, masterProvisionedMachine, mc.machine, mc.provisionedMachine); err != nil {
+		return fmt.Errorf("unable to place admin kubeconfig on the node: %v", err)
+	}
+	return nil
+}
 
-	if clusterutil.RoleContains(clustercommon.MasterRole, newMachine.Spec.Roles) {
-		log.Println("Updating cluster status")
-		// Update cluster etcd members
-		machineStatus, err := sputil.GetMachineStatus(*newMachine)
-		if err != nil {
-			log.Fatalf("Unable to get machine %q status: %v", newMachine.Name, err)
-		}
-		if machineStatus.EtcdMember != nil {
-			if err := insertClusterEtcdMember(*machineStatus.EtcdMember, cluster); err != nil {
-				log.Fatalf("Unable to add etcd member to cluster status: %v", err)
-			}
-		}
-		// Update cluster API endpoints
-		var apiEndpoint *clusterv1.APIEndpoint
-		// Use the controlPlaneEndpoint if it is defined
-		apiEndpoint, err = controlPlaneEndpointFromMachine(newMachine, newProvisionedMachine)
-		if err != nil {
-			if err.Error() != "controlPlaneEndpoint is not defined" {
-				log.Fatalf("Unable to get machine %q control plane endpoint: %v", newMachine.Name, err)
-			}
-			// If control plane endpoint is not defined, use the machine's advertised API address and port
-			apiEndpoint, err = apiEndpointFromMachine(newMachine, newProvisionedMachine)
-			if err != nil {
-				log.Fatalf("Unable to get machine %q advertised API address and port: %v", newMachine.Name, err)
-			}
+// labelNode applies the labels of the machine to its node.
+func (mc *machineCreation) labelNode() error {
+	if len(mc.machine.Spec.ObjectMeta.Labels) == 0 {
+		return nil
+	}
+	if err := labelNodeForMachine(mc.machine, mc.provisionedMachine); err != nil {
+		return fmt.Errorf("unable to label node: %v", err)
+	}
+	return nil
+}
+
+func (mc *machineCreation) updateClusterStatus() error {
+	if mc.isNode() {
+		return nil
+	}
+	log.Println("Updating cluster status")
+	cluster := mc.cluster
+	// Update cluster etcd members
+	machineStatus, err := sputil.GetMachineStatus(*mc.machine)
+	if err != nil {
+		return fmt.Errorf("unable to get machine %q status: %v", mc.machine.Name, err)
+	}
+	if machineStatus.EtcdMember != nil {
+		if err := insertClusterEtcdMember(*machineStatus.EtcdMember, cluster); err != nil {
+			return fmt.Errorf("unable to add etcd member to cluster status: %v", err)
 		}
+	}
+	// Update cluster API endpoints
+	apiEndpoint, err := apiEndpointForMaster(mc.machine, mc.provisionedMachine)
+	if err != nil {
+		return fmt.Errorf("unable to get machine %q API endpoint: %v", mc.machine.Name, err)
+	}
 
-		apiEndpointSet := setsutil.NewAPIEndpointSet(cluster.Status.APIEndpoints...)
-		apiEndpointSet.Insert(*apiEndpoint)
-		cluster.Status.APIEndpoints = apiEndpointSet.List()
+	apiEndpointSet := setsutil.NewAPIEndpointSet(cluster.Status.APIEndpoints...)
+	apiEndpointSet.Insert(*apiEndpoint)
+	cluster.Status.APIEndpoints = apiEndpointSet.List()
 
-		_, err = state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).UpdateStatus(cluster)
-		if err != nil {
-			log.Fatalf("Unable to update cluster state: %v", err)
+	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).UpdateStatus(cluster); err != nil {
+		return fmt.Errorf("unable to update cluster state: %v", err)
+	}
+	return nil
+}
+
+// knownProductUUIDs returns the product UUIDs recorded for the machines in
+// the state, mapped to the machine names, except for the named machine of the
+// current cluster.
+func knownProductUUIDs(exclude string) (map[string]string, error) {
+	machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list machines: %v", err)
+	}
+	known := make(map[string]string)
+	for _, machine := range machineList.Items {
+		if machine.Name == exclude && machine.Namespace == clusterNamespace {
+			continue
+		}
+		if productUUID, ok := machine.Annotations[common.ProductUUIDAnnotationKey]; ok {
+			known[productUUID] = machine.Name
 		}
 	}
+	return known, nil
+}
 
-	if err := state.PullFromAPIs(); err != nil {
-		log.Fatalf("Unable to sync on-disk state: %v", err)
+// runPreflightChecks runs the preflight checks for a machine of the cluster
+// with the role, and prints the results.
+func runPreflightChecks(machineClient sshmachine.Client, role clustercommon.MachineRole, cluster *clusterv1.Cluster, machineName string) ([]preflight.Result, error) {
+	cspec, err := sputil.GetClusterSpec(*cluster)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode cluster spec: %v", err)
+	}
+	var cgroupDriver string
+	if cspec.ClusterConfig != nil && cspec.ClusterConfig.Kubelet != nil {
+		cgroupDriver = cspec.ClusterConfig.Kubelet.CgroupDriver
 	}
-	log.Println("Machine created successfully.")
+	known, err := knownProductUUIDs(machineName)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Running preflight checks on machine %q", machineName)
+	results := preflight.Run(machineClient, preflight.DefaultChecks(role == clustercommon.MasterRole, cgroupDriver, known))
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 3, ' ', 0)
+	t := template.Must(template.New("PreflightResultPrintTemplate").Parse(common.PreflightResultPrintTemplate))
+	if err := t.Execute(w, struct {
+		Machine string
+		Results []preflight.Result
+	}{machineName, results}); err != nil {
+		return nil, fmt.Errorf("could not pretty print preflight results: %v", err)
+	}
+	w.Flush()
+	// Print the table at once, so that it is not interleaved with the tables
+	// of machines checked concurrently.
+	os.Stdout.Write(buf.Bytes())
+	return results, nil
 }
 
 // machineCmdCreate represents the machine create command
@@ -296,7 +577,7 @@ var machineCmdCreate = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ip := cmd.Flag("ip").Value.String()
 		iface := cmd.Flag("iface").Value.String()
-		role := strings.Title(cmd.Flag("role").Value.String())
+		role := cmd.Flag("role").Value.String()
 		port, err := strconv.Atoi(cmd.Flag("port").Value.String())
 		if err != nil {
 			log.Fatalf("Invalid port %v", err)
@@ -305,7 +586,40 @@ var machineCmdCreate = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Unable to parse `public-keys`: %v", err)
 		}
-		createMachine(ip, port, iface, role, publicKeyFiles)
+		labelSpecs, err := cmd.Flags().GetStringSlice("labels")
+		if err != nil {
+			log.Fatalf("Unable to parse `labels`: %v", err)
+		}
+		labels, err := inventory.ParseLabels(labelSpecs)
+		if err != nil {
+			log.Fatalf("Invalid labels: %v", err)
+		}
+		taints, err := cmd.Flags().GetStringSlice("taints")
+		if err != nil {
+			log.Fatalf("Unable to parse `taints`: %v", err)
+		}
+		m := inventory.Machine{
+			IP:                ip,
+			Port:              port,
+			Role:              role,
+			Iface:             iface,
+			PublicKeys:        publicKeyFiles,
+			Labels:            labels,
+			Taints:            taints,
+			KubeletConfigFile: cmd.Flag("kubelet-config-file").Value.String(),
+			Credential:        cmd.Flag("credential").Value.String(),
+		}
+		if m.JumpHosts, err = jumpHostsFromFlag(cmd); err != nil {
+			log.Fatalf("Unable to load jump hosts: %v", err)
+		}
+		resume, err := cmd.Flags().GetBool("resume")
+		if err != nil {
+			log.Fatalf("Unable to parse `resume`: %v", err)
+		}
+		if err := createMachine(m, true, resume); err != nil {
+			log.Fatalf("Unable to create machine: %v", err)
+		}
+		log.Println("Machine created successfully.")
 	},
 }
 
@@ -329,7 +643,7 @@ func newProvisionedMachineAndMachine(name string, role clustercommon.MachineRole
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              name,
-			Namespace:         common.DefaultNamespace,
+			Namespace:         clusterNamespace,
 			CreationTimestamp: metav1.Now(),
 		},
 		Spec: spv1.ProvisionedMachineSpec{
@@ -345,7 +659,7 @@ func newProvisionedMachineAndMachine(name string, role clustercommon.MachineRole
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              name,
-			Namespace:         common.DefaultNamespace,
+			Namespace:         clusterNamespace,
 			CreationTimestamp: metav1.Now(),
 		},
 		Spec: clusterv1.MachineSpec{
@@ -394,8 +708,8 @@ func newProvisionedMachineAndMachine(name string, role clustercommon.MachineRole
 	return &newProvisionedMachine, &newMachine, nil
 }
 
-func deleteMachine(ip string, force bool, skipDrainDelete bool) {
-	targetMachine, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).Get(ip, metav1.GetOptions{})
+func deleteMachine(ip string, force bool, skipDrainDelete bool, allowQuorumLoss bool) {
+	targetMachine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(ip, metav1.GetOptions{})
 	if err != nil {
 		log.Fatalf("Unable to get machine %q: %v", ip, err)
 	}
@@ -403,15 +717,18 @@ func deleteMachine(ip string, force bool, skipDrainDelete bool) {
 	if err != nil {
 		log.Fatalf("Unable to decode machine %q spec: %v", targetMachine.Name, err)
 	}
-	targetProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).Get(targetMachineSpec.ProvisionedMachineName, metav1.GetOptions{})
+	targetProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(targetMachineSpec.ProvisionedMachineName, metav1.GetOptions{})
 	if err != nil {
 		log.Fatalf("Unable to get provisioned machine %q: %v", targetMachineSpec.ProvisionedMachineName, err)
 	}
-	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
+	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
 	if err != nil {
 		log.Fatalf("Unable to get cluster: %v", err)
 	}
 
+	if err := mustNotLoseQuorum(targetMachine, "delete", force, allowQuorumLoss); err != nil {
+		log.Fatalf("Unable to delete machine: %v", err)
+	}
 	if force {
 		log.Println("--force enabled: skipping node drain, node delete, and commands invoked on the machine")
 	} else {
@@ -422,12 +739,10 @@ func deleteMachine(ip string, force bool, skipDrainDelete bool) {
 			}
 		}
 
-		var insecureIgnoreHostKey bool
-		if len(targetProvisionedMachine.Spec.SSHConfig.PublicKeys) == 0 {
-			insecureIgnoreHostKey = true
-			log.Printf("Not able to verify machine SSH identity: No public keys given. Continuing...")
-		}
-		machineClientBuilder := sshmachine.NewClient
+		// The machine client builder verifies the host key, so this only
+		// tells the actuator whether public keys are known.
+		insecureIgnoreHostKey := len(targetProvisionedMachine.Spec.SSHConfig.PublicKeys) == 0
+		machineClientBuilder := sshMachineClient
 		actuator := machineActuator.NewActuator(
 			state.KubeClient,
 			state.ClusterClient,
@@ -453,16 +768,16 @@ func deleteMachine(ip string, force bool, skipDrainDelete bool) {
 		}
 	}
 
-	if err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).Delete(targetMachine.Name, &metav1.DeleteOptions{}); err != nil {
+	if err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Delete(targetMachine.Name, &metav1.DeleteOptions{}); err != nil {
 		log.Fatalf("unable to delete machine %q: %v", targetMachine.Name, err)
 	}
-	if err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).Delete(targetProvisionedMachine.Name, &metav1.DeleteOptions{}); err != nil {
+	if err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Delete(targetProvisionedMachine.Name, &metav1.DeleteOptions{}); err != nil {
 		log.Fatalf("unable to delete provisioned machine %q: %v", targetProvisionedMachine.Name, err)
 	}
 
 	if clusterutil.RoleContains(clustercommon.MasterRole, targetMachine.Spec.Roles) {
 		// Update cluster API endpoints
-		machines, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).List(metav1.ListOptions{})
+		machines, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
 		if err != nil {
 			log.Fatalf("unable to list machines: %v", err)
 		}
@@ -475,7 +790,7 @@ func deleteMachine(ip string, force bool, skipDrainDelete bool) {
 		if len(masters) == 0 {
 			cluster.Status.APIEndpoints = []clusterv1.APIEndpoint{}
 		}
-		_, err = state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).UpdateStatus(cluster)
+		_, err = state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).UpdateStatus(cluster)
 		if err != nil {
 			log.Fatalf("Unable to update cluster state: %v", err)
 		}
@@ -501,13 +816,17 @@ var machineCmdDelete = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Unable to parse `skip-drain-delete` flag: %v", err)
 		}
-		deleteMachine(ip, force, skipDrainDelete)
+		allowQuorumLoss, err := cmd.Flags().GetBool("i-understand-quorum-loss")
+		if err != nil {
+			log.Fatalf("Unable to parse `i-understand-quorum-loss` flag: %v", err)
+		}
+		deleteMachine(ip, force, skipDrainDelete, allowQuorumLoss)
 	},
 }
 
 func deleteMustNotOrphanNodes(targetMachine *clusterv1.Machine) {
 	if clusterutil.RoleContains(clustercommon.MasterRole, targetMachine.Spec.Roles) {
-		machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).List(metav1.ListOptions{})
+		machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
 		if err != nil {
 			log.Fatalf("Unable to list machines: %v", err)
 		}
@@ -529,6 +848,58 @@ func deleteMustNotOrphanNodes(targetMachine *clusterv1.Machine) {
 	}
 }
 
+// mustNotLoseQuorum returns an error if removing the etcd member of a master
+// leaves the etcd cluster without quorum, or if the etcd cluster has no quorum,
+// unless allowQuorumLoss is true. The operation, e.g. "delete", names what is
+// done to the machine. The etcd cluster is read through another master whose
+// etcd member is healthy. If force is true, the etcd member of the machine is
+// not removed from the etcd cluster, but it stops.
+func mustNotLoseQuorum(targetMachine *clusterv1.Machine, operation string, force bool, allowQuorumLoss bool) error {
+	if !clusterutil.RoleContains(clustercommon.MasterRole, targetMachine.Spec.Roles) {
+		return nil
+	}
+	refuse := func(format string, args ...interface{}) error {
+		if allowQuorumLoss {
+			log.Warnf("Continuing, because --i-understand-quorum-loss is set: %s", fmt.Sprintf(format, args...))
+			return nil
+		}
+		return fmt.Errorf("%s. Use --i-understand-quorum-loss to %s the machine anyway", fmt.Sprintf(format, args...), operation)
+	}
+	machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list machines: %v", err)
+	}
+	otherMasters := 0
+	for _, machine := range clusterapi.MachinesWithRole(machineList.Items, clustercommon.MasterRole) {
+		if machine.Name != targetMachine.Name {
+			otherMasters++
+		}
+	}
+	if otherMasters == 0 {
+		// Deleting the last master deletes the etcd cluster.
+		return nil
+	}
+	machineStatus, err := sputil.GetMachineStatus(*targetMachine)
+	if err != nil {
+		return fmt.Errorf("unable to decode machine %q status: %v", targetMachine.Name, err)
+	}
+	master, _, status, err := healthyMaster(targetMachine.Name)
+	if err != nil {
+		return refuse("unable to check etcd quorum: %v", err)
+	}
+
+	r := etcdcluster.QuorumAfterRemoval(status, machineStatus.EtcdMember, force)
+	log.Printf("Etcd cluster, read through master %q, has %d members, %d healthy, and fault tolerance %d", master.Name, r.Members, r.Healthy, etcdcluster.FaultTolerance(r.Members))
+	log.Printf("Without the etcd member of machine %q, etcd cluster will have %d members, %d healthy, and fault tolerance %d", targetMachine.Name, r.MembersAfter, r.HealthyAfter, etcdcluster.FaultTolerance(r.MembersAfter))
+	if !r.HasQuorum() {
+		return refuse("etcd cluster has no quorum: %d of %d members are healthy, %d are needed", r.Healthy, r.Members, etcdcluster.Quorum(r.Members))
+	}
+	if !r.KeepsQuorum() {
+		return refuse("removing the etcd member of machine %q breaks etcd quorum: %d of %d members would be healthy, %d are needed", targetMachine.Name, r.HealthyAfter, r.MembersAfter, etcdcluster.Quorum(r.MembersAfter))
+	}
+	return nil
+}
+
 func bootstrapTokenSecretFromMachine(machine *clusterv1.Machine, provisionedMachine *spv1.ProvisionedMachine) (*corev1.Secret, error) {
 	machineClient, err := sshMachineClientFromSSHConfig(provisionedMachine.Spec.SSHConfig)
 	if err != nil {
@@ -550,7 +921,7 @@ func bootstrapTokenSecretFromMachine(machine *clusterv1.Machine, provisionedMach
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              common.DefaultBootstrapTokenSecretName,
-			Namespace:         common.DefaultNamespace,
+			Namespace:         clusterNamespace,
 			CreationTimestamp: metav1.Now(),
 		},
 		Data: map[string][]byte{
@@ -562,14 +933,14 @@ func bootstrapTokenSecretFromMachine(machine *clusterv1.Machine, provisionedMach
 }
 
 func masterMachineAndProvisionedMachine() (*clusterv1.Machine, *spv1.ProvisionedMachine, error) {
-	machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).List(metav1.ListOptions{})
+	machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to list machines: %v", err)
 	}
 	var masterMachine *clusterv1.Machine
 	for _, machine := range machineList.Items {
-		if clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles) {
-			// Choose first master in the list
+		if clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles) && isCreated(&machine) {
+			// Choose first created master in the list
 			masterMachine = machine.DeepCopy()
 			break
 		}
@@ -581,13 +952,31 @@ func masterMachineAndProvisionedMachine() (*clusterv1.Machine, *spv1.Provisioned
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to decode machine spec: %v", err)
 	}
-	masterProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).Get(masterMachineSpec.ProvisionedMachineName, metav1.GetOptions{})
+	masterProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(masterMachineSpec.ProvisionedMachineName, metav1.GetOptions{})
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to get provisioned machine: %v", err)
 	}
 	return masterMachine, masterProvisionedMachine.DeepCopy(), nil
 }
 
+// apiEndpointForMaster returns the API endpoint of the master: the
+// controlPlaneEndpoint if it is defined, and the advertised API address and
+// port of the API server on the machine otherwise.
+func apiEndpointForMaster(machine *clusterv1.Machine, provisionedMachine *spv1.ProvisionedMachine) (*clusterv1.APIEndpoint, error) {
+	apiEndpoint, err := controlPlaneEndpointFromMachine(machine, provisionedMachine)
+	if err == nil {
+		return apiEndpoint, nil
+	}
+	if err.Error() != "controlPlaneEndpoint is not defined" {
+		return nil, fmt.Errorf("unable to get control plane endpoint: %v", err)
+	}
+	apiEndpoint, err = apiEndpointFromMachine(machine, provisionedMachine)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get advertised API address and port: %v", err)
+	}
+	return apiEndpoint, nil
+}
+
 // controlPlaneEndpointFromMachine returns the advertised API address and port
 // of the API server on the machine
 func controlPlaneEndpointFromMachine(machine *clusterv1.Machine, provisionedMachine *spv1.ProvisionedMachine) (*clusterv1.APIEndpoint, error) {
@@ -665,24 +1054,12 @@ func adminKubeconfigFromMachine(machine *clusterv1.Machine, provisionedMachine *
 	if err != nil {
 		return nil, fmt.Errorf("unable to create machine client for machine %q: %v", machine.Name, err)
 	}
-	// chmod file for read access for all users
-	stdOut, stdErr, err := machineClient.RunCommand("chmod 0644 /etc/kubernetes/admin.conf")
-	if err != nil {
-		log.Println(stdOut)
-		log.Println(stdErr)
-		return nil, fmt.Errorf("unable to change kubeconfig file permissions on %q: %v", machine.Name, err)
-	}
-	fileContents, err := machineClient.ReadFile("/etc/kubernetes/admin.conf")
+	// The admin kubeconfig is readable only by root. The machine client reads
+	// it with root privileges, whichever user it logs in as.
+	fileContents, err := machineClient.ReadFile(common.AdminKubeconfig)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read kubeconfig from machine %q:%v", machine.Name, err)
 	}
-	// chmod file to keep it secure
-	stdOut, stdErr, err = machineClient.RunCommand("chmod 0600 /etc/kubernetes/admin.conf")
-	if err != nil {
-		log.Println(stdOut)
-		log.Println(stdErr)
-		return nil, fmt.Errorf("unable to change kubeconfig file permissions on %q: %v", machine.Name, err)
-	}
 	return fileContents, nil
 }
 
@@ -722,39 +1099,51 @@ func drainAndDeleteNodeForMachine(targetMachine *clusterv1.Machine, targetProvis
 }
 
 func sshMachineClientFromSSHConfig(sshConfig *spv1.SSHConfig) (sshmachine.Client, error) {
-	sshCredentialSecret, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Get(sshConfig.CredentialSecret.Name, metav1.GetOptions{})
+	jumpHosts, err := jumpHostsForSSHConfig(sshConfig)
 	if err != nil {
-		if apierrors.IsNotFound(err) {
-			return nil, fmt.Errorf("unable to find SSH credential %q", sshConfig.CredentialSecret.Name)
-		}
-		return nil, fmt.Errorf("unable to get SSH credential secret: %v", err)
+		return nil, fmt.Errorf("unable to find jump hosts: %v", err)
+	}
+	return sshMachineClientWithJumpHosts(sshConfig, jumpHosts)
+}
+
+// sshMachineClientWithJumpHosts returns a client of the machine, reached
+// through the jump hosts.
+func sshMachineClientWithJumpHosts(sshConfig *spv1.SSHConfig, jumpHosts []machineclient.JumpHost) (sshmachine.Client, error) {
+	target, err := credentialEndpoint(sshConfig.CredentialSecret.Name)
+	if err != nil {
+		return nil, err
 	}
-	username, privateKey, err := sputil.UsernameAndKeyFromSecret(sshCredentialSecret)
+	endpoints, err := jumpHostEndpoints(jumpHosts)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read SSH credential from secret: %v", err)
+		return nil, err
+	}
+	if len(sshConfig.PublicKeys) == 0 && trustOnFirstUse {
+		if err := trustHostKeysOnFirstUse(sshConfig, endpoints); err != nil {
+			return nil, fmt.Errorf("unable to trust host keys on first use: %v", err)
+		}
 	}
-	var insecureIgnoreHostKey bool
-	if len(sshConfig.PublicKeys) == 0 {
-		insecureIgnoreHostKey = true
-		log.Printf("Not able to verify machine SSH identity: No public keys given. Continuing...")
+	target.Host, target.Port, target.PublicKeys = sshConfig.Host, sshConfig.Port, sshConfig.PublicKeys
+	if err := setHostKeyVerification(&target, "machine"); err != nil {
+		return nil, err
 	}
-	return sshmachine.NewClient(sshConfig.Host, sshConfig.Port, username, privateKey, sshConfig.PublicKeys, insecureIgnoreHostKey)
+	return machineclient.New(target, endpoints)
 }
 
 var machineCmdGet = &cobra.Command{
-	Use:   "machine",
-	Short: "Get machine resources",
+	Use:     "machine",
+	Aliases: []string{"machines"},
+	Short:   "Get machine resources",
 	Run: func(cmd *cobra.Command, args []string) {
 		ip := cmd.Flag("ip").Value.String()
 		var machineList *clusterv1.MachineList
 		if len(ip) == 0 {
 			var err error
-			machineList, err = state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).List(metav1.ListOptions{})
+			machineList, err = state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
 			if err != nil {
 				log.Fatalf("Unable to list machines: %v", err)
 			}
 		} else {
-			machine, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).Get(ip, metav1.GetOptions{})
+			machine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(ip, metav1.GetOptions{})
 			if err != nil {
 				log.Fatalf("Unable to get machine %q: %v", ip, err)
 			}
@@ -762,26 +1151,12 @@ var machineCmdGet = &cobra.Command{
 				Items: []clusterv1.Machine{*machine},
 			}
 		}
-		switch outputFmt {
-		case "yaml":
-			bytes, err := yaml.Marshal(machineList.Items)
-			if err != nil {
-				log.Fatalf("Unable to marshal cluster spec file to yaml: %s", err)
-			}
-			os.Stdout.Write(bytes)
-		case "json":
-			bytes, err := json.Marshal(machineList.Items)
-			if err != nil {
-				log.Fatalf("Unable to marshal cluster spec file to json: %s", err)
-			}
-			os.Stdout.Write(bytes)
-		case "":
-			t := template.Must(template.New("MachineV1PrintTemplate").Parse(common.MachineV1PrintTemplate))
-			if err := t.Execute(os.Stdout, machineList.Items); err != nil {
-				log.Fatalf("Could not pretty print cluster details: %s", err)
-			}
-		default:
-			log.Fatalf("Unsupported output format %q", outputFmt)
+		live, err := cmd.Flags().GetBool("live")
+		if err != nil {
+			log.Fatalf("Unable to parse --live flag: %v", err)
+		}
+		if err := printMachines(os.Stdout, machineList.Items, outputFmt, live); err != nil {
+			log.Fatalf("Unable to print machines: %v", err)
 		}
 	},
 }
@@ -814,6 +1189,17 @@ func isUpgradeRequired(old *spv1.MachineComponentVersions, cur *spv1.MachineComp
 
 type instanceStatus *clusterv1.Machine
 
+func hasTaintWithKey(taints []corev1.Taint, key string) bool {
+	for _, taint := range taints {
+		if taint.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// getGoalMachine returns the machine after upgrade. The labels, taints and
+// kubelet configuration override of the current machine are kept.
 func getGoalMachine(currentMachine *clusterv1.Machine) (*clusterv1.Machine, error) {
 	currentMachineSpec, err := sputil.GetMachineSpec(*currentMachine)
 	if err != nil {
@@ -827,13 +1213,11 @@ func getGoalMachine(currentMachine *clusterv1.Machine) (*clusterv1.Machine, erro
 	// When upgrading from < 1.11
 	if currentKubernetesVersion.LessThan(*semver.New("1.11.0")) {
 		// Master machines need a workaround for https://github.com/kubernetes/kubeadm/issues/1358
-		if clusterutil.RoleContains(clustercommon.MasterRole, currentMachine.Spec.Roles) && len(currentMachine.Spec.Taints) == 0 {
-			goalMachine.Spec.Taints = []corev1.Taint{
-				{
-					Key:    common.LabelNodeRoleMaster,
-					Effect: corev1.TaintEffectPreferNoSchedule,
-				},
-			}
+		if clusterutil.RoleContains(clustercommon.MasterRole, currentMachine.Spec.Roles) && !hasTaintWithKey(currentMachine.Spec.Taints, common.LabelNodeRoleMaster) {
+			goalMachine.Spec.Taints = append(goalMachine.Spec.Taints, corev1.Taint{
+				Key:    common.LabelNodeRoleMaster,
+				Effect: corev1.TaintEffectPreferNoSchedule,
+			})
 		}
 	}
 
@@ -857,7 +1241,7 @@ func upgradeMachine(ip string) error {
 	log.Printf("Upgrading machine %s\n", ip)
 	// Get the current machine
 	currentMachine, err := state.ClusterClient.ClusterV1alpha1().
-		Machines(common.DefaultNamespace).
+		Machines(clusterNamespace).
 		Get(ip, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("unable to get machine %q: %v", ip, err)
@@ -867,7 +1251,7 @@ func upgradeMachine(ip string) error {
 		return fmt.Errorf("unable to decode machine %q spec: %v", currentMachine.Name, err)
 	}
 	currentProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().
-		ProvisionedMachines(common.DefaultNamespace).
+		ProvisionedMachines(clusterNamespace).
 		Get(currentMachineSpec.ProvisionedMachineName, metav1.GetOptions{})
 
 	// Check if upgrade is required
@@ -903,12 +1287,10 @@ func upgradeMachine(ip string) error {
 		}
 
 		// Instantiate actuator
-		machineClientBuilder := sshmachine.NewClient
-		insecureIgnoreHostKey := false
-		if len(currentProvisionedMachine.Spec.SSHConfig.PublicKeys) == 0 {
-			insecureIgnoreHostKey = true
-			log.Printf("Not able to verify machine SSH identity: No public keys given. Continuing...")
-		}
+		machineClientBuilder := sshMachineClient
+		// The machine client builder verifies the host key, so this only
+		// tells the actuator whether public keys are known.
+		insecureIgnoreHostKey := len(currentProvisionedMachine.Spec.SSHConfig.PublicKeys) == 0
 		actuator := machineActuator.NewActuator(
 			state.KubeClient,
 			state.ClusterClient,
@@ -934,9 +1316,9 @@ func upgradeMachine(ip string) error {
 		}
 
 		// Call actuator's update
-		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
+		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
 		if err != nil {
-			return fmt.Errorf("unable to get cluster %s: %v", common.DefaultClusterName, err)
+			return fmt.Errorf("unable to get cluster %s: %v", clusterName, err)
 		}
 		currentMachineStatus, err := sputil.GetMachineStatus(*currentMachine)
 		if err != nil {
@@ -951,7 +1333,11 @@ func upgradeMachine(ip string) error {
 				return fmt.Errorf("unable to delete etcd member from cluster status")
 			}
 		}
-		if err := actuator.Update(cluster, goalMachine); err != nil {
+		machineCluster, err := clusterForMachine(cluster, goalMachine)
+		if err != nil {
+			return err
+		}
+		if err := actuator.Update(machineCluster, goalMachine); err != nil {
 			return fmt.Errorf("unable to update the node %s: %v", nodeName, err)
 		}
 		goalMachineStatus, err := sputil.GetMachineStatus(*goalMachine)
@@ -973,6 +1359,17 @@ func upgradeMachine(ip string) error {
 				return fmt.Errorf("unable to copy admin kubeconfig to node: %v", err)
 			}
 		}
+		// The node is registered again, so its labels and taints are set again
+		if len(goalMachine.Spec.ObjectMeta.Labels) != 0 {
+			if err := labelNode(nodeName, goalMachine.Spec.ObjectMeta.Labels, targetMachineClient); err != nil {
+				return fmt.Errorf("unable to label the node %s: %v", nodeName, err)
+			}
+		}
+		if len(goalMachine.Spec.Taints) != 0 {
+			if err := taintNode(nodeName, goalMachine.Spec.Taints, targetMachineClient); err != nil {
+				return fmt.Errorf("unable to taint the node %s: %v", nodeName, err)
+			}
+		}
 		if err := uncordonNode(nodeName, targetMachineClient); err != nil {
 			return fmt.Errorf("unable to uncordon the node %s: %v", nodeName, err)
 		}
@@ -995,7 +1392,7 @@ func upgradeMachine(ip string) error {
 			log.Println("Machine upgraded successfully.")
 		}
 	}
-	if _, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).
+	if _, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).
 		Update(currentMachine); err != nil {
 		return fmt.Errorf("unable to update machine: %v", err)
 	}
@@ -1017,7 +1414,7 @@ func nodeNameForMachine(machineName string, machineClient sshmachine.Client) (st
 	// binary. nodeName includes the object kind, i.e.,
 
 	log.Printf("Identifying node for machine %q", machineName)
-	// Requires sudo because the kubelet kubeconfig is readable by only by root.
+	// Requires root privileges because the kubelet kubeconfig is readable only by root.
 	cmd = fmt.Sprintf(`%s --kubeconfig=%s get nodes -ojsonpath='{.items[?(@.status.nodeInfo.systemUUID=="%s")].metadata.name}'`, common.KubectlFile, common.KubeletKubeconfig, systemUUID)
 	stdOut, stdErr, err = machineClient.RunCommand(cmd)
 	if err != nil {
@@ -1028,7 +1425,7 @@ func nodeNameForMachine(machineName string, machineClient sshmachine.Client) (st
 }
 
 func drainNode(nodeName string, machineClient sshmachine.Client) error {
-	// Requires sudo because the admin kubeconfig is readable by only by
+	// Requires root privileges because the admin kubeconfig is readable only by
 	// root.
 	// Use the admin kubeconfig because admin permissions are required to
 	// drain.
@@ -1045,7 +1442,7 @@ func drainNode(nodeName string, machineClient sshmachine.Client) error {
 }
 
 func deleteNode(nodeName string, machineClient sshmachine.Client) error {
-	// Requires sudo because the kubelet kubeconfig is readable by only by
+	// Requires root privileges because the kubelet kubeconfig is readable only by
 	// root.
 	cmd := fmt.Sprintf("%s --kubeconfig=%s delete node %s", common.KubectlFile, common.KubeletKubeconfig, nodeName)
 	stdOut, stdErr, err := machineClient.RunCommand(cmd)
@@ -1057,7 +1454,7 @@ func deleteNode(nodeName string, machineClient sshmachine.Client) error {
 }
 
 func uncordonNode(nodeName string, machineClient sshmachine.Client) error {
-	// Requires sudo because the kubelet kubeconfig is readable by only by
+	// Requires root privileges because the kubelet kubeconfig is readable only by
 	// root.
 	cmd := fmt.Sprintf("%s --kubeconfig=%s uncordon %s", common.KubectlFile, common.AdminKubeconfig, nodeName)
 	stdOut, stdErr, err := machineClient.RunCommand(cmd)
@@ -1068,6 +1465,281 @@ func uncordonNode(nodeName string, machineClient sshmachine.Client) error {
 	return nil
 }
 
+// labelNodeForMachine sets the labels of the machine on its cluster node.
+func labelNodeForMachine(machine *clusterv1.Machine, provisionedMachine *spv1.ProvisionedMachine) error {
+	machineClient, err := sshMachineClientFromSSHConfig(provisionedMachine.Spec.SSHConfig)
+	if err != nil {
+		return fmt.Errorf("unable to create machine client for machine %q: %v", machine.Name, err)
+	}
+	nodeName, err := nodeNameForMachine(machine.Name, machineClient)
+	if err != nil {
+		return fmt.Errorf("unable to get node name: %v", err)
+	}
+	if len(nodeName) == 0 {
+		return fmt.Errorf("unable to find cluster node for machine %q", machine.Name)
+	}
+	log.Printf("Labeling cluster node %q for machine %q", nodeName, machine.Name)
+	return labelNode(nodeName, machine.Spec.ObjectMeta.Labels, machineClient)
+}
+
+func labelNode(nodeName string, labels map[string]string, machineClient sshmachine.Client) error {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, labels[k]))
+	}
+	// Requires root privileges because the admin kubeconfig is readable only by
+	// root.
+	cmd := fmt.Sprintf("%s --kubeconfig=%s label node %s --overwrite %s", common.KubectlFile, common.AdminKubeconfig, nodeName, strings.Join(pairs, " "))
+	stdOut, stdErr, err := machineClient.RunCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("error running %q: %v (%s) (%s)", cmd, err, string(stdOut), string(stdErr))
+	}
+	log.Println(string(stdOut))
+	return nil
+}
+
+func taintNode(nodeName string, taints []corev1.Taint, machineClient sshmachine.Client) error {
+	var specs []string
+	for _, taint := range taints {
+		if len(taint.Value) == 0 {
+			specs = append(specs, fmt.Sprintf("%s:%s", taint.Key, taint.Effect))
+			continue
+		}
+		specs = append(specs, fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect))
+	}
+	// Requires root privileges because the admin kubeconfig is readable only by
+	// root.
+	cmd := fmt.Sprintf("%s --kubeconfig=%s taint node %s --overwrite %s", common.KubectlFile, common.AdminKubeconfig, nodeName, strings.Join(specs, " "))
+	stdOut, stdErr, err := machineClient.RunCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("error running %q: %v (%s) (%s)", cmd, err, string(stdOut), string(stdErr))
+	}
+	log.Println(string(stdOut))
+	return nil
+}
+
+// machineCmdCheck represents the machine check command
+var machineCmdCheck = &cobra.Command{
+	Use:   "machine",
+	Short: "Runs preflight checks on a machine",
+	Run: func(cmd *cobra.Command, args []string) {
+		ip := cmd.Flag("ip").Value.String()
+		if len(ip) == 0 {
+			log.Fatalf("Use --ip to specify the machine")
+		}
+		cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				log.Fatalf("No cluster found. Create a cluster before checking a machine.")
+			}
+			log.Fatalf("Unable to get cluster: %v", err)
+		}
+
+		var sshConfig *spv1.SSHConfig
+		var role clustercommon.MachineRole
+		machine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(ip, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			// The machine is in the state; check it as it was created.
+			provisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(ip, metav1.GetOptions{})
+			if err != nil {
+				log.Fatalf("Unable to get provisioned machine %q: %v", ip, err)
+			}
+			sshConfig = provisionedMachine.Spec.SSHConfig
+			role = clustercommon.NodeRole
+			if clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles) {
+				role = clustercommon.MasterRole
+			}
+		case apierrors.IsNotFound(err):
+			role = clustercommon.MachineRole(strings.Title(cmd.Flag("role").Value.String()))
+			if role != clustercommon.MasterRole && role != clustercommon.NodeRole {
+				log.Fatalf("Machine %q is not in the state. Use --role to specify %q or %q", ip, common.MasterRole, common.NodeRole)
+			}
+			port, err := cmd.Flags().GetInt("port")
+			if err != nil {
+				log.Fatalf("Invalid port %v", err)
+			}
+			sshConfig = &spv1.SSHConfig{
+				Host: ip,
+				Port: port,
+				CredentialSecret: corev1.LocalObjectReference{
+					Name: cmd.Flag("credential").Value.String(),
+				},
+			}
+		default:
+			log.Fatalf("Unable to get machine %q: %v", ip, err)
+		}
+
+		jumpHosts, err := jumpHostsFromFlag(cmd)
+		if err != nil {
+			log.Fatalf("Unable to load jump hosts: %v", err)
+		}
+		if len(jumpHosts) == 0 {
+			if jumpHosts, err = jumpHostsForSSHConfig(sshConfig); err != nil {
+				log.Fatalf("Unable to find jump hosts: %v", err)
+			}
+		}
+		machineClient, err := sshMachineClientWithJumpHosts(sshConfig, jumpHosts)
+		if err != nil {
+			log.Fatalf("Unable to create machine client: %v", err)
+		}
+		results, err := runPreflightChecks(machineClient, role, cluster, ip)
+		if err != nil {
+			log.Fatalf("Unable to run preflight checks: %v", err)
+		}
+		if preflight.Failed(results) {
+			os.Exit(1)
+		}
+	},
+}
+
+var machineCmdScanKeys = &cobra.Command{
+	Use:   "machine",
+	Short: "Scans the SSH host keys of a machine",
+	Long: `Scans the SSH host keys of a machine, and compares them with the keys
+recorded in the state and, if --known-hosts is given, the keys listed in the
+known_hosts file. Use --record to record the scanned keys for a machine in the
+state, so that only these keys are accepted afterwards.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ip := cmd.Flag("ip").Value.String()
+		if len(ip) == 0 {
+			log.Fatalf("Use --ip to specify the machine")
+		}
+		record, err := cmd.Flags().GetBool("record")
+		if err != nil {
+			log.Fatalf("Unable to parse --record: %v", err)
+		}
+		var sshConfig *spv1.SSHConfig
+		provisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(ip, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			sshConfig = provisionedMachine.Spec.SSHConfig
+		case apierrors.IsNotFound(err):
+			if record {
+				log.Fatalf("Machine %q is not in the state. Use --trust-on-first-use when creating the machine to record its keys.", ip)
+			}
+			port, err := cmd.Flags().GetInt("port")
+			if err != nil {
+				log.Fatalf("Invalid port %v", err)
+			}
+			sshConfig = &spv1.SSHConfig{
+				Host: ip,
+				Port: port,
+				CredentialSecret: corev1.LocalObjectReference{
+					Name: cmd.Flag("credential").Value.String(),
+				},
+			}
+		default:
+			log.Fatalf("Unable to get provisioned machine %q: %v", ip, err)
+		}
+
+		jumpHosts, err := jumpHostsFromFlag(cmd)
+		if err != nil {
+			log.Fatalf("Unable to load jump hosts: %v", err)
+		}
+		if len(jumpHosts) == 0 {
+			if jumpHosts, err = jumpHostsForSSHConfig(sshConfig); err != nil {
+				log.Fatalf("Unable to find jump hosts: %v", err)
+			}
+		}
+		endpoints, err := jumpHostEndpoints(jumpHosts)
+		if err != nil {
+			log.Fatalf("Unable to reach jump hosts: %v", err)
+		}
+		kh, err := loadKnownHosts()
+		if err != nil {
+			log.Fatalf("Unable to load known_hosts: %v", err)
+		}
+		keys, err := machineclient.ScanHostKeys(machineclient.Endpoint{Host: sshConfig.Host, Port: sshConfig.Port}, endpoints)
+		if err != nil {
+			log.Fatalf("Unable to scan host keys: %v", err)
+		}
+		recorded := map[string]bool{}
+		for _, publicKey := range sshConfig.PublicKeys {
+			if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey)); err == nil {
+				recorded[string(key.Marshal())] = true
+			}
+		}
+
+		type hostKey struct {
+			Type        string
+			Fingerprint string
+			Status      string
+		}
+		summary := struct {
+			Machine string
+			Keys    []hostKey
+		}{Machine: ip}
+		publicKeys := []string{}
+		var matchesRecorded, matchesListed, isListed, isRevoked bool
+		for _, key := range keys {
+			k := hostKey{Type: key.Type(), Fingerprint: ssh.FingerprintSHA256(key), Status: "unverified"}
+			var knownHostsErr error
+			if kh != nil {
+				knownHostsErr = kh.Check(sshConfig.Host, sshConfig.Port, key)
+				if keyErr, ok := knownHostsErr.(*knownhosts.KeyError); knownHostsErr == nil || ok && len(keyErr.Want) != 0 {
+					isListed = true
+				}
+			}
+			_, revoked := knownHostsErr.(*knownhosts.RevokedError)
+			switch {
+			case revoked:
+				k.Status = "revoked"
+				isRevoked = true
+			case recorded[string(key.Marshal())]:
+				k.Status = "recorded"
+				matchesRecorded = true
+			case kh != nil && knownHostsErr == nil:
+				k.Status = "known-hosts"
+				matchesListed = true
+			}
+			summary.Keys = append(summary.Keys, k)
+			publicKeys = append(publicKeys, string(ssh.MarshalAuthorizedKey(key)))
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		t := template.Must(template.New("HostKeyPrintTemplate").Parse(common.HostKeyPrintTemplate))
+		if err := t.Execute(w, summary); err != nil {
+			log.Fatalf("Could not pretty print host keys: %s", err)
+		}
+		w.Flush()
+
+		mismatch := false
+		if isRevoked {
+			log.Errorf("Machine %q presented a key that is revoked in known_hosts file %q.", ip, kh.Filename)
+			mismatch = true
+		}
+		if len(sshConfig.PublicKeys) != 0 && !matchesRecorded {
+			log.Errorf("None of the keys of machine %q match its recorded keys (%s). The machine may have been reinstalled, or the connection may be intercepted.", ip, strings.Join(hostKeyFingerprints(sshConfig.PublicKeys), ", "))
+			mismatch = true
+		}
+		if isListed && !matchesListed && !matchesRecorded {
+			log.Errorf("None of the keys of machine %q match the keys listed in known_hosts file %q.", ip, kh.Filename)
+			mismatch = true
+		}
+		if !record {
+			if mismatch {
+				os.Exit(1)
+			}
+			return
+		}
+		if isRevoked {
+			log.Fatalf("Not recording the keys of machine %q, because one is revoked.", ip)
+		}
+		if mismatch {
+			log.Warnf("Replacing the recorded keys of machine %q. Verify the new keys out of band before trusting them.", ip)
+		}
+		if err := recordHostKeys(sshConfig.Host, sshConfig.Port, publicKeys); err != nil {
+			log.Fatalf("Unable to record host keys: %v", err)
+		}
+		log.Printf("Recorded %d SSH host keys of machine %q", len(publicKeys), ip)
+	},
+}
+
 var machineCmdUpgrade = &cobra.Command{
 	Use:   "machine",
 	Short: "Upgrade machine",
@@ -1084,7 +1756,7 @@ var machineBundleCmd = &cobra.Command{
 	Short: "Create a support bundle for a node",
 	Run: func(cmd *cobra.Command, args []string) {
 		ip := cmd.Flag("ip").Value.String()
-		targetMachine, err := state.ClusterClient.ClusterV1alpha1().Machines(common.DefaultNamespace).Get(ip, metav1.GetOptions{})
+		targetMachine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(ip, metav1.GetOptions{})
 		if err != nil {
 			log.Fatalf("Unable to get machine %q: %v", ip, err)
 		}
@@ -1092,7 +1764,7 @@ var machineBundleCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Unable to decode machine %q spec: %v", targetMachine.Name, err)
 		}
-		targetProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).Get(targetMachineSpec.ProvisionedMachineName, metav1.GetOptions{})
+		targetProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(targetMachineSpec.ProvisionedMachineName, metav1.GetOptions{})
 		if err != nil {
 			log.Fatalf("Unable to get provisioned machine %q: %v", targetMachineSpec.ProvisionedMachineName, err)
 		}
@@ -1127,19 +1799,42 @@ func init() {
 	machineCmdCreate.Flags().String("ip", "", "IP of the machine")
 	machineCmdCreate.Flags().Int("port", common.DefaultSSHPort, "SSH port")
 	machineCmdCreate.Flags().String("role", "", "Role of the machine. Can be master/node")
-	machineCmdCreate.Flags().StringSlice("public-keys", []string{}, "The machine's SSH public keys. Provide a comma-separated list, or define multiple flags.")
+	machineCmdCreate.Flags().StringSlice("public-keys", []string{}, "The machine's SSH public keys. Provide a comma-separated list, or define multiple flags. If none are given, the keys are checked against --known-hosts, or recorded with --trust-on-first-use")
 	machineCmdCreate.Flags().String("iface", "eth0", "Interface that keepalived will bind to in case of master")
+	machineCmdCreate.Flags().StringSlice("labels", []string{}, "Labels of the machine's node, in the form key=value. Provide a comma-separated list, or define multiple flags")
+	machineCmdCreate.Flags().StringSlice("taints", []string{}, "Taints of the machine's node, in the form key[=value]:effect. Provide a comma-separated list, or define multiple flags")
+	machineCmdCreate.Flags().String("kubelet-config-file", "", "File with kubelet configuration that overrides the kubelet configuration of the cluster on the machine")
+	machineCmdCreate.Flags().String("credential", common.DefaultSSHCredentialSecretName, "Name of the SSH credential used to reach the machine")
+	machineCmdCreate.Flags().String("jump-hosts-file", "", "File listing the jump hosts through which the machine is reached")
+	machineCmdCreate.Flags().Bool("resume", false, "Continue creating a machine after its last completed phase")
+	machineCmdCreate.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "Do not run preflight checks on the machine")
+
+	checkCmd.AddCommand(machineCmdCheck)
+	machineCmdCheck.Flags().String("ip", "", "IP of the machine")
+	machineCmdCheck.Flags().Int("port", common.DefaultSSHPort, "SSH port, if the machine is not in the state")
+	machineCmdCheck.Flags().String("credential", common.DefaultSSHCredentialSecretName, "Name of the SSH credential, if the machine is not in the state")
+	machineCmdCheck.Flags().String("jump-hosts-file", "", "File listing the jump hosts through which the machine is reached. Overrides the jump hosts in the state")
+	machineCmdCheck.Flags().String("role", "", "Role of the machine, if the machine is not in the state. Can be master/node")
+
+	scanKeysCmd.AddCommand(machineCmdScanKeys)
+	machineCmdScanKeys.Flags().String("ip", "", "IP of the machine")
+	machineCmdScanKeys.Flags().Int("port", common.DefaultSSHPort, "SSH port, if the machine is not in the state")
+	machineCmdScanKeys.Flags().String("credential", common.DefaultSSHCredentialSecretName, "Name of the SSH credential, used to find jump hosts, if the machine is not in the state")
+	machineCmdScanKeys.Flags().String("jump-hosts-file", "", "File listing the jump hosts through which the machine is reached. Overrides the jump hosts in the state")
+	machineCmdScanKeys.Flags().Bool("record", false, "Record the scanned keys in the state, replacing any recorded keys")
 
 	deleteCmd.AddCommand(machineCmdDelete)
 	machineCmdDelete.Flags().String("ip", "", "IP of the machine")
 	machineCmdDelete.Flags().Bool("force", false, "Force delete the machine")
 	machineCmdDelete.Flags().Bool("skip-drain-delete", false, "Do not drain and delete the cluster node for the machine")
+	machineCmdDelete.Flags().Bool("i-understand-quorum-loss", false, "Delete a master even if the etcd cluster has no quorum, would lose it, or its quorum cannot be checked")
 	machineCmdDelete.Flags().DurationVar(&drainTimeout, "drain-timeout", common.DrainTimeout, "The length of time to wait before giving up, zero means infinite")
 	machineCmdDelete.Flags().IntVar(&drainGracePeriodSeconds, "drain-grace-period", common.DrainGracePeriodSeconds, "Period of time in seconds given to each pod to terminate gracefully. If negative, the default value specified in the pod will be used.")
 	machineCmdDelete.Flags().BoolVar(&drainDeleteLocalData, "drain-delete-local-data", common.DrainDeleteLocalData, "Continue even if there are pods using emptyDir (local data that will be deleted when the node is drained).")
 	machineCmdDelete.Flags().BoolVar(&drainForce, "drain-force", common.DrainForce, "Continue even if there are pods not managed by a ReplicationController, ReplicaSet, Job, DaemonSet or StatefulSet.")
 
 	machineCmdGet.Flags().String("ip", "", "IP of the machine")
+	machineCmdGet.Flags().Bool("live", false, "Add the node name, Ready condition and kubelet version reported by the cluster to the table")
 	getCmd.AddCommand(machineCmdGet)
 
 	machineCmdUpgrade.Flags().String("ip", "", "IP of the machine")
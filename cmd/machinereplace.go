@@ -0,0 +1,382 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/etcdcluster"
+	"github.com/platform9/cctl/pkg/inventory"
+	log "github.com/platform9/cctl/pkg/logrus"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+
+	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterutil "sigs.k8s.io/cluster-api/pkg/util"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// healthyMaster returns a created master, other than the excluded machine,
+// whose etcd member is healthy, along with its client and the etcd cluster as
+// seen from it.
+func healthyMaster(exclude string) (*clusterv1.Machine, sshmachine.Client, *etcdcluster.Status, error) {
+	machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to list machines: %v", err)
+	}
+	for i := range machineList.Items {
+		machine := &machineList.Items[i]
+		if machine.Name == exclude || !clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles) || !isCreated(machine) {
+			continue
+		}
+		machineStatus, err := sputil.GetMachineStatus(*machine)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to decode machine %q status: %v", machine.Name, err)
+		}
+		if machineStatus.EtcdMember == nil {
+			continue
+		}
+		machineClient, err := sshMachineClientForMachine(machine)
+		if err != nil {
+			log.Warnf("Unable to reach master %q: %v", machine.Name, err)
+			continue
+		}
+		status, err := etcdcluster.Read(machineClient)
+		if err != nil {
+			log.Warnf("Unable to read etcd cluster from master %q: %v", machine.Name, err)
+			continue
+		}
+		if !status.Healthy[machineStatus.EtcdMember.ID] {
+			log.Warnf("Etcd member of master %q is not healthy", machine.Name)
+			continue
+		}
+		return machine, machineClient, status, nil
+	}
+	return nil, nil, nil, fmt.Errorf("unable to find a master with a healthy etcd member")
+}
+
+// setClusterEtcdMembers records the members of the etcd cluster in the
+// cluster status, healthy members first. A new master joins the etcd cluster
+// through the first member.
+func setClusterEtcdMembers(status *etcdcluster.Status, cluster *clusterv1.Cluster) error {
+	clusterStatus, err := sputil.GetClusterStatus(*cluster)
+	if err != nil {
+		return fmt.Errorf("unable to decode cluster status: %v", err)
+	}
+	members := []spv1.EtcdMember{}
+	for _, healthy := range []bool{true, false} {
+		for _, member := range status.Members {
+			if status.Healthy[member.ID] == healthy {
+				members = append(members, member)
+			}
+		}
+	}
+	clusterStatus.EtcdMembers = members
+	if err := sputil.PutClusterStatus(*clusterStatus, cluster); err != nil {
+		return fmt.Errorf("unable to encode cluster status: %v", err)
+	}
+	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).UpdateStatus(cluster); err != nil {
+		return fmt.Errorf("unable to update cluster: %v", err)
+	}
+	return nil
+}
+
+// removeEtcdMemberOfMachine removes the etcd member of the machine from the
+// etcd cluster, through the master, and records the remaining members in the
+// cluster status. The etcd cluster must have quorum.
+func removeEtcdMemberOfMachine(machine *clusterv1.Machine, masterClient sshmachine.Client, status *etcdcluster.Status, cluster *clusterv1.Cluster) error {
+	if healthy, quorum := status.HealthyMembers(), etcdcluster.Quorum(len(status.Members)); healthy < quorum {
+		return fmt.Errorf("etcd cluster has no quorum: %d of %d members are healthy, %d are needed. Use `cctl recover etcd` to recover it", healthy, len(status.Members), quorum)
+	}
+	machineStatus, err := sputil.GetMachineStatus(*machine)
+	if err != nil {
+		return fmt.Errorf("unable to decode machine %q status: %v", machine.Name, err)
+	}
+	if machineStatus.EtcdMember == nil {
+		log.Printf("Machine %q has no recorded etcd member", machine.Name)
+	} else if member, ok := status.Member(machineStatus.EtcdMember.ID, machineStatus.EtcdMember.Name); !ok {
+		log.Printf("Etcd member %q of machine %q is not in the etcd cluster", machineStatus.EtcdMember.Name, machine.Name)
+	} else {
+		log.Printf("Removing etcd member %q (%s) of machine %q", member.Name, strconv.FormatUint(member.ID, 16), machine.Name)
+		if err := etcdcluster.RemoveMember(masterClient, member.ID); err != nil {
+			return fmt.Errorf("unable to remove etcd member: %v", err)
+		}
+	}
+	remaining, err := etcdcluster.Read(masterClient)
+	if err != nil {
+		return fmt.Errorf("unable to read etcd cluster: %v", err)
+	}
+	return setClusterEtcdMembers(remaining, cluster)
+}
+
+// nodeNameOfMachine returns the name of the cluster node of the machine,
+// found with the admin kubeconfig, or an empty string if there is none.
+func nodeNameOfMachine(kubeconfig string, machine *clusterv1.Machine) (string, error) {
+	nodes, err := common.Nodes(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+	node, ok := nodeForMachine(machineSummary{IP: machine.Name, productUUID: machine.Annotations[common.ProductUUIDAnnotationKey]}, nodes)
+	if !ok {
+		return "", nil
+	}
+	return node.Name, nil
+}
+
+// deleteNodeOfMachine deletes the cluster node of the machine, using the
+// admin kubeconfig, without reaching the machine.
+func deleteNodeOfMachine(machine *clusterv1.Machine) error {
+	if err := createAdminKubeConfigSecretIfNotPresent(); err != nil {
+		return fmt.Errorf("unable to create admin kubeconfig secret: %v", err)
+	}
+	kubeconfig, err := createLocalCopyOfAdminKubeConfig()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(kubeconfig)
+	nodeName, err := nodeNameOfMachine(kubeconfig, machine)
+	if err != nil {
+		return err
+	}
+	if len(nodeName) == 0 {
+		log.Printf("Machine %q has no cluster node", machine.Name)
+		return nil
+	}
+	log.Printf("Deleting cluster node %q of machine %q", nodeName, machine.Name)
+	return common.DeleteNode(kubeconfig, nodeName)
+}
+
+// removeMachineFromState deletes the machine and its provisioned machine
+// from the state, and the API endpoint of the machine, if it has its own,
+// from the cluster status.
+func removeMachineFromState(machine *clusterv1.Machine, provisionedMachine *spv1.ProvisionedMachine, cluster *clusterv1.Cluster) error {
+	if err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Delete(machine.Name, &metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("unable to delete machine %q: %v", machine.Name, err)
+	}
+	if err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Delete(provisionedMachine.Name, &metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("unable to delete provisioned machine %q: %v", provisionedMachine.Name, err)
+	}
+	if clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles) {
+		if err := removeAPIEndpointsOfMachine(machine, provisionedMachine, cluster); err != nil {
+			return err
+		}
+	}
+	return syncState()
+}
+
+// removeAPIEndpointsOfMachine removes the API endpoints of the master from the
+// cluster status.
+func removeAPIEndpointsOfMachine(machine *clusterv1.Machine, provisionedMachine *spv1.ProvisionedMachine, cluster *clusterv1.Cluster) error {
+	apiEndpoints := []clusterv1.APIEndpoint{}
+	for _, apiEndpoint := range cluster.Status.APIEndpoints {
+		if apiEndpoint.Host != provisionedMachine.Spec.SSHConfig.Host && apiEndpoint.Host != machine.Name {
+			apiEndpoints = append(apiEndpoints, apiEndpoint)
+		}
+	}
+	cluster.Status.APIEndpoints = apiEndpoints
+	if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).UpdateStatus(cluster); err != nil {
+		return fmt.Errorf("unable to update cluster state: %v", err)
+	}
+	return nil
+}
+
+// verifyReplacement checks that the etcd cluster is healthy and includes the
+// new master, and that its API endpoint is recorded.
+func verifyReplacement(newIP string, masterClient sshmachine.Client) error {
+	newMachine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(newIP, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get machine %q: %v", newIP, err)
+	}
+	newMachineStatus, err := sputil.GetMachineStatus(*newMachine)
+	if err != nil {
+		return fmt.Errorf("unable to decode machine %q status: %v", newIP, err)
+	}
+	if newMachineStatus.EtcdMember == nil {
+		return fmt.Errorf("machine %q has no recorded etcd member", newIP)
+	}
+	status, err := etcdcluster.Read(masterClient)
+	if err != nil {
+		return fmt.Errorf("unable to read etcd cluster: %v", err)
+	}
+	if _, ok := status.Member(newMachineStatus.EtcdMember.ID, newMachineStatus.EtcdMember.Name); !ok {
+		return fmt.Errorf("etcd member %q of machine %q is not in the etcd cluster", newMachineStatus.EtcdMember.Name, newIP)
+	}
+	log.Printf("Etcd cluster has %d members, %d healthy, and fault tolerance %d", len(status.Members), status.HealthyMembers(), etcdcluster.FaultTolerance(len(status.Members)))
+	if status.HealthyMembers() != len(status.Members) {
+		return fmt.Errorf("%d of %d etcd members are not healthy", len(status.Members)-status.HealthyMembers(), len(status.Members))
+	}
+	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get cluster: %v", err)
+	}
+	if len(cluster.Status.APIEndpoints) == 0 {
+		return fmt.Errorf("cluster has no API endpoints")
+	}
+	endpoints := []string{}
+	for _, apiEndpoint := range cluster.Status.APIEndpoints {
+		endpoints = append(endpoints, fmt.Sprintf("%s:%d", apiEndpoint.Host, apiEndpoint.Port))
+	}
+	log.Printf("Cluster API endpoints: %s", strings.Join(endpoints, ", "))
+	return nil
+}
+
+// replaceMachine replaces the machine at oldIP with the machine m, which is
+// given the role, VIP interface, labels, taints and kubelet configuration
+// override of the old machine. The old machine is not reached, because it is
+// assumed to have failed.
+func replaceMachine(oldIP string, m inventory.Machine) error {
+	oldMachine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(oldIP, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get machine %q: %v", oldIP, err)
+	}
+	oldMachineSpec, err := sputil.GetMachineSpec(*oldMachine)
+	if err != nil {
+		return fmt.Errorf("unable to decode machine %q spec: %v", oldIP, err)
+	}
+	oldProvisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(oldMachineSpec.ProvisionedMachineName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get provisioned machine %q: %v", oldMachineSpec.ProvisionedMachineName, err)
+	}
+	if m.IP != oldIP {
+		if _, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(m.IP, metav1.GetOptions{}); err == nil {
+			return fmt.Errorf("machine %q already exists", m.IP)
+		} else if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to get machine %q: %v", m.IP, err)
+		}
+	}
+	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get cluster: %v", err)
+	}
+
+	isMaster := clusterutil.RoleContains(clustercommon.MasterRole, oldMachine.Spec.Roles)
+	m.Role = inventory.RoleNode
+	if isMaster {
+		m.Role = inventory.RoleMaster
+	}
+	m.Iface = oldProvisionedMachine.Spec.VIPNetworkInterface
+	if len(m.Iface) == 0 {
+		m.Iface = "eth0"
+	}
+	if len(m.Credential) == 0 {
+		m.Credential = oldProvisionedMachine.Spec.SSHConfig.CredentialSecret.Name
+	}
+	if m.Port == 0 {
+		m.Port = oldProvisionedMachine.Spec.SSHConfig.Port
+	}
+
+	// Check what can be checked before the failed machine is removed.
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(m.Credential, metav1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("no SSH credential %q found. Create the credential before replacing a machine", m.Credential)
+		}
+		return fmt.Errorf("unable to get SSH credential secret: %v", err)
+	}
+
+	var masterClient sshmachine.Client
+	if isMaster {
+		master, client, status, err := healthyMaster(oldIP)
+		if err != nil {
+			return err
+		}
+		log.Printf("Using master %q to change the etcd cluster", master.Name)
+		masterClient = client
+		if err := removeEtcdMemberOfMachine(oldMachine, masterClient, status, cluster); err != nil {
+			return err
+		}
+	}
+	log.Printf("Removing machine %q from the state", oldIP)
+	if err := removeMachineFromState(oldMachine, oldProvisionedMachine, cluster); err != nil {
+		return err
+	}
+	if err := deleteNodeOfMachine(oldMachine); err != nil {
+		log.Warnf("Unable to delete cluster node of machine %q: %v. Delete it with kubectl", oldIP, err)
+	}
+
+	log.Printf("Creating machine %q as a %s", m.IP, m.Role)
+	if err := createMachineLike(m, oldMachine, true, false); err != nil {
+		if _, getErr := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(m.IP, metav1.GetOptions{}); getErr == nil {
+			return fmt.Errorf("unable to create machine %q: %v. Use `cctl create machine --ip %s --resume` to continue", m.IP, err, m.IP)
+		}
+		return fmt.Errorf("unable to create machine %q: %v. Machine %q is removed from the cluster. Fix the failure, and use `cctl create machine --ip %s --role %s` to create the new machine", m.IP, err, oldIP, m.IP, m.Role)
+	}
+	if isMaster {
+		if err := verifyReplacement(m.IP, masterClient); err != nil {
+			return fmt.Errorf("machine %q replaced, but the cluster is not healthy: %v", oldIP, err)
+		}
+	}
+	return nil
+}
+
+// machineCmdReplace represents the machine replace command
+var machineCmdReplace = &cobra.Command{
+	Use:   "machine",
+	Short: "Replaces a failed machine with a new machine",
+	Long: `Replaces a failed machine with a new machine of the same role. The etcd
+member of a failed master is removed through a healthy master, and the
+cluster node of the failed machine is deleted. The failed machine is not
+reached. The new machine may have the IP of the failed machine.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		oldIP := cmd.Flag("old-ip").Value.String()
+		newIP := cmd.Flag("new-ip").Value.String()
+		port, err := cmd.Flags().GetInt("port")
+		if err != nil {
+			log.Fatalf("Unable to parse `port`: %v", err)
+		}
+		publicKeyFiles, err := cmd.Flags().GetStringSlice("public-keys")
+		if err != nil {
+			log.Fatalf("Unable to parse `public-keys`: %v", err)
+		}
+		m := inventory.Machine{
+			IP:         newIP,
+			Port:       port,
+			PublicKeys: publicKeyFiles,
+			Credential: cmd.Flag("credential").Value.String(),
+		}
+		if m.JumpHosts, err = jumpHostsFromFlag(cmd); err != nil {
+			log.Fatalf("Unable to load jump hosts: %v", err)
+		}
+		if err := replaceMachine(oldIP, m); err != nil {
+			log.Fatalf("Unable to replace machine: %v", err)
+		}
+		log.Println("Machine replaced successfully.")
+	},
+}
+
+func init() {
+	replaceCmd.AddCommand(machineCmdReplace)
+	machineCmdReplace.Flags().String("old-ip", "", "IP of the failed machine")
+	machineCmdReplace.MarkFlagRequired("old-ip")
+	machineCmdReplace.Flags().String("new-ip", "", "IP of the new machine")
+	machineCmdReplace.MarkFlagRequired("new-ip")
+	machineCmdReplace.Flags().Int("port", 0, "SSH port of the new machine. Defaults to the port of the failed machine")
+	machineCmdReplace.Flags().StringSlice("public-keys", []string{}, "The new machine's SSH public keys. Provide a comma-separated list, or define multiple flags. If none are given, the keys are checked against --known-hosts, or recorded with --trust-on-first-use")
+	machineCmdReplace.Flags().String("credential", "", "Name of the SSH credential used to reach the new machine. Defaults to the credential of the failed machine")
+	machineCmdReplace.Flags().String("jump-hosts-file", "", "File listing the jump hosts through which the new machine is reached")
+	machineCmdReplace.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "Do not run preflight checks on the new machine")
+}
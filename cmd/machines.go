@@ -0,0 +1,182 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/inventory"
+	log "github.com/platform9/cctl/pkg/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// machineResult is the outcome of creating one machine of an inventory.
+type machineResult struct {
+	IP      string
+	Role    string
+	Err     error
+	Skipped bool
+}
+
+// Result describes the outcome for the summary table.
+func (mr machineResult) Result() string {
+	switch {
+	case mr.Skipped:
+		return "skipped"
+	case mr.Err != nil:
+		return fmt.Sprintf("failed: %v", mr.Err)
+	default:
+		return "created"
+	}
+}
+
+// createMachines creates the machines of the inventory, and returns the
+// outcome for every machine. Masters are created one at a time, because a
+// master joins the etcd cluster formed by the masters created before it. If a
+// master is not created, no other machine is created. Nodes are created
+// concurrently, at most parallelism at a time.
+func createMachines(inv *inventory.Inventory, parallelism int) []machineResult {
+	var results []machineResult
+	failed := false
+	for _, m := range inv.Masters() {
+		if failed {
+			results = append(results, machineResult{IP: m.IP, Role: m.Role, Skipped: true})
+			continue
+		}
+		log.Printf("Creating master %q", m.IP)
+		err := createMachine(m, true, false)
+		if err != nil {
+			log.Errorf("Unable to create master %q: %v", m.IP, err)
+			failed = true
+		}
+		results = append(results, machineResult{IP: m.IP, Role: m.Role, Err: err})
+	}
+
+	nodes := inv.Nodes()
+	if len(nodes) == 0 {
+		return results
+	}
+	if failed {
+		for _, m := range nodes {
+			results = append(results, machineResult{IP: m.IP, Role: m.Role, Skipped: true})
+		}
+		return results
+	}
+	// Refresh the bootstrap token and the admin kubeconfig once, instead of
+	// once for every node.
+	if err := prepareNodeCreation(); err != nil {
+		log.Errorf("Unable to prepare to create nodes: %v", err)
+		for _, m := range nodes {
+			results = append(results, machineResult{IP: m.IP, Role: m.Role, Err: err})
+		}
+		return results
+	}
+	nodeResults := make([]machineResult, len(nodes))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, m := range nodes {
+		wg.Add(1)
+		go func(i int, m inventory.Machine) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			log.Printf("Creating node %q", m.IP)
+			err := createMachine(m, false, false)
+			if err != nil {
+				log.Errorf("Unable to create node %q: %v", m.IP, err)
+			}
+			nodeResults[i] = machineResult{IP: m.IP, Role: m.Role, Err: err}
+		}(i, m)
+	}
+	wg.Wait()
+	return append(results, nodeResults...)
+}
+
+// prepareNodeCreation refreshes the bootstrap token, and creates the admin
+// kubeconfig secret if it is not present, so that nodes can then be created
+// concurrently.
+func prepareNodeCreation() error {
+	masterMachine, masterProvisionedMachine, err := masterMachineAndProvisionedMachine()
+	if err != nil {
+		return fmt.Errorf("unable to get a master machine and provisioned machine: %v", err)
+	}
+	if err := updateBootstrapToken(masterMachine, masterProvisionedMachine); err != nil {
+		return fmt.Errorf("unable to update bootstrap token: %v", err)
+	}
+	if err := createAdminKubeConfigSecretIfNotPresent(); err != nil {
+		return fmt.Errorf("unable to create admin kubeconfig secret: %v", err)
+	}
+	return nil
+}
+
+// machinesCmdCreate represents the machines create command
+var machinesCmdCreate = &cobra.Command{
+	Use:   "machines",
+	Short: "Adds the machines listed in an inventory file to the cluster",
+	Run: func(cmd *cobra.Command, args []string) {
+		filename := cmd.Flag("file").Value.String()
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			log.Fatalf("Unable to parse `parallelism`: %v", err)
+		}
+		if parallelism < 1 {
+			log.Fatalf("Parallelism must be at least 1")
+		}
+		inv, err := inventory.Load(filename)
+		if err != nil {
+			log.Fatalf("Unable to load inventory: %v", err)
+		}
+		if _, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{}); err != nil {
+			if apierrors.IsNotFound(err) {
+				log.Fatalf("No cluster found. Create a cluster before creating machines.")
+			}
+			log.Fatalf("Unable to get cluster: %v", err)
+		}
+
+		results := createMachines(inv, parallelism)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		t := template.Must(template.New("MachineResultPrintTemplate").Parse(common.MachineResultPrintTemplate))
+		if err := t.Execute(w, results); err != nil {
+			log.Fatalf("Could not pretty print results: %s", err)
+		}
+		w.Flush()
+		for _, result := range results {
+			if result.Err != nil || result.Skipped {
+				os.Exit(1)
+			}
+		}
+		log.Println("Machines created successfully.")
+	},
+}
+
+func init() {
+	createCmd.AddCommand(machinesCmdCreate)
+	machinesCmdCreate.Flags().StringP("file", "f", "", "Inventory file listing the machines")
+	machinesCmdCreate.MarkFlagRequired("file")
+	machinesCmdCreate.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "Do not run preflight checks on the machines")
+	machinesCmdCreate.Flags().Int("parallelism", common.DefaultMachineParallelism, "Maximum number of nodes to create at a time")
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/platform9/cctl/common"
+	log "github.com/platform9/cctl/pkg/logrus"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	outputWide              = "wide"
+	outputJSONPathPrefix    = "jsonpath="
+	outputGoTemplatePrefix  = "go-template="
+	machineSummaryNone      = "<none>"
+	machineSummaryUnknown   = "<unknown>"
+	machineSummaryNotJoined = "<not found>"
+)
+
+// machineSummary is one row of the machine table.
+type machineSummary struct {
+	IP                string
+	Roles             string
+	KubernetesVersion string
+	EtcdVersion       string
+	EtcdMemberName    string
+	EtcdMemberID      string
+	VIPInterface      string
+	HostKey           string
+	NodeadmVersion    string
+	EtcdadmVersion    string
+	CNIVersion        string
+	FlannelVersion    string
+	KeepalivedVersion string
+	Credential        string
+	Created           string
+	Node              string
+	Ready             string
+	KubeletVersion    string
+
+	productUUID string
+}
+
+func orNone(s string) string {
+	if len(s) == 0 {
+		return machineSummaryNone
+	}
+	return s
+}
+
+// summarizeMachine describes the machine using its spec, its status, and its
+// provisioned machine.
+func summarizeMachine(machine clusterv1.Machine) (machineSummary, error) {
+	summary := machineSummary{
+		IP:          machine.Name,
+		Created:     machine.CreationTimestamp.UTC().Format("2006-01-02T15:04:05Z"),
+		productUUID: machine.Annotations[common.ProductUUIDAnnotationKey],
+		Node:        machineSummaryUnknown,
+		Ready:       machineSummaryUnknown,
+		// The node is unknown until its live status is added
+		KubeletVersion: machineSummaryUnknown,
+	}
+	machineSpec, err := sputil.GetMachineSpec(machine)
+	if err != nil {
+		return summary, fmt.Errorf("unable to decode machine %q spec: %v", machine.Name, err)
+	}
+	machineStatus, err := sputil.GetMachineStatus(machine)
+	if err != nil {
+		return summary, fmt.Errorf("unable to decode machine %q status: %v", machine.Name, err)
+	}
+	roles := []string{}
+	for _, role := range machineSpec.Roles {
+		roles = append(roles, string(role))
+	}
+	summary.Roles = orNone(strings.Join(roles, ","))
+	var versions spv1.MachineComponentVersions
+	if machineSpec.ComponentVersions != nil {
+		versions = *machineSpec.ComponentVersions
+	}
+	summary.KubernetesVersion = orNone(versions.KubernetesVersion)
+	summary.EtcdVersion = orNone(versions.EtcdVersion)
+	summary.NodeadmVersion = orNone(versions.NodeadmVersion)
+	summary.EtcdadmVersion = orNone(versions.EtcdadmVersion)
+	summary.CNIVersion = orNone(versions.CNIVersion)
+	summary.FlannelVersion = orNone(versions.FlannelVersion)
+	summary.KeepalivedVersion = orNone(versions.KeepalivedVersion)
+	summary.VIPInterface = orNone(machineStatus.VIPNetworkInterface)
+	summary.EtcdMemberName = machineSummaryNone
+	summary.EtcdMemberID = machineSummaryNone
+	if machineStatus.EtcdMember != nil {
+		summary.EtcdMemberName = orNone(machineStatus.EtcdMember.Name)
+		summary.EtcdMemberID = strconv.FormatUint(machineStatus.EtcdMember.ID, 16)
+	}
+	summary.HostKey = machineSummaryUnknown
+	summary.Credential = machineSummaryUnknown
+	if len(machineSpec.ProvisionedMachineName) != 0 {
+		pm, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(machineSpec.ProvisionedMachineName, metav1.GetOptions{})
+		if err != nil {
+			return summary, fmt.Errorf("unable to get provisioned machine %q: %v", machineSpec.ProvisionedMachineName, err)
+		}
+		if pm.Spec.SSHConfig != nil {
+			summary.HostKey = "none"
+			if len(pm.Spec.SSHConfig.PublicKeys) != 0 {
+				summary.HostKey = "pinned"
+			}
+			summary.Credential = orNone(pm.Spec.SSHConfig.CredentialSecret.Name)
+		}
+	}
+	return summary, nil
+}
+
+// nodeReady describes the Ready condition of the node the way kubectl does.
+func nodeReady(node corev1.Node) string {
+	ready := "Unknown"
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != corev1.NodeReady {
+			continue
+		}
+		switch condition.Status {
+		case corev1.ConditionTrue:
+			ready = "Ready"
+		case corev1.ConditionFalse:
+			ready = "NotReady"
+		}
+	}
+	if node.Spec.Unschedulable {
+		ready += ",SchedulingDisabled"
+	}
+	return ready
+}
+
+// nodeForMachine returns the node whose system UUID matches the product UUID
+// of the machine or, if the machine has no recorded product UUID, the node
+// with the IP of the machine.
+func nodeForMachine(summary machineSummary, nodes []corev1.Node) (corev1.Node, bool) {
+	for _, node := range nodes {
+		if len(summary.productUUID) != 0 && strings.EqualFold(node.Status.NodeInfo.SystemUUID, summary.productUUID) {
+			return node, true
+		}
+	}
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP && address.Address == summary.IP {
+				return node, true
+			}
+		}
+	}
+	return corev1.Node{}, false
+}
+
+// addLiveNodeStatus adds the status of the node of every machine, as reported
+// by the API server of the cluster. If the API server cannot be reached, the
+// status is left unknown.
+func addLiveNodeStatus(summaries []machineSummary) {
+	kubeconfig, err := createLocalCopyOfAdminKubeConfig()
+	if err != nil {
+		log.Warnf("Unable to get node status: %v", err)
+		return
+	}
+	defer os.Remove(kubeconfig)
+	nodes, err := common.Nodes(kubeconfig)
+	if err != nil {
+		log.Warnf("Unable to get node status: %v", err)
+		return
+	}
+	for i := range summaries {
+		node, ok := nodeForMachine(summaries[i], nodes)
+		if !ok {
+			summaries[i].Node = machineSummaryNotJoined
+			summaries[i].Ready = machineSummaryNone
+			summaries[i].KubeletVersion = machineSummaryNone
+			continue
+		}
+		summaries[i].Node = node.Name
+		summaries[i].Ready = nodeReady(node)
+		summaries[i].KubeletVersion = orNone(node.Status.NodeInfo.KubeletVersion)
+	}
+}
+
+// printMachines writes the machines in the output format. The table formats
+// describe the machines, and, if live is true, the status of their nodes. The
+// other formats write the machine objects.
+func printMachines(w io.Writer, machines []clusterv1.Machine, format string, live bool) error {
+	switch {
+	case format == "yaml":
+		bytes, err := yaml.Marshal(machines)
+		if err != nil {
+			return fmt.Errorf("unable to marshal machines to yaml: %v", err)
+		}
+		_, err = w.Write(bytes)
+		return err
+	case format == "json":
+		bytes, err := json.Marshal(machines)
+		if err != nil {
+			return fmt.Errorf("unable to marshal machines to json: %v", err)
+		}
+		_, err = w.Write(bytes)
+		return err
+	case strings.HasPrefix(format, outputJSONPathPrefix):
+		// Missing keys print nothing, as they do in kubectl.
+		t := jsonpath.New("machines").AllowMissingKeys(true)
+		if err := t.Parse(strings.TrimPrefix(format, outputJSONPathPrefix)); err != nil {
+			return fmt.Errorf("unable to parse jsonpath template: %v", err)
+		}
+		data, err := machineListData(machines)
+		if err != nil {
+			return err
+		}
+		return t.Execute(w, data)
+	case strings.HasPrefix(format, outputGoTemplatePrefix):
+		t, err := template.New("machines").Parse(strings.TrimPrefix(format, outputGoTemplatePrefix))
+		if err != nil {
+			return fmt.Errorf("unable to parse go-template: %v", err)
+		}
+		data, err := machineListData(machines)
+		if err != nil {
+			return err
+		}
+		return t.Execute(w, data)
+	case format == "" || format == outputWide:
+		summaries := []machineSummary{}
+		for _, machine := range machines {
+			summary, err := summarizeMachine(machine)
+			if err != nil {
+				return err
+			}
+			summaries = append(summaries, summary)
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].IP < summaries[j].IP })
+		if live {
+			addLiveNodeStatus(summaries)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		t := template.Must(template.New("MachineListPrintTemplate").Parse(common.MachineListPrintTemplate))
+		if err := t.Execute(tw, struct {
+			Machines []machineSummary
+			Wide     bool
+			Live     bool
+		}{summaries, format == outputWide, live}); err != nil {
+			return fmt.Errorf("unable to print machines: %v", err)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+}
+
+// machineListData returns the machines as a list, decoded from JSON, so that
+// templates refer to fields by their JSON names, as they do with kubectl.
+func machineListData(machines []clusterv1.Machine) (interface{}, error) {
+	list := clusterv1.MachineList{Items: machines}
+	list.Kind = "MachineList"
+	list.APIVersion = clusterv1.SchemeGroupVersion.String()
+	bytes, err := json.Marshal(list)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal machines to json: %v", err)
+	}
+	var data interface{}
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal machines from json: %v", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	spclientfake "github.com/platform9/ssh-provider/pkg/client/clientset_generated/clientset/fake"
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientfake "k8s.io/client-go/kubernetes/fake"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterclientfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+
+	"github.com/platform9/cctl/common"
+	cctlstate "github.com/platform9/cctl/pkg/state/v2"
+)
+
+func newTestMachine(t *testing.T, name string, spec spv1.MachineSpec, status spv1.MachineStatus) clusterv1.Machine {
+	machine := clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         clusterNamespace,
+			CreationTimestamp: metav1.NewTime(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)),
+		},
+	}
+	spec.TypeMeta = metav1.TypeMeta{APIVersion: "sshprovider.platform9.com/v1alpha1", Kind: "MachineSpec"}
+	if err := sputil.PutMachineSpec(spec, &machine); err != nil {
+		t.Fatalf("Error encoding machine spec: %v", err)
+	}
+	status.TypeMeta = metav1.TypeMeta{APIVersion: "sshprovider.platform9.com/v1alpha1", Kind: "MachineStatus"}
+	if err := sputil.PutMachineStatus(status, &machine); err != nil {
+		t.Fatalf("Error encoding machine status: %v", err)
+	}
+	return machine
+}
+
+func TestPrintMachines(t *testing.T) {
+	pm := &spv1.ProvisionedMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "pm1", Namespace: clusterNamespace},
+		Spec: spv1.ProvisionedMachineSpec{
+			SSHConfig: &spv1.SSHConfig{
+				Host:             "10.0.0.1",
+				Port:             22,
+				PublicKeys:       []string{"ssh-ed25519 AAAA"},
+				CredentialSecret: corev1.LocalObjectReference{Name: "ssh-credential"},
+			},
+		},
+	}
+	savedState := state
+	defer func() { state = savedState }()
+	state = cctlstate.NewWithFile("/nonexistent", kubeclientfake.NewSimpleClientset(), clusterclientfake.NewSimpleClientset(), spclientfake.NewSimpleClientset(pm))
+
+	machines := []clusterv1.Machine{
+		newTestMachine(t, "10.0.0.2", spv1.MachineSpec{Roles: []spv1.MachineRole{spv1.NodeRole}}, spv1.MachineStatus{}),
+		newTestMachine(t, "10.0.0.1", spv1.MachineSpec{
+			Roles:                  []spv1.MachineRole{spv1.MasterRole, spv1.NodeRole},
+			ProvisionedMachineName: "pm1",
+			ComponentVersions: &spv1.MachineComponentVersions{
+				KubernetesVersion: "1.12.8",
+				EtcdVersion:       "3.3.8",
+				NodeadmVersion:    "0.1.0",
+			},
+		}, spv1.MachineStatus{
+			VIPNetworkInterface: "eth0",
+			EtcdMember:          &spv1.EtcdMember{ID: 255, Name: "m1"},
+		}),
+	}
+
+	for _, tc := range []struct {
+		format   string
+		expected string
+	}{
+		{
+			"",
+			"IP         ROLES         KUBERNETES   ETCD     ETCD-MEMBER   VIP-IFACE   HOST-KEY\n" +
+				"10.0.0.1   Master,Node   1.12.8       3.3.8    m1            eth0        pinned\n" +
+				"10.0.0.2   Node          <none>       <none>   <none>        <none>      <unknown>\n",
+		},
+		{
+			"wide",
+			"IP         ROLES         KUBERNETES   ETCD     ETCD-MEMBER   VIP-IFACE   HOST-KEY    NODEADM   ETCDADM   CNI      FLANNEL   KEEPALIVED   ETCD-ID   CREDENTIAL       CREATED\n" +
+				"10.0.0.1   Master,Node   1.12.8       3.3.8    m1            eth0        pinned      0.1.0     <none>    <none>   <none>    <none>       ff        ssh-credential   2019-01-02T03:04:05Z\n" +
+				"10.0.0.2   Node          <none>       <none>   <none>        <none>      <unknown>   <none>    <none>    <none>   <none>    <none>       <none>    <unknown>        2019-01-02T03:04:05Z\n",
+		},
+		{
+			`jsonpath={range .items[*]}{.metadata.name}{"\t"}{.metadata.labels.missing}{"\n"}{end}`,
+			"10.0.0.2\t\n10.0.0.1\t\n",
+		},
+		{
+			`jsonpath={.items[?(@.metadata.name=="10.0.0.1")].metadata.creationTimestamp}`,
+			"2019-01-02T03:04:05Z",
+		},
+		{
+			`go-template={{range .items}}{{.metadata.name}} {{.kind}}{{"\n"}}{{end}}{{.kind}}`,
+			"10.0.0.2 <no value>\n10.0.0.1 <no value>\nMachineList",
+		},
+	} {
+		var out bytes.Buffer
+		if err := printMachines(&out, machines, tc.format, false); err != nil {
+			t.Fatalf("Format %q: error printing machines: %v", tc.format, err)
+		}
+		if out.String() != tc.expected {
+			t.Errorf("Format %q: expected\n%q\nfound\n%q", tc.format, tc.expected, out.String())
+		}
+	}
+
+	if err := printMachines(&bytes.Buffer{}, machines, "jsonpath={.items[", false); err == nil {
+		t.Errorf("Expected an error printing with an invalid jsonpath template")
+	}
+
+	machines[1].Annotations = map[string]string{common.ProductUUIDAnnotationKey: "4C4C4544-0042-4A10-8047-B4C04F564332"}
+	summary, err := summarizeMachine(machines[1])
+	if err != nil {
+		t.Fatalf("Error summarizing machine: %v", err)
+	}
+	if summary.productUUID != "4C4C4544-0042-4A10-8047-B4C04F564332" {
+		t.Errorf("Expected the product UUID of the machine, found %q", summary.productUUID)
+	}
+}
+
+func TestNodeForMachine(t *testing.T) {
+	const productUUID = "4C4C4544-0042-4A10-8047-B4C04F564332"
+	nodes := []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "by-ip"},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+				NodeInfo:  corev1.NodeSystemInfo{SystemUUID: "00000000-0000-0000-0000-000000000000"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "by-uuid"},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.9"}},
+				NodeInfo:  corev1.NodeSystemInfo{SystemUUID: "4c4c4544-0042-4a10-8047-b4c04f564332"},
+			},
+		},
+	}
+	for _, tc := range []struct {
+		name     string
+		summary  machineSummary
+		expected string
+	}{
+		{"product UUID before IP", machineSummary{IP: "10.0.0.1", productUUID: productUUID}, "by-uuid"},
+		{"IP without product UUID", machineSummary{IP: "10.0.0.1"}, "by-ip"},
+		{"IP with unknown product UUID", machineSummary{IP: "10.0.0.1", productUUID: "unknown"}, "by-ip"},
+		{"no match", machineSummary{IP: "10.0.0.5"}, ""},
+	} {
+		node, ok := nodeForMachine(tc.summary, nodes)
+		if ok != (len(tc.expected) != 0) || node.Name != tc.expected {
+			t.Errorf("%s: expected node %q, found %q", tc.name, tc.expected, node.Name)
+		}
+	}
+}
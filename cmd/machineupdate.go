@@ -0,0 +1,567 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/etcdcluster"
+	"github.com/platform9/cctl/pkg/inventory"
+	log "github.com/platform9/cctl/pkg/logrus"
+	"github.com/platform9/cctl/pkg/preflight"
+	"github.com/platform9/cctl/pkg/util/clusterapi"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	machineActuator "github.com/platform9/ssh-provider/pkg/clusterapi/machine"
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+
+	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterutil "sigs.k8s.io/cluster-api/pkg/util"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// resetMachine removes Kubernetes, and etcd if the machine is a master, from
+// the machine. The etcd member of the machine must already be removed from
+// the etcd cluster.
+func resetMachine(machine *clusterv1.Machine, machineClient sshmachine.Client) error {
+	cmd := fmt.Sprintf("%s reset", machineActuator.NodeadmPath)
+	stdOut, stdErr, err := machineClient.RunCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("error running %q: %v (stdout: %q, stderr: %q)", cmd, err, string(stdOut), string(stdErr))
+	}
+	if clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles) {
+		if err := resetEtcdSkipRemoveMember(machineClient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recreateAdminKubeConfigSecretIfStale deletes the admin kubeconfig secret if
+// it reaches the API server at the host, and creates it again from a master.
+func recreateAdminKubeConfigSecretIfStale(host string) error {
+	secret, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Get(common.DefaultAdminConfigSecretName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get secret for admin kubeconfig: %v", err)
+	}
+	config, err := clientcmd.Load(secret.Data[common.DefaultAdminConfigSecretKey])
+	if err != nil {
+		return fmt.Errorf("unable to parse admin kubeconfig: %v", err)
+	}
+	stale := false
+	for _, cluster := range config.Clusters {
+		if server, err := url.Parse(cluster.Server); err == nil && server.Hostname() == host {
+			stale = true
+		}
+	}
+	if !stale {
+		return nil
+	}
+	log.Printf("Creating admin kubeconfig again, because it reaches the API server at %q", host)
+	if err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Delete(common.DefaultAdminConfigSecretName, &metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("unable to delete secret for admin kubeconfig: %v", err)
+	}
+	if err := createAdminKubeConfigSecretIfNotPresent(); err != nil {
+		return err
+	}
+	return syncState()
+}
+
+// updateMachineAddress gives the machine a new SSH host, a new name, or both.
+// A machine named after its IP is named after its new IP, unless it is given
+// a name. Kubernetes, and etcd if the machine is a master, are set up again,
+// because the node name, and the etcd URLs and certificates, include the
+// machine name or address. A node that keeps its name only has its kubelet
+// restarted. The labels, taints, and other settings of the machine are kept.
+func updateMachineAddress(name, newHost, newName string, allowQuorumLoss bool) error {
+	machine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get machine %q: %v", name, err)
+	}
+	machineSpec, err := sputil.GetMachineSpec(*machine)
+	if err != nil {
+		return fmt.Errorf("unable to decode machine %q spec: %v", name, err)
+	}
+	machineStatus, err := sputil.GetMachineStatus(*machine)
+	if err != nil {
+		return fmt.Errorf("unable to decode machine %q status: %v", name, err)
+	}
+	provisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(machineSpec.ProvisionedMachineName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get provisioned machine %q: %v", machineSpec.ProvisionedMachineName, err)
+	}
+	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get cluster: %v", err)
+	}
+	if !isCreated(machine) {
+		return fmt.Errorf("creation of machine %q stopped after phase %q. Use `cctl create machine --ip %s --resume` to continue", name, creationPhaseOf(machine), name)
+	}
+
+	oldHost := provisionedMachine.Spec.SSHConfig.Host
+	if len(newHost) == 0 {
+		newHost = oldHost
+	}
+	if len(newName) == 0 {
+		newName = machine.Name
+		if machine.Name == oldHost {
+			newName = newHost
+		}
+	}
+	if msgs := validation.IsDNS1123Subdomain(newName); len(msgs) != 0 {
+		return fmt.Errorf("machine name %q is not valid: %s", newName, strings.Join(msgs, "; "))
+	}
+	if newName == machine.Name && newHost == oldHost {
+		log.Printf("Machine %q is already reached at %q", machine.Name, newHost)
+		return nil
+	}
+	if newName != machine.Name {
+		if _, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(newName, metav1.GetOptions{}); err == nil {
+			return fmt.Errorf("machine %q already exists", newName)
+		} else if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to get machine %q: %v", newName, err)
+		}
+		if _, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(newName, metav1.GetOptions{}); err == nil {
+			return fmt.Errorf("provisioned machine %q already exists", newName)
+		} else if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to get provisioned machine %q: %v", newName, err)
+		}
+	}
+
+	// Make sure that the new address reaches the same machine.
+	newSSHConfig := provisionedMachine.Spec.SSHConfig.DeepCopy()
+	newSSHConfig.Host = newHost
+	jumpHosts, err := jumpHostsForSSHConfig(provisionedMachine.Spec.SSHConfig)
+	if err != nil {
+		return fmt.Errorf("unable to find jump hosts: %v", err)
+	}
+	machineClient, err := sshMachineClientWithJumpHosts(newSSHConfig, jumpHosts)
+	if err != nil {
+		return fmt.Errorf("unable to reach machine %q at %q: %v", machine.Name, newHost, err)
+	}
+	if recordedUUID, ok := machine.Annotations[common.ProductUUIDAnnotationKey]; ok {
+		productUUID, err := preflight.ProductUUID(machineClient)
+		if err != nil {
+			return fmt.Errorf("unable to read product UUID: %v", err)
+		}
+		if !strings.EqualFold(productUUID, recordedUUID) {
+			return fmt.Errorf("the machine at %q has product UUID %q, but machine %q has product UUID %q", newHost, productUUID, machine.Name, recordedUUID)
+		}
+	}
+
+	isMaster := clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles)
+	if !isMaster && newName == machine.Name {
+		log.Printf("Updating SSH host of machine %q to %q", machine.Name, newHost)
+		provisionedMachine.Spec.SSHConfig = newSSHConfig
+		if _, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Update(provisionedMachine); err != nil {
+			return fmt.Errorf("unable to update provisioned machine %q: %v", provisionedMachine.Name, err)
+		}
+		if err := syncState(); err != nil {
+			return fmt.Errorf("unable to sync on-disk state: %v", err)
+		}
+		// The kubelet finds the new address of the node when it starts.
+		cmd := "systemctl restart kubelet"
+		if stdOut, stdErr, err := machineClient.RunCommand(cmd); err != nil {
+			return fmt.Errorf("error running %q: %v (stdout: %q, stderr: %q)", cmd, err, string(stdOut), string(stdErr))
+		}
+		return nil
+	}
+
+	// Find a master, other than the machine, through which the cluster is
+	// changed.
+	var masterClient sshmachine.Client
+	var etcdStatus *etcdcluster.Status
+	if isMaster {
+		machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to list machines: %v", err)
+		}
+		if len(clusterapi.MachinesWithRole(machineList.Items, clustercommon.MasterRole)) == 1 {
+			return fmt.Errorf("machine %q is the only master. Setting up etcd again at its new address loses the etcd data", machine.Name)
+		}
+		if err := mustNotLoseQuorum(machine, "update", false, allowQuorumLoss); err != nil {
+			return err
+		}
+		if _, masterClient, etcdStatus, err = healthyMaster(machine.Name); err != nil {
+			return err
+		}
+	} else {
+		_, masterProvisionedMachine, err := masterMachineAndProvisionedMachine()
+		if err != nil {
+			return fmt.Errorf("unable to get a master machine and provisioned machine: %v", err)
+		}
+		if masterClient, err = sshMachineClientFromSSHConfig(masterProvisionedMachine.Spec.SSHConfig); err != nil {
+			return fmt.Errorf("unable to create machine client for master: %v", err)
+		}
+	}
+
+	if err := createAdminKubeConfigSecretIfNotPresent(); err != nil {
+		return fmt.Errorf("unable to create admin kubeconfig secret: %v", err)
+	}
+	kubeconfig, err := createLocalCopyOfAdminKubeConfig()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(kubeconfig)
+	nodeName, err := nodeNameOfMachine(kubeconfig, machine)
+	if err != nil {
+		return fmt.Errorf("unable to find cluster node of machine %q: %v", machine.Name, err)
+	}
+	if len(nodeName) != 0 {
+		log.Printf("Draining cluster node %q of machine %q", nodeName, machine.Name)
+		if err := drainNode(nodeName, masterClient); err != nil {
+			return fmt.Errorf("unable to drain node: %v", err)
+		}
+	}
+	if isMaster {
+		if err := removeEtcdMemberOfMachine(machine, masterClient, etcdStatus, cluster); err != nil {
+			return err
+		}
+	}
+	log.Printf("Resetting machine %q", machine.Name)
+	if err := resetMachine(machine, machineClient); err != nil {
+		return fmt.Errorf("unable to reset machine %q: %v", machine.Name, err)
+	}
+	if len(nodeName) != 0 {
+		log.Printf("Deleting cluster node %q of machine %q", nodeName, machine.Name)
+		if err := common.DeleteNode(kubeconfig, nodeName); err != nil {
+			return fmt.Errorf("unable to delete node: %v", err)
+		}
+	}
+
+	// Replace the machine objects with objects of the new name, which are
+	// created again from the registered phase. The new objects are created
+	// before the old ones are deleted, so that the state always records the
+	// machine.
+	log.Printf("Renaming machine %q to %q, reached at %q", machine.Name, newName, newHost)
+	renamed := newName != machine.Name
+	newProvisionedMachine := provisionedMachine.DeepCopy()
+	newMachine := machine.DeepCopy()
+	if renamed {
+		newProvisionedMachine.ObjectMeta = metav1.ObjectMeta{
+			Name:        newName,
+			Namespace:   provisionedMachine.Namespace,
+			Labels:      provisionedMachine.Labels,
+			Annotations: provisionedMachine.Annotations,
+		}
+		newMachine.ObjectMeta = metav1.ObjectMeta{
+			Name:        newName,
+			Namespace:   machine.Namespace,
+			Labels:      machine.Labels,
+			Annotations: machine.Annotations,
+		}
+	}
+	newProvisionedMachine.Spec.SSHConfig = newSSHConfig
+	machineSpec.ProvisionedMachineName = newName
+	if err := sputil.PutMachineSpec(*machineSpec, newMachine); err != nil {
+		return fmt.Errorf("unable to encode machine %q spec: %v", newName, err)
+	}
+	machineStatus.EtcdMember = nil
+	if err := sputil.PutMachineStatus(*machineStatus, newMachine); err != nil {
+		return fmt.Errorf("unable to encode machine %q status: %v", newName, err)
+	}
+	metav1.SetMetaDataAnnotation(&newMachine.ObjectMeta, common.CreationPhaseAnnotationKey, string(phaseRegistered))
+	recoveryHint := fmt.Sprintf("Machine %q was reset, and is still recorded in the state. Use `cctl delete machine --ip %s --force`, then create it again at %q", machine.Name, machine.Name, newHost)
+	if renamed {
+		if _, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Create(newProvisionedMachine); err != nil {
+			return fmt.Errorf("unable to create provisioned machine %q: %v. %s", newName, err, recoveryHint)
+		}
+		if _, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Create(newMachine); err != nil {
+			state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Delete(newName, &metav1.DeleteOptions{})
+			return fmt.Errorf("unable to create machine %q: %v. %s", newName, err, recoveryHint)
+		}
+		if err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Delete(machine.Name, &metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("unable to delete machine %q: %v", machine.Name, err)
+		}
+		if err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Delete(provisionedMachine.Name, &metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("unable to delete provisioned machine %q: %v", provisionedMachine.Name, err)
+		}
+	} else {
+		if _, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Update(newProvisionedMachine); err != nil {
+			return fmt.Errorf("unable to update provisioned machine %q: %v. %s", newName, err, recoveryHint)
+		}
+		if _, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Update(newMachine); err != nil {
+			return fmt.Errorf("unable to update machine %q: %v. %s", newName, err, recoveryHint)
+		}
+	}
+	if isMaster {
+		if err := removeAPIEndpointsOfMachine(machine, provisionedMachine, cluster); err != nil {
+			return err
+		}
+	}
+	if err := syncState(); err != nil {
+		return fmt.Errorf("unable to sync on-disk state: %v", err)
+	}
+
+	if err := createMachineLike(inventory.Machine{IP: newName}, nil, true, true); err != nil {
+		return fmt.Errorf("unable to set up machine %q again: %v. Use `cctl create machine --ip %s --resume` to continue", newName, err, newName)
+	}
+	if isMaster {
+		if err := verifyReplacement(newName, masterClient); err != nil {
+			return fmt.Errorf("machine %q updated, but the cluster is not healthy: %v", newName, err)
+		}
+		if err := recreateAdminKubeConfigSecretIfStale(oldHost); err != nil {
+			return fmt.Errorf("unable to update admin kubeconfig: %v", err)
+		}
+	}
+	return nil
+}
+
+// updateMachineRole changes the role of the machine. The machine is drained,
+// reset and created again with the new role through the actuator, so that a
+// new master joins the etcd cluster, and a former master leaves it. The
+// labels, taints, and other settings of the machine are kept.
+func updateMachineRole(name, role string, allowQuorumLoss bool) error {
+	newRole := clustercommon.MachineRole(strings.Title(role))
+	if newRole != clustercommon.MasterRole && newRole != clustercommon.NodeRole {
+		return fmt.Errorf("machine role %q is not supported, must be %q or %q", role, common.MasterRole, common.NodeRole)
+	}
+	machine, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get machine %q: %v", name, err)
+	}
+	machineSpec, err := sputil.GetMachineSpec(*machine)
+	if err != nil {
+		return fmt.Errorf("unable to decode machine %q spec: %v", name, err)
+	}
+	machineStatus, err := sputil.GetMachineStatus(*machine)
+	if err != nil {
+		return fmt.Errorf("unable to decode machine %q status: %v", name, err)
+	}
+	provisionedMachine, err := state.SPClient.SshproviderV1alpha1().ProvisionedMachines(clusterNamespace).Get(machineSpec.ProvisionedMachineName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get provisioned machine %q: %v", machineSpec.ProvisionedMachineName, err)
+	}
+	cluster, err := state.ClusterClient.ClusterV1alpha1().Clusters(clusterNamespace).Get(clusterName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get cluster: %v", err)
+	}
+	if !isCreated(machine) {
+		return fmt.Errorf("creation of machine %q stopped after phase %q. Use `cctl create machine --ip %s --resume` to continue", name, creationPhaseOf(machine), name)
+	}
+	isMaster := clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles)
+	if isMaster == (newRole == clustercommon.MasterRole) {
+		log.Printf("Machine %q is already a %s", name, strings.ToLower(string(newRole)))
+		return nil
+	}
+
+	machineList, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list machines: %v", err)
+	}
+	masters := clusterapi.MachinesWithRole(machineList.Items, clustercommon.MasterRole)
+	if isMaster {
+		if len(masters) == 1 {
+			return fmt.Errorf("machine %q is the only master. Promote a node to master first", name)
+		}
+		if err := mustNotLoseQuorum(machine, "demote", false, allowQuorumLoss); err != nil {
+			return err
+		}
+	} else if len(masters) != 0 {
+		cspec, err := sputil.GetClusterSpec(*cluster)
+		if err != nil {
+			return fmt.Errorf("unable to decode cluster spec: %v", err)
+		}
+		if cspec.VIPConfiguration == nil {
+			return fmt.Errorf("promoting a node to master is not allowed: this cluster already has one master and has no VIP configured")
+		}
+		// The new master joins the etcd cluster through the first member
+		// in the cluster status.
+		_, _, etcdStatus, err := healthyMaster(name)
+		if err != nil {
+			return err
+		}
+		if err := setClusterEtcdMembers(etcdStatus, cluster); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Changing role of machine %q to %s", name, strings.ToLower(string(newRole)))
+	if err := drainAndDeleteNodeForMachine(machine, provisionedMachine); err != nil {
+		return fmt.Errorf("unable to drain and delete cluster node for machine %q: %v", name, err)
+	}
+	if isMaster && machineStatus.EtcdMember != nil {
+		if err := removeClusterEtcdMember(*machineStatus.EtcdMember, cluster); err != nil {
+			return fmt.Errorf("unable to delete etcd member from cluster status: %v", err)
+		}
+	}
+	// The machine client builder verifies the host key, so this only
+	// tells the actuator whether public keys are known.
+	insecureIgnoreHostKey := len(provisionedMachine.Spec.SSHConfig.PublicKeys) == 0
+	actuator := machineActuator.NewActuator(
+		state.KubeClient,
+		state.ClusterClient,
+		state.SPClient,
+		sshMachineClient,
+		insecureIgnoreHostKey,
+		log.LogLevel(),
+	)
+	machineCluster, err := clusterForMachine(cluster, machine)
+	if err != nil {
+		return err
+	}
+	log.Printf("Resetting machine %q", name)
+	if err := actuator.Delete(machineCluster, machine); err != nil {
+		return fmt.Errorf("unable to reset machine %q: %v", name, err)
+	}
+	if isMaster {
+		if err := removeAPIEndpointsOfMachine(machine, provisionedMachine, cluster); err != nil {
+			return err
+		}
+	}
+
+	// Give the machine the new role, and create it again from the registered
+	// phase.
+	if machine, err = state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Get(name, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("unable to get machine %q: %v", name, err)
+	}
+	machine.Spec.Roles = []clustercommon.MachineRole{newRole}
+	taints := []corev1.Taint{}
+	for _, taint := range machine.Spec.Taints {
+		if taint.Key != common.LabelNodeRoleMaster {
+			taints = append(taints, taint)
+		}
+	}
+	if newRole == clustercommon.MasterRole {
+		taints = append(taints, corev1.Taint{
+			Key:    common.LabelNodeRoleMaster,
+			Effect: corev1.TaintEffectPreferNoSchedule,
+		})
+	}
+	machine.Spec.Taints = taints
+	machineSpec.Roles = []spv1.MachineRole{spv1.MachineRole(newRole)}
+	if err := sputil.PutMachineSpec(*machineSpec, machine); err != nil {
+		return fmt.Errorf("unable to encode machine %q spec: %v", name, err)
+	}
+	if machineStatus, err = sputil.GetMachineStatus(*machine); err != nil {
+		return fmt.Errorf("unable to decode machine %q status: %v", name, err)
+	}
+	machineStatus.EtcdMember = nil
+	if err := sputil.PutMachineStatus(*machineStatus, machine); err != nil {
+		return fmt.Errorf("unable to encode machine %q status: %v", name, err)
+	}
+	metav1.SetMetaDataAnnotation(&machine.ObjectMeta, common.CreationPhaseAnnotationKey, string(phaseRegistered))
+	if _, err := state.ClusterClient.ClusterV1alpha1().Machines(clusterNamespace).Update(machine); err != nil {
+		return fmt.Errorf("unable to update machine %q: %v", name, err)
+	}
+	if err := syncState(); err != nil {
+		return fmt.Errorf("unable to sync on-disk state: %v", err)
+	}
+	if err := createMachineLike(inventory.Machine{IP: name}, nil, true, true); err != nil {
+		return fmt.Errorf("unable to create machine %q as a %s: %v. Use `cctl create machine --ip %s --resume` to continue", name, strings.ToLower(string(newRole)), err, name)
+	}
+
+	machineClient, err := sshMachineClientFromSSHConfig(provisionedMachine.Spec.SSHConfig)
+	if err != nil {
+		return fmt.Errorf("unable to create machine client for machine %q: %v", name, err)
+	}
+	nodeName, err := nodeNameForMachine(name, machineClient)
+	if err != nil {
+		return fmt.Errorf("unable to get node name for machine %q: %v", name, err)
+	}
+	if err := uncordonNode(nodeName, machineClient); err != nil {
+		return fmt.Errorf("unable to uncordon the node %s: %v", nodeName, err)
+	}
+	_, masterClient, etcdStatus, err := healthyMaster("")
+	if err != nil {
+		return err
+	}
+	if newRole == clustercommon.MasterRole {
+		return verifyReplacement(name, masterClient)
+	}
+	log.Printf("Etcd cluster has %d members, %d healthy, and fault tolerance %d", len(etcdStatus.Members), etcdStatus.HealthyMembers(), etcdcluster.FaultTolerance(len(etcdStatus.Members)))
+	return nil
+}
+
+// machineCmdUpdate represents the machine update command
+var machineCmdUpdate = &cobra.Command{
+	Use:   "machine",
+	Short: "Updates a machine",
+	Long: `Changes the IP address, or the name, of a machine whose address changed.
+The machine keeps its labels, taints, and other settings. A machine named
+after its IP is named after its new IP, unless --name is given. A DNS name
+given with --name becomes the name of the machine and its cluster node, and
+is kept when the IP changes again.
+
+Kubernetes, and etcd if the machine is a master, are set up again at the new
+address, with new certificates and kubeconfigs. The etcd member of a master
+is removed through a healthy master, and the master joins the etcd cluster
+again.
+
+With --role, a node is promoted to master, or a master is demoted to node.
+The machine is drained, reset, and created again with the new role. A new
+master joins the etcd cluster, and a former master leaves it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ip := cmd.Flag("ip").Value.String()
+		newIP := cmd.Flag("new-ip").Value.String()
+		name := cmd.Flag("name").Value.String()
+		allowQuorumLoss, err := cmd.Flags().GetBool("i-understand-quorum-loss")
+		if err != nil {
+			log.Fatalf("Unable to parse `i-understand-quorum-loss` flag: %v", err)
+		}
+		role := cmd.Flag("role").Value.String()
+		if len(role) != 0 {
+			if len(newIP) != 0 || len(name) != 0 {
+				log.Fatalf("Change the role of a machine separately from its IP or name")
+			}
+			if err := updateMachineRole(ip, role, allowQuorumLoss); err != nil {
+				log.Fatalf("Unable to update machine: %v", err)
+			}
+			log.Println("Machine updated successfully.")
+			return
+		}
+		if len(newIP) == 0 && len(name) == 0 {
+			log.Fatalf("Nothing to update. Use --new-ip, --name, --role, or --new-ip and --name")
+		}
+		if len(newIP) != 0 && net.ParseIP(newIP) == nil {
+			log.Fatalf("New IP %q is not a valid IP", newIP)
+		}
+		if err := updateMachineAddress(ip, newIP, name, allowQuorumLoss); err != nil {
+			log.Fatalf("Unable to update machine: %v", err)
+		}
+		log.Println("Machine updated successfully.")
+	},
+}
+
+func init() {
+	updateCmd.AddCommand(machineCmdUpdate)
+	machineCmdUpdate.Flags().String("ip", "", "IP, or name, of the machine")
+	machineCmdUpdate.MarkFlagRequired("ip")
+	machineCmdUpdate.Flags().String("new-ip", "", "New IP of the machine")
+	machineCmdUpdate.Flags().String("name", "", "New name of the machine, e.g. its DNS name. Defaults to the new IP, if the machine is named after its IP")
+	machineCmdUpdate.Flags().String("role", "", "New role of the machine, master or node")
+	machineCmdUpdate.Flags().Bool("i-understand-quorum-loss", false, "Update a master even if the etcd cluster has no quorum, would lose it, or its quorum cannot be checked")
+	machineCmdUpdate.Flags().DurationVar(&drainTimeout, "drain-timeout", common.DrainTimeout, "The length of time to wait before giving up, zero means infinite")
+	machineCmdUpdate.Flags().IntVar(&drainGracePeriodSeconds, "drain-grace-period", common.DrainGracePeriodSeconds, "Period of time in seconds given to each pod to terminate gracefully. If negative, the default value specified in the pod will be used.")
+}
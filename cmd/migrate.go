@@ -17,18 +17,16 @@ limitations under the License.
 package cmd
 
 import (
-	log "github.com/platform9/cctl/pkg/logrus"
-
-	"github.com/platform9/cctl/pkg/state/v1"
+	"os"
+	"strings"
 
-	"github.com/spf13/cobra"
-
-	spclientfake "github.com/platform9/ssh-provider/pkg/client/clientset_generated/clientset/fake"
-	kubeclientfake "k8s.io/client-go/kubernetes/fake"
-	clusterclientfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+	"github.com/ghodss/yaml"
 
+	log "github.com/platform9/cctl/pkg/logrus"
 	stateutil "github.com/platform9/cctl/pkg/state/util"
-	"github.com/platform9/cctl/pkg/state/v0"
+	cctlstate "github.com/platform9/cctl/pkg/state/v2"
+
+	"github.com/spf13/cobra"
 )
 
 // migrateCmd represents the migrate command
@@ -43,44 +41,62 @@ var migrateCmd = &cobra.Command{
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		version, err := stateutil.VersionFromFile(stateFilename)
+		dryRun, err := cmd.Flags().GetBool("dry-run")
 		if err != nil {
-			log.Fatalf("Error determining version of state file: %v", err)
+			log.Fatalf("Unable to parse `dry-run` flag: %v", err)
 		}
-		switch version {
-		case 0:
-			log.Println("Migrating from v0")
-			kubeClient := kubeclientfake.NewSimpleClientset()
-			clusterClient := clusterclientfake.NewSimpleClientset()
-			spClient := spclientfake.NewSimpleClientset()
-			stateV0 := v0.NewWithFile(stateFilename, kubeClient, clusterClient, spClient)
-			if err := stateV0.PushToAPIs(); err != nil {
-				log.Fatalf("Error reading from state: %v", err)
-			}
-			stateV1 := stateutil.StateV1FromStateV0(stateV0)
-			stateV2 := stateutil.StateV2FromStateV1(stateV1)
-			if err := stateV2.PullFromAPIs(); err != nil {
-				log.Fatalf("Error writing to state: %v", err)
-			}
-		case 1:
-			log.Println("Migrating from v1")
-			kubeClient := kubeclientfake.NewSimpleClientset()
-			clusterClient := clusterclientfake.NewSimpleClientset()
-			spClient := spclientfake.NewSimpleClientset()
-			stateV1 := v1.NewWithFile(stateFilename, kubeClient, clusterClient, spClient)
-			if err := stateV1.PushToAPIs(); err != nil {
-				log.Fatalf("Error reading from state: %v", err)
-			}
-			stateV2 := stateutil.StateV2FromStateV1(stateV1)
-			if err := stateV2.PullFromAPIs(); err != nil {
-				log.Fatalf("Error writing to state: %v", err)
+		store := stateFileStore()
+		if err := store.Lock(); err != nil {
+			log.Fatalf("Unable to lock state file: %v", err)
+		}
+		defer store.Unlock()
+		sm, err := stateutil.MigrateStore(store, dryRun)
+		if err != nil {
+			log.Fatalf("Error migrating state file: %v", err)
+		}
+		if len(sm.Chain) == 0 {
+			log.Printf("No migration needed: already at v%d", stateutil.LatestVersion())
+			return
+		}
+		for _, m := range sm.Chain {
+			log.Printf("Migrating from v%d to v%d", m.From, m.To)
+		}
+		if dryRun {
+			printMigrationDiff(sm.StateBytes, sm.MigratedBytes)
+			return
+		}
+		log.Printf("Backed up state file to %q", sm.BackupFilename)
+		if journal := newStateJournal(store); journal != nil {
+			if err := journal.Append(sm.MigratedBytes, strings.Join(os.Args, " ")); err != nil {
+				log.Warnf("Migrated state file, but could not record it in the journal: %v", err)
 			}
-		case 2:
-			log.Println("No migration needed: already at v2")
 		}
+		log.Printf("Migrated state file to v%d", sm.Chain[len(sm.Chain)-1].To)
 	},
 }
 
+// printMigrationDiff prints the JSON patch that migrates the state.
+func printMigrationDiff(stateBytes, migratedBytes []byte) {
+	fromJSON, err := yaml.YAMLToJSON(stateBytes)
+	if err != nil {
+		log.Fatalf("Unable to convert state from YAML to JSON: %v", err)
+	}
+	toJSON, err := yaml.YAMLToJSON(migratedBytes)
+	if err != nil {
+		log.Fatalf("Unable to convert migrated state from YAML to JSON: %v", err)
+	}
+	patch, err := cctlstate.DiffDocuments(fromJSON, toJSON)
+	if err != nil {
+		log.Fatalf("Unable to diff migrated state: %v", err)
+	}
+	patchBytes, err := yaml.Marshal(patch)
+	if err != nil {
+		log.Fatalf("Unable to marshal patch to YAML: %v", err)
+	}
+	os.Stdout.Write(patchBytes)
+}
+
 func init() {
 	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().Bool("dry-run", false, "Print the changes the migration would make, without changing the state file")
 }
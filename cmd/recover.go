@@ -33,6 +33,7 @@ var recoverCmd = &cobra.Command{
 		if err := log.SetLogLevelUsingString(LogLevel); err != nil {
 			log.Fatalf("Unable to parse log level %s", LogLevel)
 		}
+		preflightValidateState()
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 	},
@@ -42,6 +42,7 @@ var restoreCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Unable to parse `snapshot`: %v", err)
 		}
+		stateFilename := stateFileStore().Filename
 		if err := archive.Extract(archivePath, stateFilename, snapshotPath); err != nil {
 			log.Fatalf("Unable to extract archive: %v", err)
 		}
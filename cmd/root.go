@@ -19,8 +19,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	log "github.com/platform9/cctl/pkg/logrus"
+	"github.com/platform9/cctl/pkg/state/encryption"
 	cctlstate "github.com/platform9/cctl/pkg/state/v2"
 
 	spclientfake "github.com/platform9/ssh-provider/pkg/client/clientset_generated/clientset/fake"
@@ -29,7 +31,12 @@ import (
 	clusterclientfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
 )
 
-var stateFilename string
+var stateURL string
+var stateBackups int
+var stateKeyFile string
+var statePassphraseFile string
+var stateHistory string
+var validateState bool
 var state *cctlstate.State
 var LogLevel string
 
@@ -51,17 +58,85 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringVar(&stateFilename, "state", "/etc/cctl-state.yaml", "state file")
+	rootCmd.PersistentFlags().StringVar(&stateURL, "state", "/etc/cctl-state.yaml", "state file, or URL of the state. Supported URL schemes are file:// and s3://")
+	rootCmd.PersistentFlags().IntVar(&stateBackups, "state-backups", cctlstate.DefaultBackups, "number of previous versions of the state file to keep")
+	rootCmd.PersistentFlags().StringVar(&stateKeyFile, "state-key-file", "", "key file used to encrypt secrets in the state file")
+	rootCmd.PersistentFlags().StringVar(&statePassphraseFile, "state-passphrase-file", "", fmt.Sprintf("file with the passphrase used to encrypt secrets in the state file. The passphrase can also be set with %s, or entered at a prompt", encryption.PassphraseEnvVar))
+	rootCmd.PersistentFlags().StringVar(&stateHistory, "state-history", "", "journal of state revisions. Defaults to the state file name with a .history suffix, if the state is stored in a local file")
+	rootCmd.PersistentFlags().BoolVar(&validateState, "validate-state", false, "check that the state is consistent before changing it")
 	rootCmd.PersistentFlags().StringVarP(&LogLevel, "log-level", "l", "info", "set log level for output, permitted values debug, info, warn, error, fatal and panic")
 }
 
 func InitState() {
+	state = newState()
+	if err := state.PushToAPIs(); err != nil {
+		log.Fatalf("Unable to sync on-disk state: %v", err)
+	}
+	resolveCluster()
+}
+
+// stateMutex serializes updates to the state made by concurrent goroutines.
+var stateMutex sync.Mutex
+
+// syncState records the objects in the APIs to the state. It is safe to call
+// from concurrent goroutines.
+func syncState() error {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return state.PullFromAPIs()
+}
+
+// newState returns the state selected with the --state flag, without reading
+// it.
+func newState() *cctlstate.State {
 	kubeClient := kubeclientfake.NewSimpleClientset()
 	clusterClient := clusterclientfake.NewSimpleClientset()
 	spClient := spclientfake.NewSimpleClientset()
-	state = cctlstate.NewWithFile(stateFilename, kubeClient, clusterClient, spClient)
+	s := cctlstate.New(newStateStore(), kubeClient, clusterClient, spClient)
+	s.KeyProvider = stateKeyProvider()
+	s.Journal = newStateJournal(s.Store)
+	return s
+}
 
-	if err := state.PushToAPIs(); err != nil {
-		log.Fatalf("Unable to sync on-disk state: %v", err)
+// newStateJournal returns the journal of state revisions, or nil if there is
+// none.
+func newStateJournal(store cctlstate.StateStore) *cctlstate.Journal {
+	if len(stateHistory) != 0 {
+		return cctlstate.NewJournal(stateHistory)
+	}
+	if fs, ok := store.(*cctlstate.FileStore); ok {
+		return cctlstate.NewJournal(cctlstate.JournalFilename(fs.Filename))
+	}
+	return nil
+}
+
+// newStateStore returns the store selected with the --state flag.
+func newStateStore() cctlstate.StateStore {
+	store, err := cctlstate.NewStore(stateURL)
+	if err != nil {
+		log.Fatalf("Unable to use state %q: %v", stateURL, err)
+	}
+	if fs, ok := store.(*cctlstate.FileStore); ok {
+		fs.Backups = stateBackups
+	}
+	return store
+}
+
+// stateFileStore returns the store selected with the --state flag, and exits
+// if the state is not stored in a local file.
+func stateFileStore() *cctlstate.FileStore {
+	fs, ok := newStateStore().(*cctlstate.FileStore)
+	if !ok {
+		log.Fatalf("State %q is not stored in a local file. This command requires a local state file.", stateURL)
+	}
+	return fs
+}
+
+// stateKeyProvider returns the provider of the key used to encrypt secrets in
+// the state file. The passphrase is only read if it is needed.
+func stateKeyProvider() encryption.KeyProvider {
+	if len(stateKeyFile) != 0 {
+		return &encryption.KeyFileProvider{KeyFile: stateKeyFile}
 	}
+	return &encryption.PassphraseProvider{PassphraseFile: statePassphraseFile}
 }
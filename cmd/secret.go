@@ -63,19 +63,19 @@ func createSecretDefaults() error {
 		return fmt.Errorf("unable to generate bootstrap token CA secret: %v", err)
 	}
 
-	if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newAPIServerCASecret); err != nil {
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newAPIServerCASecret); err != nil {
 		return fmt.Errorf("unable to create API server CA secret: %v", err)
 	}
-	if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newEtcdCASecret); err != nil {
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newEtcdCASecret); err != nil {
 		return fmt.Errorf("unable to create etcd CA secret: %v", err)
 	}
-	if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newFrontProxyCASecret); err != nil {
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newFrontProxyCASecret); err != nil {
 		return fmt.Errorf("unable to create front proxy CA secret: %v", err)
 	}
-	if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newServiceAccountKeySecret); err != nil {
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newServiceAccountKeySecret); err != nil {
 		return fmt.Errorf("unable to create service account secret: %v", err)
 	}
-	if _, err := state.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(newBootstrapTokenSecret); err != nil {
+	if _, err := state.KubeClient.CoreV1().Secrets(clusterNamespace).Create(newBootstrapTokenSecret); err != nil {
 		return fmt.Errorf("unable to create bootstrap token secret: %v", err)
 	}
 	return nil
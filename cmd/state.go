@@ -0,0 +1,373 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	log "github.com/platform9/cctl/pkg/logrus"
+	cctlstate "github.com/platform9/cctl/pkg/state/v2"
+	"github.com/platform9/cctl/pkg/state/validate"
+
+	"github.com/spf13/cobra"
+)
+
+// stateCmd represents the state command
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Used to manage the state file",
+	Args:  cobra.MinimumNArgs(1),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// PersistentPreRuns are not chained https://github.com/spf13/cobra/issues/216
+		// Therefore LogLevel must be set in all the PersistentPreRuns
+		if err := log.SetLogLevelUsingString(LogLevel); err != nil {
+			log.Fatalf("Unable to parse log level %s", LogLevel)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Printf("Unknown subcommand %q. Use --help to print available options", args[0])
+	},
+}
+
+var stateCmdUnlock = &cobra.Command{
+	Use:   "unlock",
+	Short: "Clear the stale holder of the state file lock",
+	Run: func(cmd *cobra.Command, args []string) {
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			log.Fatalf("Unable to parse `force` flag: %v", err)
+		}
+		stateFilename := stateFileStore().Filename
+		holder, err := cctlstate.ReadLockInfo(stateFilename)
+		if err != nil {
+			log.Fatalf("Unable to read state lock: %v", err)
+		}
+		if holder == nil {
+			log.Println("State file is not locked.")
+			return
+		}
+		log.Printf("State file lock records holder %s", holder)
+		if !force {
+			log.Fatalf("Not clearing the lock holder. Use --force.")
+		}
+		if _, err := cctlstate.ForceUnlock(stateFilename); err != nil {
+			log.Fatalf("Unable to clear state lock holder: %v", err)
+		}
+		log.Println("Cleared stale state lock holder.")
+	},
+}
+
+var stateCmdRestorePrevious = &cobra.Command{
+	Use:   "restore-previous",
+	Short: "Replace the state file with one of its previous versions",
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := cmd.Flags().GetBool("list")
+		if err != nil {
+			log.Fatalf("Unable to parse `list` flag: %v", err)
+		}
+		version, err := cmd.Flags().GetInt("version")
+		if err != nil {
+			log.Fatalf("Unable to parse `version` flag: %v", err)
+		}
+		store := stateFileStore()
+		backups, err := cctlstate.ListBackups(store.Filename)
+		if err != nil {
+			log.Fatalf("Unable to list previous versions of the state file: %v", err)
+		}
+		if list {
+			if len(backups) == 0 {
+				log.Println("No previous versions of the state file found.")
+			}
+			for _, b := range backups {
+				fmt.Printf("%d\t%s\t%s\n", b.Index, b.ModTime.Format(time.RFC3339), b.Path)
+			}
+			return
+		}
+		if version < 1 || version > len(backups) {
+			log.Fatalf("Previous version %d of the state file not found. Use --list to print available versions.", version)
+		}
+		if err := store.RestoreBackup(version); err != nil {
+			log.Fatalf("Unable to restore previous version %d of the state file: %v", version, err)
+		}
+		if journal := newStateJournal(store); journal != nil {
+			restoredBytes, err := store.Read()
+			if err != nil {
+				log.Fatalf("Unable to read restored state file: %v", err)
+			}
+			if err := journal.Append(restoredBytes, strings.Join(os.Args, " ")); err != nil {
+				log.Warnf("Restored state file, but could not record it in the journal: %v", err)
+			}
+		}
+		log.Printf("Restored state file from %q. The replaced state file is now previous version 1.", cctlstate.BackupFilename(store.Filename, version))
+	},
+}
+
+var stateCmdEncrypt = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt secrets in the state file",
+	Run: func(cmd *cobra.Command, args []string) {
+		InitState()
+		if err := state.EnableEncryption(stateKeyProvider()); err != nil {
+			log.Fatalf("Unable to enable encryption: %v", err)
+		}
+		if err := state.PullFromAPIs(); err != nil {
+			log.Fatalf("Unable to sync on-disk state: %v", err)
+		}
+		log.Printf("Encrypted secrets in state %q.", state.Store)
+		warnPlaintextBackups()
+		compactPlaintextJournal()
+	},
+}
+
+var stateCmdDecrypt = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Decrypt secrets in the state file",
+	Run: func(cmd *cobra.Command, args []string) {
+		InitState()
+		if err := state.DisableEncryption(); err != nil {
+			log.Fatalf("Unable to disable encryption: %v", err)
+		}
+		if err := state.PullFromAPIs(); err != nil {
+			log.Fatalf("Unable to sync on-disk state: %v", err)
+		}
+		log.Printf("Decrypted secrets in state %q.", state.Store)
+	},
+}
+
+var stateCmdHistory = &cobra.Command{
+	Use:   "history",
+	Short: "List the revisions of the state",
+	Run: func(cmd *cobra.Command, args []string) {
+		revisions, err := stateJournal().Revisions()
+		if err != nil {
+			log.Fatalf("Unable to read state revisions: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "REVISION\tTIMESTAMP\tCHANGES\tCOMMAND")
+		for _, rev := range revisions {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", rev.Revision, rev.Timestamp.Format(time.RFC3339), len(rev.Patch), rev.Command)
+		}
+		w.Flush()
+	},
+}
+
+var stateCmdDiff = &cobra.Command{
+	Use:   "diff <revision> [<revision>]",
+	Short: "Show the changes made by a revision of the state, or between two revisions",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		to, err := parseRevision(args[len(args)-1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		from := to - 1
+		if len(args) == 2 {
+			if from, err = parseRevision(args[0]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		journal := stateJournal()
+		fromDoc, err := journal.Document(from)
+		if err != nil {
+			log.Fatalf("Unable to get revision %d: %v", from, err)
+		}
+		toDoc, err := journal.Document(to)
+		if err != nil {
+			log.Fatalf("Unable to get revision %d: %v", to, err)
+		}
+		patch, err := cctlstate.DiffDocuments(fromDoc, toDoc)
+		if err != nil {
+			log.Fatalf("Unable to diff revisions %d and %d: %v", from, to, err)
+		}
+		if len(patch) == 0 {
+			log.Printf("No changes between revisions %d and %d.", from, to)
+			return
+		}
+		patchBytes, err := yaml.Marshal(patch)
+		if err != nil {
+			log.Fatalf("Unable to marshal patch to YAML: %v", err)
+		}
+		os.Stdout.Write(patchBytes)
+	},
+}
+
+var stateCmdRollback = &cobra.Command{
+	Use:   "rollback <revision>",
+	Short: "Replace the state with one of its revisions",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		revision, err := parseRevision(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		s := newState()
+		if s.Journal == nil {
+			log.Fatalf("State %q has no journal of revisions. Use --state-history.", s.Store)
+		}
+		if err := s.Rollback(revision); err != nil {
+			log.Fatalf("Unable to roll back to revision %d: %v", revision, err)
+		}
+		log.Printf("Rolled back state %q to revision %d.", s.Store, revision)
+	},
+}
+
+var stateCmdValidate = &cobra.Command{
+	Use:   "validate",
+	Short: "Check that the state is internally consistent",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, err := cmd.Flags().GetString("o")
+		if err != nil {
+			log.Fatalf("Unable to parse `o` flag: %v", err)
+		}
+		InitState()
+		violations, err := validate.Validate(state)
+		if err != nil {
+			log.Fatalf("Unable to validate state: %v", err)
+		}
+		switch output {
+		case "yaml":
+			bytes, err := yaml.Marshal(violations)
+			if err != nil {
+				log.Fatalf("Unable to marshal violations to yaml: %v", err)
+			}
+			os.Stdout.Write(bytes)
+		case "json":
+			bytes, err := json.Marshal(violations)
+			if err != nil {
+				log.Fatalf("Unable to marshal violations to json: %v", err)
+			}
+			os.Stdout.Write(bytes)
+		case "":
+			if len(violations) == 0 {
+				log.Println("State is consistent.")
+				break
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "SEVERITY\tCHECK\tOBJECT\tMESSAGE")
+			for _, v := range violations {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Severity, v.Check, v.Object, v.Message)
+			}
+			w.Flush()
+		default:
+			log.Fatalf("Unsupported output format %q", output)
+		}
+		if validate.HasErrors(violations) {
+			os.Exit(1)
+		}
+	},
+}
+
+// preflightValidateState exits if --validate-state is set and the state has
+// errors. It must be called after InitState.
+func preflightValidateState() {
+	if !validateState {
+		return
+	}
+	violations, err := validate.Validate(state)
+	if err != nil {
+		log.Fatalf("Unable to validate state: %v", err)
+	}
+	for _, v := range violations {
+		if v.Severity == validate.SeverityError {
+			log.Errorf("State validation: %s", v)
+		} else {
+			log.Warnf("State validation: %s", v)
+		}
+	}
+	if validate.HasErrors(violations) {
+		log.Fatalf("State %q is inconsistent. Fix the errors, or run without --validate-state.", state.Store)
+	}
+}
+
+// stateJournal returns the journal of state revisions, and exits if there is
+// none.
+func stateJournal() *cctlstate.Journal {
+	journal := newStateJournal(newStateStore())
+	if journal == nil {
+		log.Fatalf("State %q has no journal of revisions. Use --state-history.", stateURL)
+	}
+	return journal
+}
+
+func parseRevision(arg string) (int, error) {
+	revision, err := strconv.Atoi(arg)
+	if err != nil || revision < 1 {
+		return 0, fmt.Errorf("invalid revision %q: must be a positive integer", arg)
+	}
+	return revision, nil
+}
+
+// warnPlaintextBackups warns that previous versions of the state file written
+// before encryption was enabled still hold plain text secrets.
+func warnPlaintextBackups() {
+	fs, ok := state.Store.(*cctlstate.FileStore)
+	if !ok {
+		return
+	}
+	backups, err := cctlstate.ListBackups(fs.Filename)
+	if err != nil {
+		log.Warnf("Unable to list previous versions of the state file: %v", err)
+		return
+	}
+	for _, b := range backups {
+		log.Warnf("Previous version %q of the state file may contain plain text secrets. Remove it if it is no longer needed.", b.Path)
+	}
+}
+
+// compactPlaintextJournal removes plain text secret data that the journal
+// recorded before secret data was redacted.
+func compactPlaintextJournal() {
+	if state.Journal == nil {
+		return
+	}
+	changed, err := state.Journal.Compact()
+	if err != nil {
+		log.Warnf("Journal %q may contain plain text secrets, and could not be compacted: %v", state.Journal.Filename, err)
+		return
+	}
+	if changed {
+		log.Warnf("Compacted journal %q, which contained plain text secrets of earlier revisions. Revisions were numbered again.", state.Journal.Filename)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+	stateCmd.AddCommand(stateCmdUnlock)
+	stateCmdUnlock.Flags().Bool("force", false, "Clear the lock holder, unless a running process still holds the lock")
+
+	stateCmd.AddCommand(stateCmdRestorePrevious)
+	stateCmdRestorePrevious.Flags().Int("version", 1, "Previous version to restore, where 1 is the most recent")
+	stateCmdRestorePrevious.Flags().Bool("list", false, "List the previous versions of the state file")
+
+	stateCmd.AddCommand(stateCmdEncrypt)
+	stateCmd.AddCommand(stateCmdDecrypt)
+
+	stateCmd.AddCommand(stateCmdHistory)
+	stateCmd.AddCommand(stateCmdDiff)
+	stateCmd.AddCommand(stateCmdRollback)
+
+	stateCmd.AddCommand(stateCmdValidate)
+	stateCmdValidate.Flags().String("o", "", "Output format yaml|json")
+}
@@ -27,6 +27,7 @@ const (
 	MasterRole                          = "master"
 	NodeRole                            = "node"
 	DefaultSSHPort                      = 22
+	DefaultMachineParallelism           = 5
 	DefaultNamespace                    = "default"
 	DefaultClusterName                  = "cctl-cluster"
 	DefaultSSHCredentialSecretName      = "ssh-credential"
@@ -50,6 +51,9 @@ const (
 	DockerKubeAPIServerNameFilter       = "name=k8s_kube-apiserver.*kube-system.*"
 	DockerRunningStatusFilter           = "status=running"
 	InstanceStatusAnnotationKey         = "instance-status"
+	CreationPhaseAnnotationKey          = "cctl.platform9.com/creation-phase"
+	ProductUUIDAnnotationKey            = "cctl.platform9.com/product-uuid"
+	KubeletConfigOverrideAnnotationKey  = "cctl.platform9.com/kubelet-config-override"
 	KubeAPIServer                       = "kube-apiserver"
 	KubeControllerManager               = "kube-controller-manager"
 	KubeScheduler                       = "kube-scheduler"
@@ -79,12 +83,41 @@ Networking
 	VIP          : None configured.
 	{{  end  }}
 `
-	MachineV1PrintTemplate = `Machine Information
-------- -----------
-Machine IP             Creation Timestamp                      Role
-{{ range $machine := .}}{{ $machine.ObjectMeta.Name }}           {{ $machine.ObjectMeta.CreationTimestamp }}           {{ $machine.Spec.Roles }}
-{{ end }}
-`
+	// ClusterListV1PrintTemplate is printed with a tabwriter, so columns are
+	// separated by tabs
+	ClusterListV1PrintTemplate = `NAME	NAMESPACE	MACHINES	CURRENT
+{{ range .}}{{ .Name }}	{{ .Namespace }}	{{ .Machines }}	{{ if .Current }}*{{ end }}
+{{ end }}`
+	// CredentialListPrintTemplate is printed with a tabwriter, so columns are
+	// separated by tabs
+	CredentialListPrintTemplate = `NAME	USER	TYPE	BECOME	MACHINES
+{{ range .}}{{ .Name }}	{{ .User }}	{{ .Type }}	{{ .Become }}	{{ .Machines }}
+{{ end }}`
+	// MachineResultPrintTemplate is printed with a tabwriter, so columns are
+	// separated by tabs
+	MachineResultPrintTemplate = `MACHINE	ROLE	RESULT
+{{ range .}}{{ .IP }}	{{ .Role }}	{{ .Result }}
+{{ end }}`
+	// PreflightResultPrintTemplate is printed with a tabwriter, so columns are
+	// separated by tabs
+	PreflightResultPrintTemplate = `MACHINE	CHECK	STATUS	MESSAGE
+{{ range .Results }}{{ $.Machine }}	{{ .Check }}	{{ .Status }}	{{ .Message }}
+{{ end }}`
+	// HostKeyPrintTemplate is printed with a tabwriter, so columns are
+	// separated by tabs
+	HostKeyPrintTemplate = `MACHINE	TYPE	FINGERPRINT	STATUS
+{{ range .Keys }}{{ $.Machine }}	{{ .Type }}	{{ .Fingerprint }}	{{ .Status }}
+{{ end }}`
+	// FanOutResultPrintTemplate is printed with a tabwriter, so columns are
+	// separated by tabs
+	FanOutResultPrintTemplate = `MACHINE	ROLES	EXIT	RESULT
+{{ range .}}{{ .IP }}	{{ .Roles }}	{{ .Exit }}	{{ .Result }}
+{{ end }}`
+	// MachineListPrintTemplate is printed with a tabwriter, so columns are
+	// separated by tabs
+	MachineListPrintTemplate = `IP	ROLES	KUBERNETES	ETCD	ETCD-MEMBER	VIP-IFACE	HOST-KEY{{ if .Wide }}	NODEADM	ETCDADM	CNI	FLANNEL	KEEPALIVED	ETCD-ID	CREDENTIAL	CREATED{{ end }}{{ if .Live }}	NODE	READY	KUBELET{{ end }}
+{{ range .Machines }}{{ .IP }}	{{ .Roles }}	{{ .KubernetesVersion }}	{{ .EtcdVersion }}	{{ .EtcdMemberName }}	{{ .VIPInterface }}	{{ .HostKey }}{{ if $.Wide }}	{{ .NodeadmVersion }}	{{ .EtcdadmVersion }}	{{ .CNIVersion }}	{{ .FlannelVersion }}	{{ .KeepalivedVersion }}	{{ .EtcdMemberID }}	{{ .Credential }}	{{ .Created }}{{ end }}{{ if $.Live }}	{{ .Node }}	{{ .Ready }}	{{ .KubeletVersion }}{{ end }}
+{{ end }}`
 	// LabelNodeRoleMaster specifies that a node is a master
 	LabelNodeRoleMaster = "node-role.kubernetes.io/master"
 )
@@ -42,6 +42,31 @@ func getKubeClient(kubeconfig string) (clientset.Interface, error) {
 	return client, nil
 }
 
+// Nodes returns all Nodes in the cluster
+func Nodes(kubeconfig string) ([]v1.Node, error) {
+	client, err := getKubeClient(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kube client: %v", err)
+	}
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list nodes in cluster: %v", err)
+	}
+	return nodes.Items, nil
+}
+
+// DeleteNode deletes the Node from the cluster
+func DeleteNode(kubeconfig, name string) error {
+	client, err := getKubeClient(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("unable to create kube client: %v", err)
+	}
+	if err := client.CoreV1().Nodes().Delete(name, &metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("couldn't delete node %q: %v", name, err)
+	}
+	return nil
+}
+
 // MasterNodesReady checks whether all master Nodes in the cluster are in the Ready state
 func MasterNodesReady(kubeconfig string) error {
 	client, err := getKubeClient(kubeconfig)
@@ -0,0 +1,208 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package adopt reads the configuration of a cluster deployed with nodeadm
+// and etcdadm from its machines, so that the cluster can be recorded in the
+// cctl state.
+package adopt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	spmachine "github.com/platform9/ssh-provider/pkg/clusterapi/machine"
+	"github.com/platform9/ssh-provider/pkg/machine"
+	"github.com/platform9/ssh-provider/pkg/nodeadm"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/util/kubeadm"
+	"github.com/platform9/cctl/pkg/util/secret"
+)
+
+const (
+	KubeadmPath = "/opt/bin/kubeadm"
+	KubeletPath = "/opt/bin/kubelet"
+	EtcdPath    = "/opt/bin/etcd"
+)
+
+// ClusterSecrets maps the names of the cluster secrets to the files they are
+// read from on a master.
+var ClusterSecrets = map[string]spmachine.ClusterSecretConstants{
+	common.DefaultAPIServerCASecretName:       spmachine.APIServerCASecretConstants,
+	common.DefaultEtcdCASecretName:            spmachine.EtcdCASecretConstants,
+	common.DefaultFrontProxyCASecretName:      spmachine.FrontProxyCASecretConstants,
+	common.DefaultServiceAccountKeySecretName: spmachine.ServiceAccountKeySecretConstants,
+}
+
+func runCommand(client machine.Client, cmd string) ([]byte, error) {
+	stdOut, stdErr, err := client.RunCommand(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error running %q: %v (%s) (%s)", cmd, err, string(stdOut), string(stdErr))
+	}
+	return stdOut, nil
+}
+
+// ReadClusterConfiguration returns the kubeadm ClusterConfiguration of the
+// master.
+func ReadClusterConfiguration(client machine.Client) (*kubeadm.ClusterConfiguration, error) {
+	stdOut, err := runCommand(client, fmt.Sprintf("%s config view", KubeadmPath))
+	if err != nil {
+		return nil, err
+	}
+	kcc := kubeadm.ClusterConfiguration{}
+	if err := yaml.Unmarshal(stdOut, &kcc); err != nil {
+		return nil, fmt.Errorf("unable to read kubeadm ClusterConfiguration: %v", err)
+	}
+	return &kcc, nil
+}
+
+// ReadInitConfiguration returns the nodeadm configuration of the master, or
+// nil if the master has none.
+func ReadInitConfiguration(client machine.Client) (*nodeadm.InitConfiguration, error) {
+	exists, err := client.Exists(spmachine.NodeadmConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check if %q exists: %v", spmachine.NodeadmConfigPath, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	cfgBytes, err := client.ReadFile(spmachine.NodeadmConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %v", spmachine.NodeadmConfigPath, err)
+	}
+	cfg := nodeadm.InitConfiguration{}
+	if err := yaml.Unmarshal(cfgBytes, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to read nodeadm configuration from %q: %v", spmachine.NodeadmConfigPath, err)
+	}
+	return &cfg, nil
+}
+
+// ReadClusterSecret returns the cluster secret with the given name, read from
+// the certificate and key files on the master.
+func ReadClusterSecret(client machine.Client, name string) (*corev1.Secret, error) {
+	constants, ok := ClusterSecrets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster secret %q", name)
+	}
+	cert, err := client.ReadFile(constants.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %v", constants.CertPath, err)
+	}
+	key, err := client.ReadFile(constants.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %v", constants.KeyPath, err)
+	}
+	return secret.CreateSecretWithData(name, map[string][]byte{
+		constants.CertKey: cert,
+		constants.KeyKey:  key,
+	}), nil
+}
+
+// ReadEtcdMember returns the etcd member running on the master.
+func ReadEtcdMember(client machine.Client) (*spv1.EtcdMember, error) {
+	stdOut, err := runCommand(client, fmt.Sprintf("%s info", spmachine.EtcdadmPath))
+	if err != nil {
+		return nil, err
+	}
+	member := spv1.EtcdMember{}
+	if err := json.Unmarshal(stdOut, &member); err != nil {
+		return nil, fmt.Errorf("unable to read etcdadm info output: %v", err)
+	}
+	return &member, nil
+}
+
+// ReadComponentVersions returns the versions of the components installed on
+// the machine. Etcd and etcdadm are read only from masters.
+func ReadComponentVersions(client machine.Client, master bool) (*spv1.MachineComponentVersions, error) {
+	versions := spv1.MachineComponentVersions{}
+
+	stdOut, err := runCommand(client, fmt.Sprintf("%s version --short", spmachine.NodeadmPath))
+	if err != nil {
+		return nil, err
+	}
+	versions.NodeadmVersion = withV(strings.TrimSpace(string(stdOut)))
+
+	stdOut, err = runCommand(client, fmt.Sprintf("%s --version", KubeletPath))
+	if err != nil {
+		return nil, err
+	}
+	// Output is of the form "Kubernetes v1.12.8"
+	fields := strings.Fields(string(stdOut))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("unable to parse kubelet version from %q", string(stdOut))
+	}
+	versions.KubernetesVersion = strings.TrimPrefix(fields[1], "v")
+
+	if master {
+		stdOut, err = runCommand(client, fmt.Sprintf("%s version --short", spmachine.EtcdadmPath))
+		if err != nil {
+			return nil, err
+		}
+		versions.EtcdadmVersion = withV(strings.TrimSpace(string(stdOut)))
+
+		stdOut, err = runCommand(client, fmt.Sprintf("%s --version", EtcdPath))
+		if err != nil {
+			return nil, err
+		}
+		// The first line of output is of the form "etcd Version: 3.3.8"
+		line := strings.SplitN(string(stdOut), "\n", 2)[0]
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[1] != "Version:" {
+			return nil, fmt.Errorf("unable to parse etcd version from %q", line)
+		}
+		versions.EtcdVersion = withV(fields[2])
+	} else {
+		// Nodes do not run etcd. Record the versions cctl would record for a
+		// node it created, so that upgrade does not consider them changed.
+		versions.EtcdadmVersion = common.DefaultEtcdadmVersion
+		versions.EtcdVersion = common.DefaultEtcdVersion
+	}
+
+	// CNI, flannel and keepalived are deployed by nodeadm, and their versions
+	// are fixed by the nodeadm version. If nodeadm is the version cctl deploys,
+	// so are they. Otherwise they are left empty, and upgrade replaces them.
+	if versions.NodeadmVersion == common.DefaultNodeadmVersion {
+		versions.CNIVersion = common.DefaultCNIVersion
+		versions.FlannelVersion = common.DefaultFlannelVersion
+		versions.KeepalivedVersion = common.DefaultKeepalivedVersion
+	}
+	return &versions, nil
+}
+
+func withV(version string) string {
+	return "v" + strings.TrimPrefix(version, "v")
+}
+
+// ClusterConfig returns the cluster configuration of a cluster with the given
+// kubeadm and nodeadm configuration. The nodeadm configuration may be nil.
+func ClusterConfig(kcc *kubeadm.ClusterConfiguration, cfg *nodeadm.InitConfiguration) *spv1.ClusterConfig {
+	cc := spv1.ClusterConfig{
+		KubeAPIServer:         kcc.APIServerExtraArgs,
+		KubeControllerManager: kcc.ControllerManagerExtraArgs,
+		KubeScheduler:         kcc.SchedulerExtraArgs,
+	}
+	if cfg != nil {
+		cc.Kubelet = cfg.MasterConfiguration.KubeletConfiguration.BaseConfig
+		cc.KubeProxy = cfg.MasterConfiguration.KubeProxy.Config
+		cc.NetworkBackend = cfg.NetworkBackend
+		cc.KeepAlived = cfg.KeepAlived
+	}
+	return &cc
+}
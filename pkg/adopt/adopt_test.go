@@ -0,0 +1,166 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adopt_test
+
+import (
+	"reflect"
+	"testing"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/adopt"
+	"github.com/platform9/cctl/pkg/etcdcluster"
+	"github.com/platform9/cctl/pkg/machineclient/fake"
+)
+
+func newMasterClient() *fake.Client {
+	return &fake.Client{
+		Commands: map[string]string{
+			"/opt/bin/nodeadm version --short": common.DefaultNodeadmVersion + "\n",
+			"/opt/bin/etcdadm version --short": "0.1.1\n",
+			"/opt/bin/kubelet --version":       "Kubernetes v1.12.8\n",
+			"/opt/bin/etcd --version":          "etcd Version: 3.3.8\nGit SHA: 33245c6b5\nGo Version: go1.10.3\n",
+			"/opt/bin/etcdadm info":            `{"ID":1,"name":"m1","peerURLs":["https://10.0.0.1:2380"],"clientURLs":["https://10.0.0.1:2379"]}`,
+			"/opt/bin/etcdctl.sh member list --write-out=json": `{"header":{"cluster_id":7},"members":[` +
+				`{"ID":1,"name":"m1","peerURLs":["https://10.0.0.1:2380"],"clientURLs":["https://10.0.0.1:2379"]},` +
+				`{"ID":2,"name":"m2","peerURLs":["https://10.0.0.2:2380"],"clientURLs":["https://10.0.0.2:2379"]}]}`,
+			"/opt/bin/kubeadm config view": `apiServerExtraArgs:
+  service-node-port-range: 80-32767
+controlPlaneEndpoint: 10.0.0.10:6443
+kubernetesVersion: v1.12.8
+networking:
+  dnsDomain: cluster.local
+  podSubnet: 10.2.0.0/16
+  serviceSubnet: 10.1.0.0/16
+`,
+		},
+		Files: map[string]string{
+			"/etc/kubernetes/pki/ca.crt": "apiserver-cert",
+			"/etc/kubernetes/pki/ca.key": "apiserver-key",
+			"/etc/kubernetes/pki/sa.pub": "sa-pub",
+			"/etc/kubernetes/pki/sa.key": "sa-key",
+			"/etc/nodeadm.yaml": `vipConfiguration:
+  ip: 10.0.0.10
+  routerID: 42
+  networkInterface: eth1
+networkBackend:
+  type: vxlan
+masterConfiguration:
+  kubeletConfiguration:
+    baseConfig:
+      maxPods: 200
+`,
+		},
+	}
+}
+
+func TestReadComponentVersions(t *testing.T) {
+	client := newMasterClient()
+	versions, err := adopt.ReadComponentVersions(client, true)
+	if err != nil {
+		t.Fatalf("Error reading component versions: %v", err)
+	}
+	expected := &spv1.MachineComponentVersions{
+		NodeadmVersion:    common.DefaultNodeadmVersion,
+		EtcdadmVersion:    "v0.1.1",
+		KubernetesVersion: "1.12.8",
+		CNIVersion:        common.DefaultCNIVersion,
+		FlannelVersion:    common.DefaultFlannelVersion,
+		KeepalivedVersion: common.DefaultKeepalivedVersion,
+		EtcdVersion:       "v3.3.8",
+	}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Fatalf("Expected versions %+v, found %+v", expected, versions)
+	}
+
+	client.Commands["/opt/bin/nodeadm version --short"] = "v0.2.0\n"
+	delete(client.Commands, "/opt/bin/etcd --version")
+	versions, err = adopt.ReadComponentVersions(client, false)
+	if err != nil {
+		t.Fatalf("Error reading node component versions: %v", err)
+	}
+	if versions.EtcdVersion != common.DefaultEtcdVersion {
+		t.Fatalf("Expected node etcd version %q, found %q", common.DefaultEtcdVersion, versions.EtcdVersion)
+	}
+	if len(versions.CNIVersion) != 0 {
+		t.Fatalf("Expected empty CNI version for a different nodeadm version, found %q", versions.CNIVersion)
+	}
+}
+
+func TestReadEtcdMember(t *testing.T) {
+	client := newMasterClient()
+	member, err := adopt.ReadEtcdMember(client)
+	if err != nil {
+		t.Fatalf("Error reading etcd member: %v", err)
+	}
+	members, err := etcdcluster.ReadMembers(client)
+	if err != nil {
+		t.Fatalf("Error reading etcd members: %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("Expected 2 etcd members, found %d", len(members))
+	}
+	if !reflect.DeepEqual(*member, members[0]) {
+		t.Fatalf("Expected member %+v, found %+v", members[0], *member)
+	}
+}
+
+func TestClusterConfiguration(t *testing.T) {
+	client := newMasterClient()
+	kcc, err := adopt.ReadClusterConfiguration(client)
+	if err != nil {
+		t.Fatalf("Error reading kubeadm configuration: %v", err)
+	}
+	if kcc.Networking.PodSubnet != "10.2.0.0/16" || kcc.Networking.ServiceSubnet != "10.1.0.0/16" {
+		t.Fatalf("Unexpected networking configuration %+v", kcc.Networking)
+	}
+	cfg, err := adopt.ReadInitConfiguration(client)
+	if err != nil {
+		t.Fatalf("Error reading nodeadm configuration: %v", err)
+	}
+	if cfg == nil || cfg.VIPConfiguration == nil || cfg.VIPConfiguration.RouterID != 42 {
+		t.Fatalf("Expected VIP configuration with router ID 42, found %+v", cfg)
+	}
+	cc := adopt.ClusterConfig(kcc, cfg)
+	if cc.KubeAPIServer["service-node-port-range"] != "80-32767" {
+		t.Fatalf("Expected API server args from kubeadm configuration, found %v", cc.KubeAPIServer)
+	}
+	if cc.Kubelet == nil || cc.Kubelet.MaxPods != 200 {
+		t.Fatalf("Expected kubelet configuration from nodeadm configuration, found %+v", cc.Kubelet)
+	}
+
+	delete(client.Files, "/etc/nodeadm.yaml")
+	cfg, err = adopt.ReadInitConfiguration(client)
+	if err != nil || cfg != nil {
+		t.Fatalf("Expected no nodeadm configuration, found %+v (%v)", cfg, err)
+	}
+}
+
+func TestReadClusterSecret(t *testing.T) {
+	client := newMasterClient()
+	s, err := adopt.ReadClusterSecret(client, common.DefaultServiceAccountKeySecretName)
+	if err != nil {
+		t.Fatalf("Error reading secret: %v", err)
+	}
+	if string(s.Data["publickey"]) != "sa-pub" || string(s.Data["privatekey"]) != "sa-key" {
+		t.Fatalf("Unexpected secret data %v", s.Data)
+	}
+	if _, err := adopt.ReadClusterSecret(client, common.DefaultEtcdCASecretName); err == nil {
+		t.Fatalf("Expected error reading secret whose files are missing")
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config reads and writes the cctl configuration file, which holds
+// settings that persist between invocations, such as the current context.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/client-go/util/homedir"
+)
+
+const (
+	// FileMode defines the file mode used to create the configuration file.
+	FileMode = 0600
+	// EnvVar is the environment variable that overrides the default location
+	// of the configuration file.
+	EnvVar = "CCTL_CONFIG"
+)
+
+// Config is the cctl configuration.
+type Config struct {
+	// CurrentContext is the name of the cluster that commands act on if no
+	// cluster is given.
+	// +optional
+	CurrentContext string `json:"currentContext,omitempty"`
+}
+
+// DefaultFilename returns the location of the configuration file: the value of
+// EnvVar if it is set, and $HOME/.cctl/config.yaml otherwise.
+func DefaultFilename() string {
+	if filename := os.Getenv(EnvVar); len(filename) != 0 {
+		return filename
+	}
+	return filepath.Join(homedir.HomeDir(), ".cctl", "config.yaml")
+}
+
+// Load reads the configuration file. If the file does not exist, an empty
+// configuration is returned.
+func Load(filename string) (*Config, error) {
+	cfg := Config{}
+	cfgBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, fmt.Errorf("unable to read %q: %v", filename, err)
+	}
+	if err := yaml.Unmarshal(cfgBytes, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal config from YAML: %v", err)
+	}
+	return &cfg, nil
+}
+
+// Save writes the configuration file, creating its directory if needed.
+func (c *Config) Save(filename string) error {
+	cfgBytes, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("unable to marshal config to YAML: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("unable to create directory for %q: %v", filename, err)
+	}
+	if err := ioutil.WriteFile(filename, cfgBytes, FileMode); err != nil {
+		return fmt.Errorf("unable to write %q: %v", filename, err)
+	}
+	return nil
+}
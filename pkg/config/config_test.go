@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/platform9/cctl/pkg/config"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-config-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, ".cctl", "config.yaml")
+
+	cfg, err := config.Load(filename)
+	if err != nil {
+		t.Fatalf("Error loading missing config: %v", err)
+	}
+	if cfg.CurrentContext != "" {
+		t.Fatalf("Expected empty current context, found %q", cfg.CurrentContext)
+	}
+	cfg.CurrentContext = "site-a"
+	if err := cfg.Save(filename); err != nil {
+		t.Fatalf("Error saving config: %v", err)
+	}
+	cfg, err = config.Load(filename)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.CurrentContext != "site-a" {
+		t.Fatalf("Expected current context %q, found %q", "site-a", cfg.CurrentContext)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credential
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentSocketEnvVar is the environment variable that holds the path of the
+// SSH agent socket.
+const AgentSocketEnvVar = "SSH_AUTH_SOCK"
+
+// AgentSigners returns a signer for every key held by the SSH agent at
+// SSH_AUTH_SOCK.
+func AgentSigners() ([]ssh.Signer, error) {
+	socket := os.Getenv(AgentSocketEnvVar)
+	if len(socket) == 0 {
+		return nil, fmt.Errorf("%s is not set. Start an SSH agent and add keys to it with ssh-add", AgentSocketEnvVar)
+	}
+	return agentSigners(socket)
+}
+
+// agentSigners returns the signers of the SSH agent at the socket. The private
+// keys never leave the agent, so the connection stays open for the signers to
+// use.
+func agentSigners(socket string) ([]ssh.Signer, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to SSH agent at %q: %v", socket, err)
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to list SSH agent keys: %v", err)
+	}
+	if len(signers) == 0 {
+		conn.Close()
+		return nil, fmt.Errorf("the SSH agent has no keys. Add keys to it with ssh-add")
+	}
+	return signers, nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package credential reads and writes the SSH credentials that cctl uses to
+// log in to machines, and turns them into SSH authentication methods.
+package credential
+
+import (
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/platform9/cctl/pkg/machineclient"
+)
+
+const (
+	// UsernameKey is the secret key of the username.
+	UsernameKey = "username"
+	// PrivateKeyKey is the secret key of the PEM-encoded private key. An
+	// agent credential has an empty private key.
+	PrivateKeyKey = "ssh-privatekey"
+	// TypeKey is the secret key of the credential type. A secret without it
+	// is a private key credential.
+	TypeKey = "type"
+	// BecomeKey is the secret key of the privilege escalation method. A
+	// secret without it uses sudo without a password.
+	BecomeKey = "become"
+
+	// TypePrivateKey authenticates with the private key in the secret.
+	TypePrivateKey = "private-key"
+	// TypeAgent authenticates with the keys of the SSH agent at
+	// SSH_AUTH_SOCK, and keeps no key material in the secret.
+	TypeAgent = "agent"
+)
+
+// Credential is an SSH credential.
+type Credential struct {
+	Name       string
+	Username   string
+	Type       string
+	PrivateKey []byte
+	// Become is how the user gains root privileges, one of the
+	// machineclient escalation methods.
+	Become string
+}
+
+// IsCredential returns true if the secret is an SSH credential.
+func IsCredential(secret *corev1.Secret) bool {
+	_, hasUsername := secret.Data[UsernameKey]
+	_, hasPrivateKey := secret.Data[PrivateKeyKey]
+	return hasUsername && hasPrivateKey
+}
+
+// FromSecret returns the credential stored in the secret.
+func FromSecret(secret *corev1.Secret) (*Credential, error) {
+	if !IsCredential(secret) {
+		return nil, fmt.Errorf("secret %q is not an SSH credential: it must have keys %q and %q", secret.Name, UsernameKey, PrivateKeyKey)
+	}
+	c := &Credential{
+		Name:       secret.Name,
+		Username:   string(secret.Data[UsernameKey]),
+		Type:       TypePrivateKey,
+		PrivateKey: secret.Data[PrivateKeyKey],
+		Become:     machineclient.EscalationSudo,
+	}
+	if t, ok := secret.Data[TypeKey]; ok {
+		c.Type = string(t)
+	}
+	if c.Type != TypePrivateKey && c.Type != TypeAgent {
+		return nil, fmt.Errorf("SSH credential %q has unknown type %q", secret.Name, c.Type)
+	}
+	if b, ok := secret.Data[BecomeKey]; ok {
+		c.Become = string(b)
+	}
+	if err := machineclient.ValidateEscalationMethod(c.Become); err != nil {
+		return nil, fmt.Errorf("SSH credential %q: %v", secret.Name, err)
+	}
+	return c, nil
+}
+
+// Secret returns the secret that stores the credential.
+func (c *Credential) Secret(namespace string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              c.Name,
+			Namespace:         namespace,
+			CreationTimestamp: metav1.Now(),
+		},
+		Data: map[string][]byte{
+			UsernameKey: []byte(c.Username),
+			// The key must be present, even if empty, so that the
+			// machine actuator accepts the secret.
+			PrivateKeyKey: c.PrivateKey,
+			TypeKey:       []byte(c.Type),
+			BecomeKey:     []byte(c.Become),
+		},
+	}
+}
+
+// Encrypted returns true if the private key is protected by a passphrase.
+func (c *Credential) Encrypted() bool {
+	block, _ := pem.Decode(c.PrivateKey)
+	return block != nil && strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED")
+}
+
+// Signers returns the signers of the credential. The passphrase of an
+// encrypted private key is obtained from passphrases.
+func (c *Credential) Signers(passphrases *Passphrases) ([]ssh.Signer, error) {
+	switch c.Type {
+	case TypeAgent:
+		return AgentSigners()
+	default:
+		if !c.Encrypted() {
+			signer, err := ssh.ParsePrivateKey(c.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing private key: %v. An OpenSSH format key protected by a passphrase is not supported; convert it with `ssh-keygen -p -m PEM`", err)
+			}
+			return []ssh.Signer{signer}, nil
+		}
+		passphrase, err := passphrases.Get(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(c.PrivateKey, passphrase)
+		if err != nil {
+			passphrases.Forget(c.Name)
+			return nil, fmt.Errorf("error decrypting private key of SSH credential %q: %v", c.Name, err)
+		}
+		return []ssh.Signer{signer}, nil
+	}
+}
+
+// Escalation returns how the user of the credential gains root privileges.
+// The sudo password, if one is needed, is obtained from sudoPasswords when a
+// command is first run.
+func (c *Credential) Escalation(sudoPasswords *Passphrases) machineclient.Escalation {
+	escalation := machineclient.Escalation{Method: c.Become}
+	if c.Become == machineclient.EscalationSudoPassword {
+		name := c.Name
+		escalation.Password = func() ([]byte, error) {
+			return sudoPasswords.Get(name)
+		}
+	}
+	return escalation
+}
+
+// AuthMethod returns the SSH authentication method of the credential.
+func (c *Credential) AuthMethod(passphrases *Passphrases) (ssh.AuthMethod, error) {
+	signers, err := c.Signers(passphrases)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.PublicKeys(signers...), nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/platform9/cctl/pkg/machineclient"
+)
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	return key
+}
+
+func encryptedKeyPEM(t *testing.T, key *rsa.PrivateKey, passphrase string) []byte {
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte(passphrase), x509.PEMCipherAES128)
+	if err != nil {
+		t.Fatalf("Error encrypting key: %v", err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	c := Credential{Name: "bastion", Username: "ubuntu", Type: TypeAgent, Become: machineclient.EscalationNone}
+	secret := c.Secret("default")
+	if len(secret.Data[PrivateKeyKey]) != 0 {
+		t.Fatalf("Expected agent credential to store no key, found %q", secret.Data[PrivateKeyKey])
+	}
+	if !IsCredential(secret) {
+		t.Fatalf("Expected secret to be a credential")
+	}
+	got, err := FromSecret(secret)
+	if err != nil {
+		t.Fatalf("Error reading credential: %v", err)
+	}
+	if got.Name != c.Name || got.Username != c.Username || got.Type != c.Type || got.Become != c.Become {
+		t.Fatalf("Expected %+v, found %+v", c, got)
+	}
+	delete(secret.Data, TypeKey)
+	delete(secret.Data, BecomeKey)
+	if got, err = FromSecret(secret); err != nil || got.Type != TypePrivateKey || got.Become != machineclient.EscalationSudo {
+		t.Fatalf("Expected secret without type to be a private key credential using sudo, found %+v, %v", got, err)
+	}
+	secret.Data[BecomeKey] = []byte("su")
+	if _, err = FromSecret(secret); err == nil {
+		t.Fatalf("Expected error reading credential with unknown escalation")
+	}
+}
+
+func TestSudoPasswordEscalation(t *testing.T) {
+	c := Credential{Name: "deploy", Become: machineclient.EscalationSudoPassword}
+	env := map[string]string{SudoPasswordEnvVar: "sudo-secret", PassphraseEnvVar: "passphrase"}
+	sudoPasswords := &Passphrases{
+		EnvVar: SudoPasswordEnvVar,
+		Getenv: func(k string) string { return env[k] },
+	}
+	escalation := c.Escalation(sudoPasswords)
+	if escalation.Method != machineclient.EscalationSudoPassword {
+		t.Fatalf("Expected method %q, found %q", machineclient.EscalationSudoPassword, escalation.Method)
+	}
+	password, err := escalation.Password()
+	if err != nil {
+		t.Fatalf("Error getting sudo password: %v", err)
+	}
+	if string(password) != "sudo-secret" {
+		t.Fatalf("Expected sudo password %q, found %q", "sudo-secret", password)
+	}
+}
+
+func TestEncryptedKeyPassphraseFromEnv(t *testing.T) {
+	key := newKey(t)
+	c := Credential{Name: "ssh-credential", Type: TypePrivateKey, PrivateKey: encryptedKeyPEM(t, key, "secret")}
+	if !c.Encrypted() {
+		t.Fatalf("Expected key to be encrypted")
+	}
+	env := map[string]string{PassphraseEnvVar: "wrong", "CCTL_SSH_PASSPHRASE_SSH_CREDENTIAL": "secret"}
+	p := &Passphrases{
+		Getenv: func(k string) string { return env[k] },
+		Prompt: func(string) ([]byte, error) {
+			t.Fatalf("Expected no prompt")
+			return nil, nil
+		},
+	}
+	signers, err := c.Signers(p)
+	if err != nil {
+		t.Fatalf("Error decrypting key: %v", err)
+	}
+	if string(signers[0].PublicKey().Marshal()) != string(mustPublicKey(t, key).Marshal()) {
+		t.Fatalf("Unexpected public key")
+	}
+}
+
+func TestEncryptedKeyWrongPassphraseIsForgotten(t *testing.T) {
+	c := Credential{Name: "ssh-credential", Type: TypePrivateKey, PrivateKey: encryptedKeyPEM(t, newKey(t), "secret")}
+	prompts := 0
+	p := &Passphrases{
+		Getenv: func(string) string { return "" },
+		Prompt: func(string) ([]byte, error) {
+			prompts++
+			if prompts == 1 {
+				return []byte("wrong"), nil
+			}
+			return []byte("secret"), nil
+		},
+	}
+	if _, err := c.Signers(p); err == nil {
+		t.Fatalf("Expected error decrypting key with wrong passphrase")
+	}
+	if _, err := c.Signers(p); err != nil {
+		t.Fatalf("Error decrypting key: %v", err)
+	}
+	if _, err := c.Signers(p); err != nil {
+		t.Fatalf("Error decrypting key: %v", err)
+	}
+	if prompts != 2 {
+		t.Fatalf("Expected 2 prompts, found %d", prompts)
+	}
+}
+
+func mustPublicKey(t *testing.T, key *rsa.PrivateKey) ssh.PublicKey {
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Error creating public key: %v", err)
+	}
+	return pub
+}
+
+// serveAgent serves a keyring that holds the key, like an SSH agent.
+func serveAgent(t *testing.T, listener net.Listener, key *rsa.PrivateKey) {
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key, Comment: "test"}); err != nil {
+		t.Fatalf("Error adding key to agent: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}(conn)
+		}
+	}()
+}
+
+func TestAgentSigners(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-agent-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer listener.Close()
+	key := newKey(t)
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Error creating signer: %v", err)
+	}
+	serveAgent(t, listener, key)
+
+	signers, err := agentSigners(socket)
+	if err != nil {
+		t.Fatalf("Error listing agent keys: %v", err)
+	}
+	if len(signers) != 1 {
+		t.Fatalf("Expected 1 agent key, found %d", len(signers))
+	}
+	data := []byte("session data")
+	signature, err := signers[0].Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("Error signing with agent: %v", err)
+	}
+	if err := signer.PublicKey().Verify(data, signature); err != nil {
+		t.Fatalf("Error verifying agent signature: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credential
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// PassphraseEnvVar is the environment variable that holds the
+	// passphrase of every encrypted private key. The passphrase of one
+	// credential is read from the variable suffixed with the credential
+	// name, e.g. CCTL_SSH_PASSPHRASE_SSH_CREDENTIAL, first.
+	PassphraseEnvVar = "CCTL_SSH_PASSPHRASE"
+	// SudoPasswordEnvVar is the environment variable that holds the sudo
+	// password of every credential that needs one. As with
+	// PassphraseEnvVar, it may be suffixed with the credential name.
+	SudoPasswordEnvVar = "CCTL_SUDO_PASSWORD"
+)
+
+// Passphrases obtains the passphrases of encrypted private keys, or other
+// secrets of credentials, from the environment, or else from a prompt, and
+// keeps them in memory only. It is safe for concurrent use.
+type Passphrases struct {
+	// EnvVar is the environment variable that holds the secret. Defaults
+	// to PassphraseEnvVar.
+	EnvVar string
+	// Description describes the secret in prompts and errors. Defaults to
+	// "passphrase".
+	Description string
+	// Prompt asks for the passphrase of the named credential. If nil, the
+	// passphrase is read from the terminal.
+	Prompt func(name string) ([]byte, error)
+	// Getenv defaults to os.Getenv.
+	Getenv func(key string) string
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// EnvVarFor returns the variable, derived from the environment variable,
+// that holds the secret of the named credential.
+func EnvVarFor(envVar, name string) string {
+	return envVar + "_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+func (p *Passphrases) envVar() string {
+	if len(p.EnvVar) == 0 {
+		return PassphraseEnvVar
+	}
+	return p.EnvVar
+}
+
+func (p *Passphrases) description() string {
+	if len(p.Description) == 0 {
+		return "passphrase"
+	}
+	return p.Description
+}
+
+// Get returns the passphrase of the named credential.
+func (p *Passphrases) Get(name string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if passphrase, ok := p.cache[name]; ok {
+		return passphrase, nil
+	}
+	getenv := p.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	var passphrase []byte
+	for _, envVar := range []string{EnvVarFor(p.envVar(), name), p.envVar()} {
+		if value := getenv(envVar); len(value) != 0 {
+			passphrase = []byte(value)
+			break
+		}
+	}
+	if passphrase == nil {
+		prompt := p.Prompt
+		if prompt == nil {
+			prompt = p.promptTerminal
+		}
+		var err error
+		if passphrase, err = prompt(name); err != nil {
+			return nil, err
+		}
+	}
+	if p.cache == nil {
+		p.cache = make(map[string][]byte)
+	}
+	p.cache[name] = passphrase
+	return passphrase, nil
+}
+
+// Forget removes the passphrase of the named credential from memory, e.g.
+// because it is wrong.
+func (p *Passphrases) Forget(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.cache, name)
+}
+
+func (p *Passphrases) promptTerminal(name string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("SSH credential %q needs a %s. Set %s or %s, or run cctl in a terminal to enter it", name, p.description(), EnvVarFor(p.envVar(), name), p.envVar())
+	}
+	fmt.Fprintf(os.Stderr, "Enter %s for SSH credential %q: ", p.description(), name)
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", p.description(), err)
+	}
+	return passphrase, nil
+}
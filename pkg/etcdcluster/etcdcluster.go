@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package etcdcluster reads and changes the membership of the etcd cluster of
+// the masters, through etcdctl on one of the masters.
+package etcdcluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	"github.com/platform9/ssh-provider/pkg/machine"
+)
+
+// EtcdctlPath is the etcdctl wrapper installed by etcdadm. It sets the
+// client certificates and the endpoint of the local member.
+const EtcdctlPath = "/opt/bin/etcdctl.sh"
+
+// Status is the etcd cluster as seen from one master.
+type Status struct {
+	Members []spv1.EtcdMember
+	// Healthy is keyed by member ID.
+	Healthy map[uint64]bool
+}
+
+// ReadMembers returns the members of the etcd cluster, as seen by the master.
+func ReadMembers(client machine.Client) ([]spv1.EtcdMember, error) {
+	cmd := fmt.Sprintf("%s member list --write-out=json", EtcdctlPath)
+	stdOut, stdErr, err := client.RunCommand(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error running %q: %v (%s) (%s)", cmd, err, string(stdOut), string(stdErr))
+	}
+	memberList := struct {
+		Members []spv1.EtcdMember `json:"members"`
+	}{}
+	if err := json.Unmarshal(stdOut, &memberList); err != nil {
+		return nil, fmt.Errorf("unable to read etcd member list: %v", err)
+	}
+	return memberList.Members, nil
+}
+
+// Read returns the members of the etcd cluster, and whether each one is
+// healthy.
+func Read(client machine.Client) (*Status, error) {
+	members, err := ReadMembers(client)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{
+		Members: members,
+		Healthy: make(map[uint64]bool),
+	}
+	for _, member := range status.Members {
+		status.Healthy[member.ID] = memberHealthy(client, member)
+	}
+	return status, nil
+}
+
+// memberHealthy returns true if the member answers on one of its client URLs.
+// A member that has not started has no client URLs.
+func memberHealthy(client machine.Client, member spv1.EtcdMember) bool {
+	for _, url := range member.ClientURLs {
+		if _, _, err := client.RunCommand(fmt.Sprintf("%s endpoint health --endpoints=%s", EtcdctlPath, url)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Member returns the member with the ID or, if the ID is zero, the name.
+func (s *Status) Member(id uint64, name string) (spv1.EtcdMember, bool) {
+	for _, member := range s.Members {
+		if (id != 0 && member.ID == id) || (id == 0 && member.Name == name) {
+			return member, true
+		}
+	}
+	return spv1.EtcdMember{}, false
+}
+
+// HealthyMembers returns the number of healthy members.
+func (s *Status) HealthyMembers() int {
+	healthy := 0
+	for _, member := range s.Members {
+		if s.Healthy[member.ID] {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// Quorum returns the number of members that must be healthy for a cluster of
+// the given size to make progress.
+func Quorum(members int) int {
+	return members/2 + 1
+}
+
+// FaultTolerance returns the number of members of a cluster of the given size
+// that can fail while the cluster keeps its quorum.
+func FaultTolerance(members int) int {
+	if members == 0 {
+		return 0
+	}
+	return members - Quorum(members)
+}
+
+// Removal is the size of the etcd cluster before and after a member is
+// removed.
+type Removal struct {
+	Members      int
+	Healthy      int
+	MembersAfter int
+	HealthyAfter int
+}
+
+// QuorumAfterRemoval returns the size of the cluster before and after the
+// member is removed. A nil member, or one that is not in the cluster, changes
+// nothing. If force is set, the member is stopped, but stays in the member
+// list, so it no longer counts as healthy, but still counts towards quorum.
+func QuorumAfterRemoval(status *Status, member *spv1.EtcdMember, force bool) Removal {
+	r := Removal{Members: len(status.Members), Healthy: status.HealthyMembers()}
+	r.MembersAfter, r.HealthyAfter = r.Members, r.Healthy
+	if member == nil {
+		return r
+	}
+	m, ok := status.Member(member.ID, member.Name)
+	if !ok {
+		return r
+	}
+	if status.Healthy[m.ID] {
+		r.HealthyAfter--
+	}
+	if !force {
+		r.MembersAfter--
+	}
+	return r
+}
+
+// HasQuorum returns true if the cluster has quorum before the removal.
+func (r Removal) HasQuorum() bool {
+	return r.Healthy >= Quorum(r.Members)
+}
+
+// KeepsQuorum returns true if the cluster has quorum after the removal.
+func (r Removal) KeepsQuorum() bool {
+	return r.HealthyAfter >= Quorum(r.MembersAfter)
+}
+
+// RemoveMember removes the member from the cluster.
+func RemoveMember(client machine.Client, id uint64) error {
+	// etcdctl expects the ID in hex
+	cmd := fmt.Sprintf("%s member remove %s", EtcdctlPath, strconv.FormatUint(id, 16))
+	stdOut, stdErr, err := client.RunCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("error running %q: %v (%s) (%s)", cmd, err, string(stdOut), string(stdErr))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcdcluster_test
+
+import (
+	"testing"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+
+	"github.com/platform9/cctl/pkg/etcdcluster"
+	"github.com/platform9/cctl/pkg/machineclient/fake"
+)
+
+func TestRead(t *testing.T) {
+	client := &fake.Client{Commands: map[string]string{
+		"/opt/bin/etcdctl.sh member list --write-out=json": `{"header":{"cluster_id":7},"members":[` +
+			`{"ID":1,"name":"a","peerURLs":["https://10.0.0.1:2380"],"clientURLs":["https://10.0.0.1:2379"]},` +
+			`{"ID":2,"name":"b","peerURLs":["https://10.0.0.2:2380"],"clientURLs":["https://10.0.0.2:2379"]},` +
+			`{"ID":3,"name":"c","peerURLs":["https://10.0.0.3:2380"],"clientURLs":["https://10.0.0.3:2379"]}]}`,
+		"/opt/bin/etcdctl.sh endpoint health --endpoints=https://10.0.0.1:2379": "healthy",
+		"/opt/bin/etcdctl.sh endpoint health --endpoints=https://10.0.0.3:2379": "healthy",
+	}}
+	status, err := etcdcluster.Read(client)
+	if err != nil {
+		t.Fatalf("Error reading etcd status: %v", err)
+	}
+	if len(status.Members) != 3 || status.HealthyMembers() != 2 {
+		t.Fatalf("Expected 3 members, 2 healthy, found %d and %d", len(status.Members), status.HealthyMembers())
+	}
+	if status.Healthy[2] {
+		t.Errorf("Expected member 2 to be unhealthy")
+	}
+	if member, ok := status.Member(0, "c"); !ok || member.ID != 3 {
+		t.Errorf("Expected to find member c by name, found %v", member)
+	}
+	if _, ok := status.Member(4, "c"); ok {
+		t.Errorf("Expected not to find member 4")
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	for _, tc := range []struct {
+		members, quorum, faultTolerance int
+	}{
+		{0, 1, 0},
+		{1, 1, 0},
+		{2, 2, 0},
+		{3, 2, 1},
+		{4, 3, 1},
+		{5, 3, 2},
+	} {
+		if quorum := etcdcluster.Quorum(tc.members); quorum != tc.quorum {
+			t.Errorf("%d members: expected quorum %d, found %d", tc.members, tc.quorum, quorum)
+		}
+		if faultTolerance := etcdcluster.FaultTolerance(tc.members); faultTolerance != tc.faultTolerance {
+			t.Errorf("%d members: expected fault tolerance %d, found %d", tc.members, tc.faultTolerance, faultTolerance)
+		}
+	}
+}
+
+func TestQuorumAfterRemoval(t *testing.T) {
+	// Member 3 is down.
+	status := &etcdcluster.Status{
+		Members: []spv1.EtcdMember{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}, {ID: 3, Name: "c"}},
+		Healthy: map[uint64]bool{1: true, 2: true},
+	}
+	for _, tc := range []struct {
+		name        string
+		member      *spv1.EtcdMember
+		force       bool
+		expected    etcdcluster.Removal
+		keepsQuorum bool
+	}{
+		{"healthy member", &spv1.EtcdMember{ID: 1}, false, etcdcluster.Removal{Members: 3, Healthy: 2, MembersAfter: 2, HealthyAfter: 1}, false},
+		{"down member", &spv1.EtcdMember{ID: 3}, false, etcdcluster.Removal{Members: 3, Healthy: 2, MembersAfter: 2, HealthyAfter: 2}, true},
+		{"member by name", &spv1.EtcdMember{Name: "c"}, false, etcdcluster.Removal{Members: 3, Healthy: 2, MembersAfter: 2, HealthyAfter: 2}, true},
+		{"force keeps healthy member", &spv1.EtcdMember{ID: 1}, true, etcdcluster.Removal{Members: 3, Healthy: 2, MembersAfter: 3, HealthyAfter: 1}, false},
+		{"force keeps down member", &spv1.EtcdMember{ID: 3}, true, etcdcluster.Removal{Members: 3, Healthy: 2, MembersAfter: 3, HealthyAfter: 2}, true},
+		{"no recorded member", nil, false, etcdcluster.Removal{Members: 3, Healthy: 2, MembersAfter: 3, HealthyAfter: 2}, true},
+		{"member not in cluster", &spv1.EtcdMember{ID: 4}, false, etcdcluster.Removal{Members: 3, Healthy: 2, MembersAfter: 3, HealthyAfter: 2}, true},
+	} {
+		r := etcdcluster.QuorumAfterRemoval(status, tc.member, tc.force)
+		if r != tc.expected {
+			t.Errorf("%s: expected %+v, found %+v", tc.name, tc.expected, r)
+		}
+		if !r.HasQuorum() {
+			t.Errorf("%s: expected the cluster to have quorum before the removal", tc.name)
+		}
+		if r.KeepsQuorum() != tc.keepsQuorum {
+			t.Errorf("%s: expected keeps quorum %v, found %v", tc.name, tc.keepsQuorum, r.KeepsQuorum())
+		}
+	}
+
+	noQuorum := &etcdcluster.Status{
+		Members: []spv1.EtcdMember{{ID: 1}, {ID: 2}, {ID: 3}},
+		Healthy: map[uint64]bool{1: true},
+	}
+	if r := etcdcluster.QuorumAfterRemoval(noQuorum, &spv1.EtcdMember{ID: 2}, false); r.HasQuorum() {
+		t.Errorf("Expected a cluster with 1 of 3 members healthy to have no quorum, found %+v", r)
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	client := &fake.Client{Commands: map[string]string{
+		"/opt/bin/etcdctl.sh member remove ff": "Member ff removed",
+	}}
+	if err := etcdcluster.RemoveMember(client, 255); err != nil {
+		t.Fatalf("Error removing member: %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inventory reads the inventory file that lists the machines to create
+// in a cluster.
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/machineclient"
+)
+
+const (
+	RoleMaster = "master"
+	RoleNode   = "node"
+)
+
+// Inventory lists the machines to create.
+type Inventory struct {
+	Machines []Machine `json:"machines"`
+}
+
+// Machine describes one machine to create.
+type Machine struct {
+	// IP is the IP of the machine.
+	IP string `json:"ip"`
+	// Port is the SSH port of the machine. Defaults to common.DefaultSSHPort.
+	// +optional
+	Port int `json:"port,omitempty"`
+	// Role is the role of the machine, master or node.
+	Role string `json:"role"`
+	// Iface is the interface that keepalived binds to on a master. Defaults
+	// to eth0.
+	// +optional
+	Iface string `json:"iface,omitempty"`
+	// PublicKeys are files with the SSH public keys of the machine.
+	// +optional
+	PublicKeys []string `json:"publicKeys,omitempty"`
+	// Labels are applied to the node of the machine.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Taints are applied to the node of the machine, in the form
+	// key[=value]:effect.
+	// +optional
+	Taints []string `json:"taints,omitempty"`
+	// KubeletConfigFile is a file with kubelet configuration that overrides
+	// the kubelet configuration of the cluster on the machine.
+	// +optional
+	KubeletConfigFile string `json:"kubeletConfigFile,omitempty"`
+	// Credential is the name of the SSH credential used to reach the
+	// machine. Defaults to common.DefaultSSHCredentialSecretName.
+	// +optional
+	Credential string `json:"credential,omitempty"`
+	// JumpHosts are the hosts through which the machine is reached, in
+	// order. Defaults to the jump hosts of the credential or cluster.
+	// +optional
+	JumpHosts []machineclient.JumpHost `json:"jumpHosts,omitempty"`
+}
+
+// Load reads the inventory file, sets defaults, and validates it.
+func Load(filename string) (*Inventory, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory %q: %v", filename, err)
+	}
+	inv := Inventory{}
+	if err := yaml.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("unable to decode inventory %q: %v", filename, err)
+	}
+	inv.SetDefaults()
+	if err := inv.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid inventory %q: %v", filename, err)
+	}
+	return &inv, nil
+}
+
+// SetDefaults sets the defaults of unset fields.
+func (inv *Inventory) SetDefaults() {
+	for i := range inv.Machines {
+		m := &inv.Machines[i]
+		m.Role = strings.ToLower(m.Role)
+		if m.Port == 0 {
+			m.Port = common.DefaultSSHPort
+		}
+		if len(m.Iface) == 0 {
+			m.Iface = "eth0"
+		}
+		if len(m.Credential) == 0 {
+			m.Credential = common.DefaultSSHCredentialSecretName
+		}
+		machineclient.SetJumpHostDefaults(m.JumpHosts)
+	}
+}
+
+// Validate returns an error describing every invalid machine.
+func (inv *Inventory) Validate() error {
+	if len(inv.Machines) == 0 {
+		return fmt.Errorf("no machines listed")
+	}
+	errs := []string{}
+	seen := make(map[string]bool)
+	for i, m := range inv.Machines {
+		prefix := fmt.Sprintf("machines[%d]", i)
+		if net.ParseIP(m.IP) == nil {
+			errs = append(errs, fmt.Sprintf("%s: ip %q is not a valid IP", prefix, m.IP))
+		} else if seen[m.IP] {
+			errs = append(errs, fmt.Sprintf("%s: ip %q is listed more than once", prefix, m.IP))
+		}
+		seen[m.IP] = true
+		if m.Role != RoleMaster && m.Role != RoleNode {
+			errs = append(errs, fmt.Sprintf("%s: role %q must be %q or %q", prefix, m.Role, RoleMaster, RoleNode))
+		}
+		if m.Port < 1 || m.Port > 65535 {
+			errs = append(errs, fmt.Sprintf("%s: port %d must be between 1 and 65535", prefix, m.Port))
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(m.Credential) {
+			errs = append(errs, fmt.Sprintf("%s: credential %q: %s", prefix, m.Credential, msg))
+		}
+		if err := machineclient.ValidateJumpHosts(m.JumpHosts); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", prefix, err))
+		}
+		for _, msg := range ValidateLabels(m.Labels) {
+			errs = append(errs, fmt.Sprintf("%s: %s", prefix, msg))
+		}
+		if _, err := ParseTaints(m.Taints); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", prefix, err))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ValidateLabels returns a message for every invalid label key and value.
+func ValidateLabels(labels map[string]string) []string {
+	msgs := []string{}
+	for k, v := range labels {
+		for _, msg := range validation.IsQualifiedName(k) {
+			msgs = append(msgs, fmt.Sprintf("label key %q: %s", k, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(v) {
+			msgs = append(msgs, fmt.Sprintf("label value %q: %s", v, msg))
+		}
+	}
+	return msgs
+}
+
+// ParseLabels parses labels in the form key=value, and validates them.
+func ParseLabels(specs []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("label %q must be in the form key=value", spec)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	if msgs := ValidateLabels(labels); len(msgs) != 0 {
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	return labels, nil
+}
+
+// ParseTaints parses taints in the form key[=value]:effect, and validates
+// them.
+func ParseTaints(specs []string) ([]corev1.Taint, error) {
+	taints := []corev1.Taint{}
+	msgs := []string{}
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, ":")
+		if i == -1 {
+			msgs = append(msgs, fmt.Sprintf("taint %q must be in the form key[=value]:effect", spec))
+			continue
+		}
+		taint := corev1.Taint{Effect: corev1.TaintEffect(spec[i+1:])}
+		parts := strings.SplitN(spec[:i], "=", 2)
+		taint.Key = parts[0]
+		if len(parts) == 2 {
+			taint.Value = parts[1]
+		}
+		for _, msg := range validation.IsQualifiedName(taint.Key) {
+			msgs = append(msgs, fmt.Sprintf("taint key %q: %s", taint.Key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(taint.Value) {
+			msgs = append(msgs, fmt.Sprintf("taint value %q: %s", taint.Value, msg))
+		}
+		switch taint.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			msgs = append(msgs, fmt.Sprintf("taint effect %q must be %q, %q or %q", taint.Effect, corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute))
+		}
+		taints = append(taints, taint)
+	}
+	if len(msgs) != 0 {
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	return taints, nil
+}
+
+// Masters returns the machines with the master role, in inventory order.
+func (inv *Inventory) Masters() []Machine {
+	return inv.withRole(RoleMaster)
+}
+
+// Nodes returns the machines with the node role, in inventory order.
+func (inv *Inventory) Nodes() []Machine {
+	return inv.withRole(RoleNode)
+}
+
+func (inv *Inventory) withRole(role string) []Machine {
+	machines := []Machine{}
+	for _, m := range inv.Machines {
+		if m.Role == role {
+			machines = append(machines, m)
+		}
+	}
+	return machines
+}
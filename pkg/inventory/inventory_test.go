@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/inventory"
+)
+
+func writeInventory(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-inventory-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	filename := filepath.Join(dir, "inventory.yaml")
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing inventory: %v", err)
+	}
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestLoad(t *testing.T) {
+	filename, cleanup := writeInventory(t, `machines:
+- ip: 10.0.0.1
+  role: Master
+  iface: eth1
+- ip: 10.0.0.2
+  role: node
+  port: 2222
+  publicKeys: [/etc/ssh/ssh_host_rsa_key.pub]
+  labels:
+    topology.kubernetes.io/zone: a
+  taints: [dedicated=infra:NoSchedule]
+- ip: 10.0.0.3
+  role: node
+  credential: ubuntu-rack2
+`)
+	defer cleanup()
+	inv, err := inventory.Load(filename)
+	if err != nil {
+		t.Fatalf("Error loading inventory: %v", err)
+	}
+	masters, nodes := inv.Masters(), inv.Nodes()
+	if len(masters) != 1 || len(nodes) != 2 {
+		t.Fatalf("Expected 1 master and 2 nodes, found %d and %d", len(masters), len(nodes))
+	}
+	if masters[0].Iface != "eth1" || masters[0].Port != common.DefaultSSHPort {
+		t.Fatalf("Unexpected master %+v", masters[0])
+	}
+	if nodes[0].Port != 2222 || nodes[0].Labels["topology.kubernetes.io/zone"] != "a" {
+		t.Fatalf("Unexpected node %+v", nodes[0])
+	}
+	if nodes[1].Iface != "eth0" {
+		t.Fatalf("Expected default iface eth0, found %q", nodes[1].Iface)
+	}
+	if nodes[0].Credential != common.DefaultSSHCredentialSecretName || nodes[1].Credential != "ubuntu-rack2" {
+		t.Fatalf("Unexpected credentials %q and %q", nodes[0].Credential, nodes[1].Credential)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	filename, cleanup := writeInventory(t, `machines:
+- ip: 10.0.0.1
+  role: master
+- ip: 10.0.0.1
+  role: node
+  credential: Bad_Name
+- ip: not-an-ip
+  role: worker
+  labels:
+    bad key: v
+  taints: [dedicated=infra, gpu:NoRun]
+`)
+	defer cleanup()
+	_, err := inventory.Load(filename)
+	if err == nil {
+		t.Fatalf("Expected error loading invalid inventory")
+	}
+	for _, msg := range []string{"listed more than once", "not a valid IP", `role "worker"`, `label key "bad key"`, `credential "Bad_Name"`, `taint "dedicated=infra" must be`, `taint effect "NoRun"`} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected error to contain %q, found %q", msg, err)
+		}
+	}
+}
+
+func TestParseTaints(t *testing.T) {
+	taints, err := inventory.ParseTaints([]string{"dedicated=infra:NoSchedule", "storage:PreferNoSchedule"})
+	if err != nil {
+		t.Fatalf("Error parsing taints: %v", err)
+	}
+	if len(taints) != 2 {
+		t.Fatalf("Expected 2 taints, found %v", taints)
+	}
+	if taints[0].Key != "dedicated" || taints[0].Value != "infra" || taints[0].Effect != "NoSchedule" {
+		t.Errorf("Unexpected taint %+v", taints[0])
+	}
+	if taints[1].Key != "storage" || taints[1].Value != "" || taints[1].Effect != "PreferNoSchedule" {
+		t.Errorf("Unexpected taint %+v", taints[1])
+	}
+	if _, err := inventory.ParseLabels([]string{"role=infra", "novalue"}); err == nil || !strings.Contains(err.Error(), `label "novalue"`) {
+		t.Errorf("Expected error parsing label without value, found %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package machineclient runs commands and transfers files on machines over
+// SSH, optionally through a chain of jump hosts.
+package machineclient
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+)
+
+// Endpoint is a host that accepts SSH connections.
+type Endpoint struct {
+	Host     string
+	Port     int
+	Username string
+	// Auth are the methods used to authenticate Username.
+	Auth []ssh.AuthMethod
+	// PublicKeys are the host keys, in authorized_keys format, that the host
+	// may present.
+	PublicKeys []string
+	// KnownHosts lists the host keys, if PublicKeys is empty.
+	KnownHosts *KnownHosts
+	// InsecureIgnoreHostKey skips the check of the host key.
+	InsecureIgnoreHostKey bool
+	// Escalation is how commands gain root privileges on the host. It is
+	// not used for jump hosts.
+	Escalation Escalation
+}
+
+func (e Endpoint) address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+func (e Endpoint) clientConfig() (*ssh.ClientConfig, error) {
+	config := &ssh.ClientConfig{
+		User: e.Username,
+		Auth: e.Auth,
+	}
+	callback, err := e.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	config.HostKeyCallback = callback
+	return config, nil
+}
+
+type client struct {
+	sshClient  *ssh.Client
+	sftpClient *sftp.Client
+	username   string
+	escalation Escalation
+}
+
+// New returns a client of the target. If jump hosts are given, the
+// connection to the target is made through them, in order.
+func New(target Endpoint, jumpHosts []Endpoint) (sshmachine.Client, error) {
+	sshClient, err := dial(append(append([]Endpoint{}, jumpHosts...), target))
+	if err != nil {
+		return nil, err
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start SFTP session with %s: %v", target.address(), err)
+	}
+	return &client{
+		sshClient:  sshClient,
+		sftpClient: sftpClient,
+		username:   target.Username,
+		escalation: target.Escalation,
+	}, nil
+}
+
+// dial connects to the first endpoint, and then to each following endpoint
+// through the connection to the endpoint before it.
+func dial(endpoints []Endpoint) (*ssh.Client, error) {
+	var sshClient *ssh.Client
+	for i, e := range endpoints {
+		config, err := e.clientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure SSH to %s: %v", e.address(), err)
+		}
+		conn, err := connect(sshClient, e.address())
+		if err != nil {
+			return nil, err
+		}
+		clientConn, chans, reqs, err := ssh.NewClientConn(conn, e.address(), config)
+		if err != nil {
+			conn.Close()
+			if i == 0 {
+				return nil, fmt.Errorf("unable to dial %s: %s", e.address(), err)
+			}
+			return nil, fmt.Errorf("unable to dial %s through jump host %s: %s", e.address(), endpoints[i-1].address(), err)
+		}
+		sshClient = ssh.NewClient(clientConn, chans, reqs)
+	}
+	return sshClient, nil
+}
+
+// connect opens a TCP connection to the address, through the SSH client if
+// one is given.
+func connect(via *ssh.Client, address string) (net.Conn, error) {
+	if via == nil {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dial %s: %s", address, err)
+		}
+		return conn, nil
+	}
+	conn, err := via.Dial("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial %s through jump host %s: %s", address, via.RemoteAddr(), err)
+	}
+	return conn, nil
+}
+
+// RunCommand runs a command on the machine with root privileges, and returns
+// stdout and stderr separately
+func (c *client) RunCommand(cmd string) ([]byte, []byte, error) {
+	if !c.escalates() {
+		return c.run(cmd, nil)
+	}
+	command, stdin, err := c.escalation.command(cmd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to escalate privileges: %v", err)
+	}
+	stdOut, stdErr, err := c.run(command, stdin)
+	if err != nil && c.escalation.Method == EscalationSudoPassword && bytes.Contains(stdErr, []byte("incorrect password")) {
+		return stdOut, stdErr, fmt.Errorf("command failed: sudo rejected the password of user %q", c.username)
+	}
+	return stdOut, stdErr, err
+}
+
+// CommandError is returned when a command runs, but exits with a non-zero
+// status.
+type CommandError struct {
+	ExitStatus int
+	Err        error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command failed: %s", e.Err)
+}
+
+// run runs the command as the login user.
+func (c *client) run(cmd string, stdin io.Reader) ([]byte, []byte, error) {
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create session: %s", err)
+	}
+	defer session.Close()
+	var stdOut, stdErr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = &stdOut
+	session.Stderr = &stdErr
+	if err := session.Run(cmd); err != nil {
+		switch err := err.(type) {
+		case *ssh.ExitError:
+			return stdOut.Bytes(), stdErr.Bytes(), &CommandError{ExitStatus: err.ExitStatus(), Err: err}
+		case *ssh.ExitMissingError:
+			return stdOut.Bytes(), stdErr.Bytes(), fmt.Errorf("command failed (no exit status): %s", err)
+		default:
+			return stdOut.Bytes(), stdErr.Bytes(), fmt.Errorf("command failed: %s", err)
+		}
+	}
+	return stdOut.Bytes(), stdErr.Bytes(), nil
+}
+
+// escalates returns true if commands must gain root privileges, because the
+// login user is not root. File transfers are then done with commands, because
+// SFTP runs as the login user.
+func (c *client) escalates() bool {
+	return c.username != "root" && c.escalation.Method != EscalationNone
+}
+
+// WriteFile writes a file to the machine
+func (c *client) WriteFile(path string, mode os.FileMode, b []byte) error {
+	if c.escalates() {
+		return c.writeFileWithEscalation(path, mode, b)
+	}
+	f, err := c.sftpClient.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create file: %s", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("write failed: %s", err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		return fmt.Errorf("chmod failed: %s", err)
+	}
+	return nil
+}
+
+// writeFileWithEscalation writes the file to a temporary file owned by the
+// login user, and then copies it into place with root privileges.
+func (c *client) writeFileWithEscalation(path string, mode os.FileMode, b []byte) error {
+	stdOut, stdErr, err := c.run("mktemp", nil)
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %s (%s)", err, stdErr)
+	}
+	tmpPath := strings.TrimSpace(string(stdOut))
+	defer c.run("rm -f "+ShellQuote(tmpPath), nil)
+	f, err := c.sftpClient.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("unable to create file: %s", err)
+	}
+	_, err = f.Write(b)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("write failed: %s", err)
+	}
+	// chmod expects the mode in octal
+	cmd := fmt.Sprintf("cp -f %s %s && chmod %s %s", ShellQuote(tmpPath), ShellQuote(path), strconv.FormatUint(uint64(mode.Perm()), 8), ShellQuote(path))
+	if _, stdErr, err := c.RunCommand(cmd); err != nil {
+		return fmt.Errorf("unable to write file %q: %s (%s)", path, err, stdErr)
+	}
+	return nil
+}
+
+// ReadFile reads a file from the machine
+func (c *client) ReadFile(path string) ([]byte, error) {
+	if c.escalates() {
+		stdOut, stdErr, err := c.RunCommand("cat " + ShellQuote(path))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file %q: %s (%s)", path, err, stdErr)
+		}
+		return stdOut, nil
+	}
+	f, err := c.sftpClient.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file: %s", err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("read failed: %s", err)
+	}
+	return b, nil
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents,
+// and sets its permissions.
+func (c *client) MkdirAll(path string, mode os.FileMode) error {
+	if _, _, err := c.RunCommand(fmt.Sprintf("mkdir -p %s", path)); err != nil {
+		return fmt.Errorf("unable to create directory %q: %s", path, err)
+	}
+	// chmod expects the mode in octal
+	if _, _, err := c.RunCommand(fmt.Sprintf("chmod %s %s", strconv.FormatUint(uint64(mode), 8), path)); err != nil {
+		return fmt.Errorf("unable to set permissions to directory %q: %s", path, err)
+	}
+	return nil
+}
+
+// MoveFile moves the file at srcFilePath to dstFilePath
+func (c *client) MoveFile(srcFilePath, dstFilePath string) error {
+	if _, _, err := c.RunCommand(fmt.Sprintf("mv -f %s %s", srcFilePath, dstFilePath)); err != nil {
+		return fmt.Errorf("unable to move file from %q to %q: %s", srcFilePath, dstFilePath, err)
+	}
+	return nil
+}
+
+// CopyFile copies the file at srcFilePath to dstFilePath
+func (c *client) CopyFile(srcFilePath, dstFilePath string) error {
+	if _, _, err := c.RunCommand(fmt.Sprintf("cp -f %s %s", srcFilePath, dstFilePath)); err != nil {
+		return fmt.Errorf("unable to copy file from %q to %q: %s", srcFilePath, dstFilePath, err)
+	}
+	return nil
+}
+
+// Exists checks if the path exists
+func (c *client) Exists(path string) (bool, error) {
+	stdOut, _, err := c.RunCommand(fmt.Sprintf("test -e %s && echo true || echo false", path))
+	if err != nil {
+		return false, fmt.Errorf("unable to check if path %q exists: %s", path, err)
+	}
+	return strings.TrimSpace(string(stdOut)) == "true", nil
+}
+
+// RemoveFile removes the file at path
+func (c *client) RemoveFile(path string) error {
+	if _, _, err := c.RunCommand(fmt.Sprintf("rm -f %s", path)); err != nil {
+		return fmt.Errorf("unable to remove file %q: %s", path, err)
+	}
+	return nil
+}
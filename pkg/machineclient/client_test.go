@@ -0,0 +1,348 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machineclient_test
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/platform9/cctl/pkg/machineclient"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Error creating signer: %v", err)
+	}
+	return signer
+}
+
+// startServer starts an SSH server that accepts the user's key, forwards
+// direct-tcpip channels, serves SFTP from memory, and answers every command with the name of the
+// server and the command.
+func startServer(t *testing.T, name string, userKey ssh.PublicKey) (net.Listener, ssh.PublicKey) {
+	hostSigner := newSigner(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(userKey.Marshal()) {
+				return nil, fmt.Errorf("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	// Files are kept in memory, and shared by all connections to the server
+	handlers := sftp.InMemHandler()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, config, name, handlers)
+		}
+	}()
+	return listener, hostSigner.PublicKey()
+}
+
+func serve(conn net.Conn, config *ssh.ServerConfig, name string, handlers sftp.Handlers) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "direct-tcpip":
+			var payload struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, _ := newChannel.Accept()
+			go ssh.DiscardRequests(requests)
+			go func() { io.Copy(channel, target); channel.Close() }()
+			go func() { io.Copy(target, channel); target.Close() }()
+		case "session":
+			channel, requests, _ := newChannel.Accept()
+			go func() {
+				for req := range requests {
+					if req.Type == "subsystem" && string(req.Payload[4:]) == "sftp" {
+						req.Reply(true, nil)
+						go func() {
+							server := sftp.NewRequestServer(channel, handlers)
+							server.Serve()
+							server.Close()
+						}()
+						continue
+					}
+					if req.Type != "exec" {
+						req.Reply(false, nil)
+						continue
+					}
+					req.Reply(true, nil)
+					command := string(req.Payload[4:])
+					stdin, _ := ioutil.ReadAll(channel)
+					fmt.Fprintf(channel, "%s: %s", name, command)
+					if len(stdin) != 0 {
+						fmt.Fprintf(channel, " < %q", stdin)
+					}
+					// "exit N" exits with status N
+					exitStatus, _ := strconv.Atoi(strings.TrimPrefix(command, "exit "))
+					status := make([]byte, 4)
+					binary.BigEndian.PutUint32(status, uint32(exitStatus))
+					channel.SendRequest("exit-status", false, status)
+					channel.Close()
+				}
+			}()
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func endpoint(listener net.Listener, userSigner ssh.Signer, hostKey ssh.PublicKey) machineclient.Endpoint {
+	addr := listener.Addr().(*net.TCPAddr)
+	e := machineclient.Endpoint{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "root",
+	}
+	if userSigner != nil {
+		e.Auth = []ssh.AuthMethod{ssh.PublicKeys(userSigner)}
+	}
+	if hostKey != nil {
+		e.PublicKeys = []string{string(ssh.MarshalAuthorizedKey(hostKey))}
+	}
+	return e
+}
+
+func TestRunCommandThroughJumpHosts(t *testing.T) {
+	userSigner := newSigner(t)
+	target, targetKey := startServer(t, "target", userSigner.PublicKey())
+	defer target.Close()
+	jump1, jump1Key := startServer(t, "jump1", userSigner.PublicKey())
+	defer jump1.Close()
+	jump2, jump2Key := startServer(t, "jump2", userSigner.PublicKey())
+	defer jump2.Close()
+
+	client, err := machineclient.New(endpoint(target, userSigner, targetKey), []machineclient.Endpoint{
+		endpoint(jump1, userSigner, jump1Key),
+		endpoint(jump2, userSigner, jump2Key),
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	stdOut, _, err := client.RunCommand("hostname")
+	if err != nil {
+		t.Fatalf("Error running command: %v", err)
+	}
+	if string(stdOut) != "target: hostname" {
+		t.Fatalf("Expected the command to run on the target as root, without sudo, found %q", stdOut)
+	}
+	if err := client.WriteFile("/config", 0600, []byte("data")); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	data, err := client.ReadFile("/config")
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("Expected to read %q, found %q", "data", data)
+	}
+}
+
+func TestRunCommandEscalation(t *testing.T) {
+	userSigner := newSigner(t)
+	target, targetKey := startServer(t, "target", userSigner.PublicKey())
+	defer target.Close()
+
+	for _, tc := range []struct {
+		escalation machineclient.Escalation
+		expected   string
+	}{
+		{machineclient.Escalation{Method: machineclient.EscalationNone}, `target: echo 'a' | tee /b`},
+		{machineclient.Escalation{Method: machineclient.EscalationSudo}, `target: sudo -n -- sh -c 'echo '"'"'a'"'"' | tee /b'`},
+		{machineclient.Escalation{
+			Method:   machineclient.EscalationSudoPassword,
+			Password: func() ([]byte, error) { return []byte("secret"), nil },
+		}, `target: sudo -k -S -p '' -- sh -c 'echo '"'"'a'"'"' | tee /b' < "secret\n"`},
+	} {
+		e := endpoint(target, userSigner, targetKey)
+		e.Username = "deploy"
+		e.Escalation = tc.escalation
+		client, err := machineclient.New(e, nil)
+		if err != nil {
+			t.Fatalf("Error creating client: %v", err)
+		}
+		stdOut, _, err := client.RunCommand("echo 'a' | tee /b")
+		if err != nil {
+			t.Fatalf("%s: error running command: %v", tc.escalation.Method, err)
+		}
+		if string(stdOut) != tc.expected {
+			t.Errorf("%s: expected %q, found %q", tc.escalation.Method, tc.expected, stdOut)
+		}
+	}
+}
+
+func TestRunCommandExitStatus(t *testing.T) {
+	userSigner := newSigner(t)
+	target, targetKey := startServer(t, "target", userSigner.PublicKey())
+	defer target.Close()
+
+	e := endpoint(target, userSigner, targetKey)
+	e.Escalation = machineclient.Escalation{Method: machineclient.EscalationNone}
+	client, err := machineclient.New(e, nil)
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	_, _, err = client.RunCommand("exit 3")
+	commandErr, ok := err.(*machineclient.CommandError)
+	if !ok {
+		t.Fatalf("Expected a command error, found %v", err)
+	}
+	if commandErr.ExitStatus != 3 {
+		t.Fatalf("Expected exit status 3, found %d", commandErr.ExitStatus)
+	}
+}
+
+func TestNewRejectsUnknownJumpHostKey(t *testing.T) {
+	userSigner := newSigner(t)
+	target, targetKey := startServer(t, "target", userSigner.PublicKey())
+	defer target.Close()
+	jump, jumpKey := startServer(t, "jump", userSigner.PublicKey())
+	defer jump.Close()
+	other, otherKey := startServer(t, "other", userSigner.PublicKey())
+	other.Close()
+
+	_, err := machineclient.New(endpoint(target, userSigner, targetKey), []machineclient.Endpoint{
+		endpoint(jump, userSigner, otherKey),
+	})
+	if err == nil {
+		t.Fatalf("Expected error dialing a jump host with an unknown host key")
+	}
+	for _, msg := range []string{"host key verification failed", ssh.FingerprintSHA256(jumpKey), ssh.FingerprintSHA256(otherKey)} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected error to contain %q, found %q", msg, err)
+		}
+	}
+}
+
+func TestScanHostKeys(t *testing.T) {
+	userSigner := newSigner(t)
+	target, targetKey := startServer(t, "target", userSigner.PublicKey())
+	defer target.Close()
+	jump, jumpKey := startServer(t, "jump", userSigner.PublicKey())
+	defer jump.Close()
+
+	scanTarget := endpoint(target, nil, nil)
+	keys, err := machineclient.ScanHostKeys(scanTarget, []machineclient.Endpoint{
+		endpoint(jump, userSigner, jumpKey),
+	})
+	if err != nil {
+		t.Fatalf("Error scanning host keys: %v", err)
+	}
+	if len(keys) != 1 || string(keys[0].Marshal()) != string(targetKey.Marshal()) {
+		t.Fatalf("Expected the target host key, found %v", keys)
+	}
+}
+
+func TestNewWithKnownHosts(t *testing.T) {
+	userSigner := newSigner(t)
+	target, targetKey := startServer(t, "target", userSigner.PublicKey())
+	defer target.Close()
+	addr := target.Addr().(*net.TCPAddr)
+	dir, err := ioutil.TempDir("/tmp", "cctl-known-hosts-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	fmt.Fprintf(mac, "[%s]:%d", addr.IP, addr.Port)
+	hashed := fmt.Sprintf("|1|%s|%s", base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	otherKey := newSigner(t).PublicKey()
+
+	for _, tc := range []struct {
+		name    string
+		line    string
+		key     ssh.PublicKey
+		wantErr string
+	}{
+		{"listed", fmt.Sprintf("[%s]:%d", addr.IP, addr.Port), targetKey, ""},
+		{"hashed", hashed, targetKey, ""},
+		{"wildcard", fmt.Sprintf("[127.0.0.*]:%d,!other", addr.Port), targetKey, ""},
+		{"negated", fmt.Sprintf("[127.0.0.*]:%d,![%s]:%d", addr.Port, addr.IP, addr.Port), targetKey, "lists no keys"},
+		{"other key", fmt.Sprintf("[%s]:%d", addr.IP, addr.Port), otherKey, "does not match"},
+		{"not listed", "other.example.com", targetKey, "lists no keys"},
+		{"revoked", fmt.Sprintf("@revoked [%s]:%d", addr.IP, addr.Port), targetKey, "revoked"},
+	} {
+		filename := filepath.Join(dir, "known_hosts")
+		content := "# comment\n" + tc.line + " " + string(ssh.MarshalAuthorizedKey(tc.key))
+		if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatalf("Error writing known_hosts: %v", err)
+		}
+		knownHosts, err := machineclient.LoadKnownHosts(filename)
+		if err != nil {
+			t.Fatalf("%s: error loading known_hosts: %v", tc.name, err)
+		}
+		e := endpoint(target, userSigner, nil)
+		e.KnownHosts = knownHosts
+		_, err = machineclient.New(e, nil)
+		switch {
+		case len(tc.wantErr) == 0 && err != nil:
+			t.Errorf("%s: error creating client: %v", tc.name, err)
+		case len(tc.wantErr) != 0 && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: expected error containing %q, found %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
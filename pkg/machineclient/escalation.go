@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machineclient
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Methods of privilege escalation.
+const (
+	// EscalationNone runs commands as the login user.
+	EscalationNone = "none"
+	// EscalationSudo runs commands with sudo, which must not ask for a
+	// password.
+	EscalationSudo = "sudo"
+	// EscalationSudoPassword runs commands with sudo, and gives it the
+	// password of the login user.
+	EscalationSudoPassword = "sudo-password"
+)
+
+// Escalation is how commands and file transfers gain root privileges.
+type Escalation struct {
+	// Method is one of EscalationNone, EscalationSudo, or
+	// EscalationSudoPassword. Defaults to EscalationSudo.
+	Method string
+	// Password returns the sudo password, if Method is
+	// EscalationSudoPassword.
+	Password func() ([]byte, error)
+}
+
+// ValidateEscalationMethod returns an error if the method is unknown.
+func ValidateEscalationMethod(method string) error {
+	switch method {
+	case EscalationNone, EscalationSudo, EscalationSudoPassword:
+		return nil
+	}
+	return fmt.Errorf("privilege escalation %q must be %q, %q or %q", method, EscalationNone, EscalationSudo, EscalationSudoPassword)
+}
+
+// command returns the command that runs cmd with root privileges, and the
+// input to give it. The whole command, including any pipes, runs as root.
+func (e Escalation) command(cmd string) (string, io.Reader, error) {
+	switch e.Method {
+	case EscalationNone:
+		return cmd, nil, nil
+	case EscalationSudoPassword:
+		if e.Password == nil {
+			return "", nil, fmt.Errorf("no sudo password available")
+		}
+		password, err := e.Password()
+		if err != nil {
+			return "", nil, err
+		}
+		// -k ignores cached credentials, so that sudo always reads the
+		// password, and never passes it on to the command.
+		return "sudo -k -S -p '' -- sh -c " + ShellQuote(cmd), bytes.NewReader(append(append([]byte{}, password...), '\n')), nil
+	default:
+		// -n fails instead of waiting for a password that is never given.
+		return "sudo -n -- sh -c " + ShellQuote(cmd), nil, nil
+	}
+}
+
+// ShellQuote quotes the string as one word for a POSIX shell.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake provides a machine client for tests, which returns canned
+// command output and file contents.
+package fake
+
+import (
+	"fmt"
+	"os"
+
+	sshmachine "github.com/platform9/ssh-provider/pkg/machine"
+)
+
+// Client returns canned command output and file contents. Commands that are
+// not listed fail, and files that are not listed do not exist. Calling a
+// method that changes the machine panics.
+type Client struct {
+	sshmachine.Client
+	// Commands maps a command to its standard output.
+	Commands map[string]string
+	// Files maps a path to its content.
+	Files map[string]string
+}
+
+// RunCommand returns the standard output of the command.
+func (c *Client) RunCommand(cmd string) ([]byte, []byte, error) {
+	out, ok := c.Commands[cmd]
+	if !ok {
+		return nil, []byte("command not found"), fmt.Errorf("exit status 127")
+	}
+	return []byte(out), nil, nil
+}
+
+// ReadFile returns the content of the file.
+func (c *Client) ReadFile(path string) ([]byte, error) {
+	content, ok := c.Files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+// Exists returns true if the file is listed.
+func (c *Client) Exists(path string) (bool, error) {
+	_, ok := c.Files[path]
+	return ok, nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machineclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMismatchError is returned when a host presents a key that is not
+// expected.
+type HostKeyMismatchError struct {
+	Address string
+	// Key is the key the host presented.
+	Key ssh.PublicKey
+	// Expected are the keys the host may present.
+	Expected []ssh.PublicKey
+	// Source describes where the expected keys come from.
+	Source string
+	// Revoked is true if the key is revoked.
+	Revoked bool
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	switch {
+	case e.Revoked:
+		return fmt.Sprintf("host key verification failed for %s: the host presented key %s, which is revoked in %s", e.Address, Fingerprint(e.Key), e.Source)
+	case len(e.Expected) == 0:
+		return fmt.Sprintf("host key verification failed for %s: the host presented key %s, but %s lists no keys for the host. Verify the key out of band, and add it to %s", e.Address, Fingerprint(e.Key), e.Source, e.Source)
+	default:
+		expected := make([]string, len(e.Expected))
+		for i, key := range e.Expected {
+			expected[i] = Fingerprint(key)
+		}
+		return fmt.Sprintf("host key verification failed for %s: the host presented key %s, which does not match the keys in %s (%s). The host may have been reinstalled, or the connection may be intercepted. Verify the new key out of band before trusting it", e.Address, Fingerprint(e.Key), e.Source, strings.Join(expected, ", "))
+	}
+}
+
+// Fingerprint returns the type and SHA256 fingerprint of the key, as printed
+// by ssh-keygen -l.
+func Fingerprint(key ssh.PublicKey) string {
+	return fmt.Sprintf("%s %s", key.Type(), ssh.FingerprintSHA256(key))
+}
+
+// hostKeyCallback checks the host key against the public keys of the
+// endpoint or, if it has none, against its known_hosts file.
+func (e Endpoint) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if e.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if len(e.PublicKeys) == 0 && e.KnownHosts != nil {
+		check := func(key ssh.PublicKey) error {
+			return e.KnownHosts.Check(e.Host, e.Port, key)
+		}
+		return checkHostKey(e.address(), fmt.Sprintf("known_hosts file %q", e.KnownHosts.Filename), check), nil
+	}
+	if len(e.PublicKeys) == 0 {
+		return nil, fmt.Errorf("no public keys of the host are known")
+	}
+	recorded := make([]knownhosts.KnownKey, len(e.PublicKeys))
+	for i, key := range e.PublicKeys {
+		parsedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse host public key: %v", err)
+		}
+		recorded[i] = knownhosts.KnownKey{Key: parsedKey}
+	}
+	check := func(key ssh.PublicKey) error {
+		for _, r := range recorded {
+			if bytes.Equal(r.Key.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		return &knownhosts.KeyError{Want: recorded}
+	}
+	return checkHostKey(e.address(), "the recorded public keys", check), nil
+}
+
+// checkHostKey returns a callback that checks the host key with check, which
+// returns the errors of golang.org/x/crypto/ssh/knownhosts, and describes a
+// mismatch with a *HostKeyMismatchError.
+func checkHostKey(address, source string, check func(key ssh.PublicKey) error) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		switch err := check(key).(type) {
+		case nil:
+			return nil
+		case *knownhosts.RevokedError:
+			return &HostKeyMismatchError{Address: address, Key: key, Source: source, Revoked: true}
+		case *knownhosts.KeyError:
+			expected := make([]ssh.PublicKey, len(err.Want))
+			for i, want := range err.Want {
+				expected[i] = want.Key
+			}
+			return &HostKeyMismatchError{Address: address, Key: key, Expected: expected, Source: source}
+		default:
+			return err
+		}
+	}
+}
+
+// scanKeyAlgorithms are the host key types requested when scanning, in the
+// order OpenSSH prefers them.
+var scanKeyAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA,
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+// scanTimeout bounds each handshake made while scanning, so that a host that
+// neither answers nor closes the connection does not block the scan.
+const scanTimeout = 10 * time.Second
+
+// ScanHostKeys returns the public keys that the target presents, one of each
+// type, like ssh-keyscan. The target is not authenticated to. If jump hosts
+// are given, the target is reached through them, and their keys are checked.
+func ScanHostKeys(target Endpoint, jumpHosts []Endpoint) ([]ssh.PublicKey, error) {
+	var via *ssh.Client
+	if len(jumpHosts) != 0 {
+		var err error
+		if via, err = dial(jumpHosts); err != nil {
+			return nil, err
+		}
+		defer via.Close()
+	}
+	keys := []ssh.PublicKey{}
+	var lastErr error
+	for _, algorithm := range scanKeyAlgorithms {
+		var key ssh.PublicKey
+		config := &ssh.ClientConfig{
+			User:              target.Username,
+			HostKeyAlgorithms: []string{algorithm},
+			HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+				key = k
+				return errHostKeyScanned
+			},
+		}
+		conn, err := connect(via, target.address())
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(scanTimeout))
+		// The handshake always fails, because the callback rejects the key
+		// once it has been captured. A host without a key of the requested
+		// type fails the handshake before the callback is called.
+		_, _, _, err = ssh.NewClientConn(conn, target.address(), config)
+		conn.Close()
+		if key != nil {
+			keys = append(keys, key)
+			continue
+		}
+		lastErr = err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unable to scan host keys of %s: %v", target.address(), lastErr)
+	}
+	return keys, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machineclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/platform9/cctl/common"
+)
+
+// JumpHostsAnnotationKey is the annotation that records the jump hosts of a
+// cluster, SSH credential, or provisioned machine.
+const JumpHostsAnnotationKey = "cctl.platform9.com/jump-hosts"
+
+// JumpHost is a host through which the SSH connection to a machine is made.
+type JumpHost struct {
+	// Host is the IP or hostname of the jump host.
+	Host string `json:"host"`
+	// Port is the SSH port of the jump host. Defaults to
+	// common.DefaultSSHPort.
+	// +optional
+	Port int `json:"port,omitempty"`
+	// Credential is the name of the SSH credential used to log in to the
+	// jump host.
+	Credential string `json:"credential"`
+	// PublicKeys are the host keys of the jump host, in authorized_keys
+	// format. If none are given, the host key is not checked.
+	// +optional
+	PublicKeys []string `json:"publicKeys,omitempty"`
+}
+
+// LoadJumpHosts reads a YAML list of jump hosts, in the order the connection
+// passes through them.
+func LoadJumpHosts(filename string) ([]JumpHost, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read jump hosts %q: %v", filename, err)
+	}
+	jumpHosts := []JumpHost{}
+	if err := yaml.Unmarshal(data, &jumpHosts); err != nil {
+		return nil, fmt.Errorf("unable to decode jump hosts %q: %v", filename, err)
+	}
+	SetJumpHostDefaults(jumpHosts)
+	if err := ValidateJumpHosts(jumpHosts); err != nil {
+		return nil, fmt.Errorf("invalid jump hosts %q: %v", filename, err)
+	}
+	return jumpHosts, nil
+}
+
+// SetJumpHostDefaults sets the defaults of unset fields.
+func SetJumpHostDefaults(jumpHosts []JumpHost) {
+	for i := range jumpHosts {
+		if jumpHosts[i].Port == 0 {
+			jumpHosts[i].Port = common.DefaultSSHPort
+		}
+	}
+}
+
+// ValidateJumpHosts returns an error describing every invalid jump host.
+func ValidateJumpHosts(jumpHosts []JumpHost) error {
+	errs := []string{}
+	for i, jh := range jumpHosts {
+		prefix := fmt.Sprintf("jumpHosts[%d]", i)
+		if net.ParseIP(jh.Host) == nil && len(validation.IsDNS1123Subdomain(jh.Host)) != 0 {
+			errs = append(errs, fmt.Sprintf("%s: host %q is not a valid IP or hostname", prefix, jh.Host))
+		}
+		if jh.Port < 1 || jh.Port > 65535 {
+			errs = append(errs, fmt.Sprintf("%s: port %d must be between 1 and 65535", prefix, jh.Port))
+		}
+		if len(jh.Credential) == 0 {
+			errs = append(errs, fmt.Sprintf("%s: credential is required", prefix))
+		}
+		for _, key := range jh.PublicKeys {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: public key %q: %v", prefix, key, err))
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// JumpHostsFromAnnotations returns the jump hosts recorded in the
+// annotations, or nil if none are recorded.
+func JumpHostsFromAnnotations(annotations map[string]string) ([]JumpHost, error) {
+	value, ok := annotations[JumpHostsAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	jumpHosts := []JumpHost{}
+	if err := json.Unmarshal([]byte(value), &jumpHosts); err != nil {
+		return nil, fmt.Errorf("unable to decode annotation %q: %v", JumpHostsAnnotationKey, err)
+	}
+	return jumpHosts, nil
+}
+
+// JumpHostsAnnotation returns the annotation value that records the jump
+// hosts.
+func JumpHostsAnnotation(jumpHosts []JumpHost) (string, error) {
+	value, err := json.Marshal(jumpHosts)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode jump hosts: %v", err)
+	}
+	return string(value), nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machineclient_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/machineclient"
+)
+
+const hostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+
+func writeJumpHosts(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-jumphost-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	filename := filepath.Join(dir, "jumphosts.yaml")
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing jump hosts: %v", err)
+	}
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadJumpHosts(t *testing.T) {
+	filename, cleanup := writeJumpHosts(t, `- host: bastion.example.com
+  credential: bastion
+  publicKeys:
+  - `+hostKey+`
+- host: 192.168.1.5
+  port: 2222
+  credential: rack2
+`)
+	defer cleanup()
+	jumpHosts, err := machineclient.LoadJumpHosts(filename)
+	if err != nil {
+		t.Fatalf("Error loading jump hosts: %v", err)
+	}
+	expected := []machineclient.JumpHost{
+		{Host: "bastion.example.com", Port: common.DefaultSSHPort, Credential: "bastion", PublicKeys: []string{hostKey}},
+		{Host: "192.168.1.5", Port: 2222, Credential: "rack2"},
+	}
+	if !reflect.DeepEqual(jumpHosts, expected) {
+		t.Fatalf("Expected %+v, found %+v", expected, jumpHosts)
+	}
+
+	value, err := machineclient.JumpHostsAnnotation(jumpHosts)
+	if err != nil {
+		t.Fatalf("Error encoding jump hosts: %v", err)
+	}
+	decoded, err := machineclient.JumpHostsFromAnnotations(map[string]string{machineclient.JumpHostsAnnotationKey: value})
+	if err != nil {
+		t.Fatalf("Error decoding jump hosts: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("Expected %+v, found %+v", expected, decoded)
+	}
+	if none, err := machineclient.JumpHostsFromAnnotations(nil); err != nil || none != nil {
+		t.Fatalf("Expected no jump hosts, found %+v (%v)", none, err)
+	}
+}
+
+func TestLoadJumpHostsInvalid(t *testing.T) {
+	filename, cleanup := writeJumpHosts(t, `- host: "bad host"
+  port: 70000
+  publicKeys: [not-a-key]
+`)
+	defer cleanup()
+	_, err := machineclient.LoadJumpHosts(filename)
+	if err == nil {
+		t.Fatalf("Expected error loading invalid jump hosts")
+	}
+	for _, msg := range []string{"not a valid IP or hostname", "port 70000", "credential is required", "public key"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected error to contain %q, found %q", msg, err)
+		}
+	}
+}
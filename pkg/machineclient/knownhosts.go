@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machineclient
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// KnownHosts is an OpenSSH known_hosts file.
+type KnownHosts struct {
+	Filename string
+	callback ssh.HostKeyCallback
+}
+
+// LoadKnownHosts reads an OpenSSH known_hosts file. Hashed host names,
+// wildcards, negated patterns and the @revoked marker are supported.
+func LoadKnownHosts(filename string) (*KnownHosts, error) {
+	callback, err := knownhosts.New(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known_hosts file %q: %v", filename, err)
+	}
+	return &KnownHosts{Filename: filename, callback: callback}, nil
+}
+
+// Check checks the key that the host presents. It returns a
+// *knownhosts.KeyError if the file does not list the key for the host, and a
+// *knownhosts.RevokedError if the key is revoked.
+func (kh *KnownHosts) Check(host string, port int, key ssh.PublicKey) error {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	return kh.callback(address, &net.TCPAddr{IP: net.ParseIP(host), Port: port}, key)
+}
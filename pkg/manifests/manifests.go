@@ -0,0 +1,194 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package manifests converts the objects in cctl state to Kubernetes
+// manifests that can be applied to a Cluster API management cluster.
+package manifests
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// RedactedAnnotationKey marks a secret whose data was removed.
+const RedactedAnnotationKey = "cctl.platform9.com/redacted"
+
+// serverFields are metadata fields set by the API server. They are removed
+// from manifests.
+var serverFields = []string{
+	"creationTimestamp",
+	"deletionTimestamp",
+	"deletionGracePeriodSeconds",
+	"generation",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// Objects are the objects of one cluster.
+type Objects struct {
+	Namespace           string
+	Clusters            []clusterv1.Cluster
+	Machines            []clusterv1.Machine
+	ProvisionedMachines []spv1.ProvisionedMachine
+	Secrets             []corev1.Secret
+}
+
+// Options control how manifests are generated.
+type Options struct {
+	// RedactSecrets removes the values of secret data.
+	RedactSecrets bool
+}
+
+// Manifest is the content of one manifest file.
+type Manifest struct {
+	Filename string
+	Content  []byte
+}
+
+// Generate returns one manifest for every object, and one for the namespace,
+// unless it is the default namespace. Manifests are sorted by filename.
+func Generate(objs Objects, opts Options) ([]Manifest, error) {
+	manifests := []Manifest{}
+	add := func(kind, name string, obj interface{}) error {
+		content, err := clean(obj)
+		if err != nil {
+			return fmt.Errorf("unable to generate manifest for %s %q: %v", kind, name, err)
+		}
+		manifests = append(manifests, Manifest{
+			Filename: fmt.Sprintf("%s-%s.yaml", strings.ToLower(kind), name),
+			Content:  content,
+		})
+		return nil
+	}
+
+	if objs.Namespace != metav1.NamespaceDefault {
+		ns := corev1.Namespace{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Namespace",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: objs.Namespace,
+			},
+		}
+		if err := add("Namespace", ns.Name, &ns); err != nil {
+			return nil, err
+		}
+	}
+	for i := range objs.Clusters {
+		cluster := objs.Clusters[i].DeepCopy()
+		cluster.TypeMeta = metav1.TypeMeta{Kind: "Cluster", APIVersion: "cluster.k8s.io/v1alpha1"}
+		if err := add(cluster.Kind, cluster.Name, cluster); err != nil {
+			return nil, err
+		}
+	}
+	for i := range objs.Machines {
+		machine := objs.Machines[i].DeepCopy()
+		machine.TypeMeta = metav1.TypeMeta{Kind: "Machine", APIVersion: "cluster.k8s.io/v1alpha1"}
+		// The instance status annotation holds the machine during an upgrade,
+		// and is otherwise empty
+		if len(machine.Annotations[sputil.InstanceStatusAnnotationKey]) == 0 {
+			delete(machine.Annotations, sputil.InstanceStatusAnnotationKey)
+		}
+		if err := add(machine.Kind, machine.Name, machine); err != nil {
+			return nil, err
+		}
+	}
+	for i := range objs.ProvisionedMachines {
+		pm := objs.ProvisionedMachines[i].DeepCopy()
+		pm.TypeMeta = metav1.TypeMeta{Kind: "ProvisionedMachine", APIVersion: "sshprovider.platform9.com/v1alpha1"}
+		if err := add(pm.Kind, pm.Name, pm); err != nil {
+			return nil, err
+		}
+	}
+	for i := range objs.Secrets {
+		secret := objs.Secrets[i].DeepCopy()
+		secret.TypeMeta = metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}
+		if opts.RedactSecrets {
+			for k := range secret.Data {
+				secret.Data[k] = []byte{}
+			}
+			if secret.Annotations == nil {
+				secret.Annotations = make(map[string]string)
+			}
+			secret.Annotations[RedactedAnnotationKey] = "true"
+		}
+		if err := add(secret.Kind, secret.Name, secret); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Filename < manifests[j].Filename })
+	return manifests, nil
+}
+
+// clean returns the object as YAML, without the fields set by the API server,
+// and without empty metadata and status.
+func clean(obj interface{}) ([]byte, error) {
+	objBytes, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal object to JSON: %v", err)
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(objBytes, &m); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal object from JSON: %v", err)
+	}
+	if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+		for _, field := range serverFields {
+			delete(metadata, field)
+		}
+		for _, field := range []string{"annotations", "labels"} {
+			if v, ok := metadata[field].(map[string]interface{}); ok && len(v) == 0 {
+				delete(metadata, field)
+			}
+		}
+	}
+	pruneNulls(m)
+	for _, field := range []string{"spec", "status"} {
+		if v, ok := m[field].(map[string]interface{}); ok && len(v) == 0 {
+			delete(m, field)
+		}
+	}
+	return yaml.Marshal(m)
+}
+
+// pruneNulls removes null values from the object. A null value is the same as
+// an unset value when the manifest is applied.
+func pruneNulls(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if e == nil {
+				delete(v, k)
+				continue
+			}
+			pruneNulls(e)
+		}
+	case []interface{}:
+		for _, e := range v {
+			pruneNulls(e)
+		}
+	}
+}
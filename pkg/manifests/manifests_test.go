@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifests_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"github.com/platform9/cctl/pkg/manifests"
+)
+
+func testObjects(t *testing.T) manifests.Objects {
+	cluster := clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "site-a",
+			Namespace:         "site-a",
+			CreationTimestamp: metav1.Now(),
+			ResourceVersion:   "7",
+			UID:               "0b5c1a2e",
+		},
+	}
+	clusterStatus := spv1.ClusterStatus{
+		EtcdMembers: []spv1.EtcdMember{{ID: 1, Name: "m1"}},
+	}
+	if err := sputil.PutClusterStatus(clusterStatus, &cluster); err != nil {
+		t.Fatalf("Error encoding cluster status: %v", err)
+	}
+	machine := clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "10.0.0.1",
+			Namespace:   "site-a",
+			Annotations: map[string]string{sputil.InstanceStatusAnnotationKey: ""},
+		},
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sshcredential",
+			Namespace: "site-a",
+		},
+		Data: map[string][]byte{"ssh-privatekey": []byte("private")},
+	}
+	return manifests.Objects{
+		Namespace: "site-a",
+		Clusters:  []clusterv1.Cluster{cluster},
+		Machines:  []clusterv1.Machine{machine},
+		Secrets:   []corev1.Secret{secret},
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	ms, err := manifests.Generate(testObjects(t), manifests.Options{})
+	if err != nil {
+		t.Fatalf("Error generating manifests: %v", err)
+	}
+	filenames := []string{}
+	for _, m := range ms {
+		filenames = append(filenames, m.Filename)
+	}
+	expected := "cluster-site-a.yaml machine-10.0.0.1.yaml namespace-site-a.yaml secret-sshcredential.yaml"
+	if strings.Join(filenames, " ") != expected {
+		t.Fatalf("Expected manifests %q, found %q", expected, strings.Join(filenames, " "))
+	}
+
+	cluster := make(map[string]interface{})
+	if err := yaml.Unmarshal(ms[0].Content, &cluster); err != nil {
+		t.Fatalf("Error unmarshalling cluster manifest: %v", err)
+	}
+	if cluster["kind"] != "Cluster" || cluster["apiVersion"] != "cluster.k8s.io/v1alpha1" {
+		t.Fatalf("Unexpected type in cluster manifest:\n%s", ms[0].Content)
+	}
+	metadata := cluster["metadata"].(map[string]interface{})
+	for _, field := range []string{"creationTimestamp", "resourceVersion", "uid"} {
+		if _, ok := metadata[field]; ok {
+			t.Fatalf("Expected %q to be removed from cluster manifest:\n%s", field, ms[0].Content)
+		}
+	}
+	if strings.Contains(string(ms[0].Content), "null") {
+		t.Fatalf("Expected null values to be removed from cluster manifest:\n%s", ms[0].Content)
+	}
+	if !strings.Contains(string(ms[0].Content), "etcdMembers") {
+		t.Fatalf("Expected provider status in cluster manifest:\n%s", ms[0].Content)
+	}
+	if strings.Contains(string(ms[1].Content), "annotations") {
+		t.Fatalf("Expected empty annotations to be removed from machine manifest:\n%s", ms[1].Content)
+	}
+	if !strings.Contains(string(ms[3].Content), "cHJpdmF0ZQ==") {
+		t.Fatalf("Expected secret data in secret manifest:\n%s", ms[3].Content)
+	}
+}
+
+func TestGenerateRedactSecrets(t *testing.T) {
+	objs := testObjects(t)
+	objs.Namespace = metav1.NamespaceDefault
+	ms, err := manifests.Generate(objs, manifests.Options{RedactSecrets: true})
+	if err != nil {
+		t.Fatalf("Error generating manifests: %v", err)
+	}
+	if len(ms) != 3 {
+		t.Fatalf("Expected 3 manifests without a namespace manifest, found %d", len(ms))
+	}
+	secret := corev1.Secret{}
+	if err := yaml.Unmarshal(ms[2].Content, &secret); err != nil {
+		t.Fatalf("Error unmarshalling secret manifest: %v", err)
+	}
+	if len(secret.Data["ssh-privatekey"]) != 0 {
+		t.Fatalf("Expected secret data to be redacted:\n%s", ms[2].Content)
+	}
+	if secret.Annotations[manifests.RedactedAnnotationKey] != "true" {
+		t.Fatalf("Expected redacted annotation:\n%s", ms[2].Content)
+	}
+	if len(objs.Secrets[0].Data["ssh-privatekey"]) == 0 {
+		t.Fatalf("Expected redaction to leave the state unchanged")
+	}
+}
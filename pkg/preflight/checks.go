@@ -0,0 +1,381 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preflight
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/platform9/ssh-provider/pkg/machine"
+
+	"github.com/platform9/cctl/common"
+)
+
+var (
+	// MasterPorts are the ports that must be free on a master.
+	MasterPorts = []int{6443, 2379, 2380, 10250}
+	// NodePorts are the ports that must be free on a node.
+	NodePorts = []int{10250}
+	// SupportedOSes are the values of ID in /etc/os-release of the
+	// operating systems that nodeadm supports.
+	SupportedOSes = []string{"ubuntu", "centos", "rhel"}
+)
+
+const (
+	// MinimumKernelVersion is the oldest kernel that runs Kubernetes.
+	MinimumKernelVersion = "3.10"
+	// DefaultCgroupDriver is the cgroup driver of the kubelet, unless the
+	// cluster configures another.
+	DefaultCgroupDriver = "cgroupfs"
+	// DefaultDiskPath is the path whose file system holds container images
+	// and etcd data.
+	DefaultDiskPath = "/var/lib"
+	// DefaultMinFreeDiskBytes is the free space below which provisioning
+	// fails.
+	DefaultMinFreeDiskBytes = 2 << 30
+	// DefaultRecommendedFreeDiskBytes is the free space below which a
+	// warning is given.
+	DefaultRecommendedFreeDiskBytes = 10 << 30
+	// DefaultMaxClockSkew is the largest difference between the clocks of
+	// the host and of cctl that does not fail the check.
+	DefaultMaxClockSkew = 30 * time.Second
+)
+
+func runCommand(client machine.Client, cmd string) (string, error) {
+	stdOut, stdErr, err := client.RunCommand(cmd)
+	if err != nil {
+		return "", fmt.Errorf("error running %q: %v (%s) (%s)", cmd, err, strings.TrimSpace(string(stdOut)), strings.TrimSpace(string(stdErr)))
+	}
+	return string(stdOut), nil
+}
+
+// OSCheck checks that the host runs a supported operating system and kernel.
+type OSCheck struct{}
+
+// Name implements Check.
+func (c OSCheck) Name() string { return "os" }
+
+// Run implements Check.
+func (c OSCheck) Run(client machine.Client) (Status, string) {
+	out, err := runCommand(client, "uname -sr")
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return StatusFail, fmt.Sprintf("unable to parse kernel from %q", strings.TrimSpace(out))
+	}
+	kernelName, kernelRelease := fields[0], fields[1]
+	if kernelName != "Linux" {
+		return StatusFail, fmt.Sprintf("kernel %q is not supported, must be %q", kernelName, "Linux")
+	}
+	if !kernelAtLeast(kernelRelease, MinimumKernelVersion) {
+		return StatusFail, fmt.Sprintf("kernel %s is older than %s", kernelRelease, MinimumKernelVersion)
+	}
+	osRelease, err := client.ReadFile("/etc/os-release")
+	if err != nil {
+		return StatusWarn, fmt.Sprintf("kernel %s, unable to read /etc/os-release: %v", kernelRelease, err)
+	}
+	id, versionID := parseOSRelease(string(osRelease))
+	for _, supported := range SupportedOSes {
+		if id == supported {
+			return StatusPass, fmt.Sprintf("%s %s, kernel %s", id, versionID, kernelRelease)
+		}
+	}
+	return StatusWarn, fmt.Sprintf("%s %s is not supported, kernel %s", id, versionID, kernelRelease)
+}
+
+func parseOSRelease(osRelease string) (string, string) {
+	var id, versionID string
+	for _, line := range strings.Split(osRelease, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.Trim(kv[1], `"'`)
+		switch kv[0] {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionID = value
+		}
+	}
+	return id, versionID
+}
+
+// kernelAtLeast returns true if the kernel release, e.g. 4.15.0-45-generic,
+// is at least the minimum major.minor version.
+func kernelAtLeast(release, minimum string) bool {
+	parse := func(v string) (int, int) {
+		parts := strings.SplitN(v, ".", 3)
+		if len(parts) < 2 {
+			return 0, 0
+		}
+		major, _ := strconv.Atoi(parts[0])
+		minor, _ := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+		return major, minor
+	}
+	major, minor := parse(release)
+	minMajor, minMinor := parse(minimum)
+	return major > minMajor || (major == minMajor && minor >= minMinor)
+}
+
+// CgroupDriverCheck checks that docker uses the cgroup driver of the kubelet.
+type CgroupDriverCheck struct {
+	Expected string
+}
+
+// Name implements Check.
+func (c CgroupDriverCheck) Name() string { return "cgroup-driver" }
+
+// Run implements Check.
+func (c CgroupDriverCheck) Run(client machine.Client) (Status, string) {
+	expected := c.Expected
+	if len(expected) == 0 {
+		expected = DefaultCgroupDriver
+	}
+	out, err := runCommand(client, "docker info --format '{{.CgroupDriver}}'")
+	if err != nil {
+		return StatusWarn, fmt.Sprintf("unable to read docker cgroup driver: %v", err)
+	}
+	driver := strings.TrimSpace(out)
+	if driver != expected {
+		return StatusFail, fmt.Sprintf("docker uses cgroup driver %q, kubelet uses %q", driver, expected)
+	}
+	return StatusPass, fmt.Sprintf("docker and kubelet use %q", driver)
+}
+
+// PortsCheck checks that no process listens on the ports.
+type PortsCheck struct {
+	Ports []int
+}
+
+// Name implements Check.
+func (c PortsCheck) Name() string { return "ports" }
+
+// Run implements Check.
+func (c PortsCheck) Run(client machine.Client) (Status, string) {
+	out, err := runCommand(client, "ss -ltn")
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	listening := make(map[int]bool)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		localAddress := fields[3]
+		port, err := strconv.Atoi(localAddress[strings.LastIndex(localAddress, ":")+1:])
+		if err != nil {
+			// The header line
+			continue
+		}
+		listening[port] = true
+	}
+	var inUse []string
+	for _, port := range c.Ports {
+		if listening[port] {
+			inUse = append(inUse, strconv.Itoa(port))
+		}
+	}
+	if len(inUse) != 0 {
+		return StatusFail, fmt.Sprintf("ports in use: %s", strings.Join(inUse, ", "))
+	}
+	return StatusPass, fmt.Sprintf("ports free: %s", joinPorts(c.Ports))
+}
+
+func joinPorts(ports []int) string {
+	s := make([]string, len(ports))
+	for i, port := range ports {
+		s[i] = strconv.Itoa(port)
+	}
+	return strings.Join(s, ", ")
+}
+
+// SwapCheck checks that swap is disabled, because the kubelet does not start
+// otherwise.
+type SwapCheck struct{}
+
+// Name implements Check.
+func (c SwapCheck) Name() string { return "swap" }
+
+// Run implements Check.
+func (c SwapCheck) Run(client machine.Client) (Status, string) {
+	swaps, err := client.ReadFile("/proc/swaps")
+	if err != nil {
+		return StatusFail, fmt.Sprintf("unable to read /proc/swaps: %v", err)
+	}
+	var devices []string
+	for i, line := range strings.Split(strings.TrimSpace(string(swaps)), "\n") {
+		fields := strings.Fields(line)
+		// The first line is the header
+		if i == 0 || len(fields) == 0 {
+			continue
+		}
+		devices = append(devices, fields[0])
+	}
+	if len(devices) != 0 {
+		return StatusFail, fmt.Sprintf("swap is enabled on %s", strings.Join(devices, ", "))
+	}
+	return StatusPass, "swap is disabled"
+}
+
+// DiskCheck checks the free space of the file system that holds the path.
+type DiskCheck struct {
+	Path                 string
+	MinFreeBytes         int64
+	RecommendedFreeBytes int64
+}
+
+// Name implements Check.
+func (c DiskCheck) Name() string { return "disk" }
+
+// Run implements Check.
+func (c DiskCheck) Run(client machine.Client) (Status, string) {
+	cmd := fmt.Sprintf("df -Pk %s", c.Path)
+	out, err := runCommand(client, cmd)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return StatusFail, fmt.Sprintf("unable to parse output of %q", cmd)
+	}
+	availableKB, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return StatusFail, fmt.Sprintf("unable to parse available space %q: %v", fields[3], err)
+	}
+	available := availableKB << 10
+	message := fmt.Sprintf("%s free on %s", formatBytes(available), c.Path)
+	switch {
+	case available < c.MinFreeBytes:
+		return StatusFail, fmt.Sprintf("%s, need at least %s", message, formatBytes(c.MinFreeBytes))
+	case available < c.RecommendedFreeBytes:
+		return StatusWarn, fmt.Sprintf("%s, recommend at least %s", message, formatBytes(c.RecommendedFreeBytes))
+	}
+	return StatusPass, message
+}
+
+func formatBytes(b int64) string {
+	return fmt.Sprintf("%.1fGiB", float64(b)/(1<<30))
+}
+
+// TimeSyncCheck checks that the clock of the host is close to the clock of
+// cctl, and is synchronized, because certificates and etcd depend on it.
+type TimeSyncCheck struct {
+	MaxSkew time.Duration
+	// Now returns the time to compare with. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Name implements Check.
+func (c TimeSyncCheck) Name() string { return "time-sync" }
+
+// Run implements Check.
+func (c TimeSyncCheck) Run(client machine.Client) (Status, string) {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	out, err := runCommand(client, "date +%s")
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return StatusFail, fmt.Sprintf("unable to parse host time %q: %v", strings.TrimSpace(out), err)
+	}
+	skew := now().Sub(time.Unix(seconds, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > c.MaxSkew {
+		return StatusFail, fmt.Sprintf("clock is off by %v, must be within %v", skew.Round(time.Second), c.MaxSkew)
+	}
+	out, err = runCommand(client, "timedatectl status")
+	if err != nil {
+		return StatusWarn, fmt.Sprintf("clock is off by %v, unable to read synchronization status: %v", skew.Round(time.Second), err)
+	}
+	if !strings.Contains(out, "synchronized: yes") {
+		return StatusWarn, fmt.Sprintf("clock is off by %v, and is not synchronized", skew.Round(time.Second))
+	}
+	return StatusPass, fmt.Sprintf("clock is off by %v, and is synchronized", skew.Round(time.Second))
+}
+
+// ProductUUID returns the product UUID of the host, which identifies its
+// cluster node.
+func ProductUUID(client machine.Client) (string, error) {
+	out, err := runCommand(client, fmt.Sprintf("cat %s", common.SystemUUIDFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// ProductUUIDCheck checks that the product UUID of the host is not the
+// product UUID of another machine, which happens when hosts are cloned from
+// one image.
+type ProductUUIDCheck struct {
+	// Known maps the product UUIDs of other machines to their names.
+	Known map[string]string
+}
+
+// Name implements Check.
+func (c ProductUUIDCheck) Name() string { return "product-uuid" }
+
+// Run implements Check.
+func (c ProductUUIDCheck) Run(client machine.Client) (Status, string) {
+	uuid, err := ProductUUID(client)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	if len(uuid) == 0 {
+		return StatusFail, fmt.Sprintf("%s is empty", common.SystemUUIDFile)
+	}
+	for known, name := range c.Known {
+		if strings.EqualFold(known, uuid) {
+			return StatusFail, fmt.Sprintf("product UUID %s is also the product UUID of machine %q", uuid, name)
+		}
+	}
+	return StatusPass, fmt.Sprintf("product UUID %s is unique", uuid)
+}
+
+// DefaultChecks returns the checks for a host that will be provisioned as a
+// master or node.
+func DefaultChecks(isMaster bool, cgroupDriver string, knownProductUUIDs map[string]string) []Check {
+	ports := NodePorts
+	if isMaster {
+		ports = MasterPorts
+	}
+	return []Check{
+		OSCheck{},
+		CgroupDriverCheck{Expected: cgroupDriver},
+		PortsCheck{Ports: ports},
+		SwapCheck{},
+		DiskCheck{
+			Path:                 DefaultDiskPath,
+			MinFreeBytes:         DefaultMinFreeDiskBytes,
+			RecommendedFreeBytes: DefaultRecommendedFreeDiskBytes,
+		},
+		TimeSyncCheck{MaxSkew: DefaultMaxClockSkew},
+		ProductUUIDCheck{Known: knownProductUUIDs},
+	}
+}
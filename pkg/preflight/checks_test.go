@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preflight_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/platform9/cctl/pkg/machineclient/fake"
+	"github.com/platform9/cctl/pkg/preflight"
+)
+
+var now = time.Unix(1550000000, 0)
+
+func newHealthyClient() *fake.Client {
+	return &fake.Client{
+		Commands: map[string]string{
+			"uname -sr": "Linux 4.15.0-45-generic\n",
+			"docker info --format '{{.CgroupDriver}}'": "cgroupfs\n",
+			"ss -ltn": `State    Recv-Q    Send-Q    Local Address:Port    Peer Address:Port
+LISTEN   0         128       0.0.0.0:22            0.0.0.0:*
+LISTEN   0         128       [::]:22               [::]:*
+`,
+			"df -Pk /var/lib": `Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/sda1         40000000 5000000  35000000      13% /
+`,
+			"date +%s":                           "1550000003\n",
+			"timedatectl status":                 "System clock synchronized: yes\n",
+			"cat /sys/class/dmi/id/product_uuid": "4C4C4544-0042-3510-8052-B4C04F4D4E32\n",
+		},
+		Files: map[string]string{
+			"/etc/os-release": "NAME=\"Ubuntu\"\nID=ubuntu\nVERSION_ID=\"16.04\"\n",
+			"/proc/swaps":     "Filename\tType\tSize\tUsed\tPriority\n",
+		},
+	}
+}
+
+func checks() []preflight.Check {
+	checks := preflight.DefaultChecks(true, "", map[string]string{"AAAA": "10.0.0.9"})
+	for i, check := range checks {
+		if _, ok := check.(preflight.TimeSyncCheck); ok {
+			checks[i] = preflight.TimeSyncCheck{MaxSkew: preflight.DefaultMaxClockSkew, Now: func() time.Time { return now }}
+		}
+	}
+	return checks
+}
+
+func statuses(results []preflight.Result) map[string]preflight.Status {
+	s := make(map[string]preflight.Status)
+	for _, result := range results {
+		s[result.Check] = result.Status
+	}
+	return s
+}
+
+func TestRunPass(t *testing.T) {
+	results := preflight.Run(newHealthyClient(), checks())
+	for _, result := range results {
+		if result.Status != preflight.StatusPass {
+			t.Errorf("check %q: expected %q, got %q (%s)", result.Check, preflight.StatusPass, result.Status, result.Message)
+		}
+	}
+	if preflight.Failed(results) {
+		t.Errorf("expected no failed results")
+	}
+}
+
+func TestRunFail(t *testing.T) {
+	client := newHealthyClient()
+	client.Commands["uname -sr"] = "Linux 3.2.0-4-amd64\n"
+	client.Commands["docker info --format '{{.CgroupDriver}}'"] = "systemd\n"
+	client.Commands["ss -ltn"] += "LISTEN   0         128       127.0.0.1:2379        0.0.0.0:*\n"
+	client.Files["/proc/swaps"] += "/dev/sda2\tpartition\t1000\t0\t-2\n"
+	client.Commands["df -Pk /var/lib"] = "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 40000000 39000000 1000000 98% /\n"
+	client.Commands["date +%s"] = "1550000600\n"
+	client.Commands["cat /sys/class/dmi/id/product_uuid"] = "aaaa\n"
+
+	results := preflight.Run(client, checks())
+	for check, status := range statuses(results) {
+		if status != preflight.StatusFail {
+			t.Errorf("check %q: expected %q, got %q", check, preflight.StatusFail, status)
+		}
+	}
+	if !preflight.Failed(results) {
+		t.Errorf("expected failed results")
+	}
+}
+
+func TestRunWarn(t *testing.T) {
+	client := newHealthyClient()
+	client.Files["/etc/os-release"] = "ID=gentoo\n"
+	delete(client.Commands, "docker info --format '{{.CgroupDriver}}'")
+	client.Commands["df -Pk /var/lib"] = "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 40000000 35000000 5000000 88% /\n"
+	client.Commands["timedatectl status"] = "System clock synchronized: no\n"
+
+	results := preflight.Run(client, checks())
+	expected := map[string]preflight.Status{
+		"os":            preflight.StatusWarn,
+		"cgroup-driver": preflight.StatusWarn,
+		"ports":         preflight.StatusPass,
+		"swap":          preflight.StatusPass,
+		"disk":          preflight.StatusWarn,
+		"time-sync":     preflight.StatusWarn,
+		"product-uuid":  preflight.StatusPass,
+	}
+	for check, status := range statuses(results) {
+		if status != expected[check] {
+			t.Errorf("check %q: expected %q, got %q", check, expected[check], status)
+		}
+	}
+	if preflight.Failed(results) {
+		t.Errorf("expected no failed results")
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package preflight checks that a host can be provisioned as a machine, before
+// any change is made to it.
+package preflight
+
+import (
+	"github.com/platform9/ssh-provider/pkg/machine"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	// StatusPass means the host satisfies the check.
+	StatusPass Status = "pass"
+	// StatusWarn means the host may not be provisioned correctly.
+	StatusWarn Status = "warn"
+	// StatusFail means the host will not be provisioned correctly.
+	StatusFail Status = "fail"
+)
+
+// Check inspects one property of a host.
+type Check interface {
+	// Name identifies the check in its result.
+	Name() string
+	// Run inspects the host using the client, and returns the status and a
+	// message that explains it.
+	Run(client machine.Client) (Status, string)
+}
+
+// Result is the outcome of running a check.
+type Result struct {
+	Check   string
+	Status  Status
+	Message string
+}
+
+// Run runs the checks in order, and returns their results.
+func Run(client machine.Client, checks []Check) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		status, message := check.Run(client)
+		results = append(results, Result{
+			Check:   check.Name(),
+			Status:  status,
+			Message: message,
+		})
+	}
+	return results
+}
+
+// Failed returns true if any of the results failed.
+func Failed(results []Result) bool {
+	for _, result := range results {
+		if result.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
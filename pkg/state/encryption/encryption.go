@@ -0,0 +1,202 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// EncryptedAnnotationKey marks a Secret whose data is encrypted in the
+	// state file.
+	EncryptedAnnotationKey = "cctl.platform9.com/encrypted"
+	// KeySize is the size in bytes of the data key and key-encryption key.
+	KeySize = 32
+)
+
+// Config describes how secret data in the state file is encrypted. Secret data
+// is encrypted with a random data key. The data key is stored in the state file
+// wrapped, i.e. encrypted, with a key-encryption key obtained from a
+// KeyProvider.
+type Config struct {
+	// Provider is the name of the KeyProvider of the key-encryption key.
+	Provider string `json:"provider"`
+	// Salt is used to derive the key-encryption key from a passphrase.
+	// +optional
+	Salt []byte `json:"salt,omitempty"`
+	// Iterations is the number of iterations used to derive the
+	// key-encryption key from a passphrase.
+	// +optional
+	Iterations int `json:"iterations,omitempty"`
+	// WrappedKey is the data key, encrypted with the key-encryption key.
+	WrappedKey []byte `json:"wrappedKey"`
+}
+
+// NewConfig generates a data key, and returns it along with the Config that
+// stores it wrapped with the key-encryption key from the provider.
+func NewConfig(provider KeyProvider) (*Config, []byte, error) {
+	cfg := &Config{
+		Provider: provider.Name(),
+	}
+	if err := provider.Init(cfg); err != nil {
+		return nil, nil, err
+	}
+	kek, err := provider.KeyEncryptionKey(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey, err := randomBytes(KeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate data key: %v", err)
+	}
+	wrappedKey, err := seal(kek, dataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to wrap data key: %v", err)
+	}
+	cfg.WrappedKey = wrappedKey
+	return cfg, dataKey, nil
+}
+
+// DataKey unwraps the data key stored in the Config, using the key-encryption
+// key from the provider.
+func DataKey(cfg *Config, provider KeyProvider) ([]byte, error) {
+	if cfg.Provider != provider.Name() {
+		return nil, fmt.Errorf("secrets are encrypted with a key from provider %q, but provider %q is configured", cfg.Provider, provider.Name())
+	}
+	kek, err := provider.KeyEncryptionKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(kek, cfg.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key, the passphrase or key may be wrong: %v", err)
+	}
+	return dataKey, nil
+}
+
+// Ciphertexts holds the encrypted data of secrets, keyed by namespace, name
+// and data key. Encryption uses a random nonce, so data that is encrypted again
+// never has the same bytes. Data that has not changed since it was decrypted is
+// written back with its previous ciphertext instead, so that an unchanged state
+// is written with the same bytes.
+type Ciphertexts map[string][]byte
+
+func ciphertextKey(secret *corev1.Secret, key string) string {
+	return secret.Namespace + "/" + secret.Name + "/" + key
+}
+
+// EncryptSecrets returns copies of the secrets with their data encrypted, and
+// the ciphertexts of the data. Data whose previous ciphertext decrypts to the
+// same plaintext keeps that ciphertext.
+func EncryptSecrets(secrets []corev1.Secret, dataKey []byte, previous Ciphertexts) ([]corev1.Secret, Ciphertexts, error) {
+	encrypted := make([]corev1.Secret, len(secrets))
+	ciphertexts := Ciphertexts{}
+	for i, secret := range secrets {
+		secret := secret.DeepCopy()
+		for k, v := range secret.Data {
+			ev, ok := previous[ciphertextKey(secret, k)]
+			if pv, err := open(dataKey, ev); !ok || err != nil || !bytes.Equal(pv, v) {
+				if ev, err = seal(dataKey, v); err != nil {
+					return nil, nil, fmt.Errorf("unable to encrypt key %q of secret %q: %v", k, secret.Name, err)
+				}
+			}
+			secret.Data[k] = ev
+			ciphertexts[ciphertextKey(secret, k)] = ev
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[EncryptedAnnotationKey] = "true"
+		encrypted[i] = *secret
+	}
+	return encrypted, ciphertexts, nil
+}
+
+// DecryptSecrets decrypts the data of every encrypted secret in place, and
+// returns the ciphertexts of the data.
+func DecryptSecrets(secrets []corev1.Secret, dataKey []byte) (Ciphertexts, error) {
+	ciphertexts := Ciphertexts{}
+	for i := range secrets {
+		secret := &secrets[i]
+		if _, ok := secret.Annotations[EncryptedAnnotationKey]; !ok {
+			continue
+		}
+		for k, v := range secret.Data {
+			dv, err := open(dataKey, v)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decrypt key %q of secret %q: %v", k, secret.Name, err)
+			}
+			secret.Data[k] = dv
+			ciphertexts[ciphertextKey(secret, k)] = v
+		}
+		delete(secret.Annotations, EncryptedAnnotationKey)
+		if len(secret.Annotations) == 0 {
+			secret.Annotations = nil
+		}
+	}
+	return ciphertexts, nil
+}
+
+// seal encrypts and authenticates the plaintext with AES-GCM. The random nonce
+// is prepended to the ciphertext.
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open reverses seal.
+func open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPassphraseProviderKeyEncryptionKey(t *testing.T) {
+	// The key-encryption key is derived with PBKDF2 and HMAC-SHA256, so that
+	// existing state files can still be decrypted.
+	provider := &PassphraseProvider{PassphraseFile: "/nonexistent", passphrase: []byte("password")}
+	kek, err := provider.KeyEncryptionKey(&Config{Salt: []byte("salt"), Iterations: 2})
+	if err != nil {
+		t.Fatalf("Error deriving key-encryption key: %v", err)
+	}
+	expected := "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"
+	if hex.EncodeToString(kek) != expected {
+		t.Fatalf("Expected key-encryption key %s, found %x", expected, kek)
+	}
+}
+
+func TestEncryptDecryptSecrets(t *testing.T) {
+	os.Setenv(PassphraseEnvVar, "correct horse battery staple")
+	defer os.Unsetenv(PassphraseEnvVar)
+
+	cfg, dataKey, err := NewConfig(&PassphraseProvider{})
+	if err != nil {
+		t.Fatalf("Error creating config: %v", err)
+	}
+	secrets := []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "ssh-credential"},
+			Data:       map[string][]byte{"username": []byte("root")},
+		},
+	}
+	encrypted, _, err := EncryptSecrets(secrets, dataKey, nil)
+	if err != nil {
+		t.Fatalf("Error encrypting secrets: %v", err)
+	}
+	if bytes.Equal(encrypted[0].Data["username"], secrets[0].Data["username"]) {
+		t.Fatalf("Expected secret data to be encrypted")
+	}
+	if string(secrets[0].Data["username"]) != "root" {
+		t.Fatalf("Expected original secret to be unchanged")
+	}
+
+	// A new provider reads the passphrase again to unwrap the data key.
+	unwrappedKey, err := DataKey(cfg, &PassphraseProvider{})
+	if err != nil {
+		t.Fatalf("Error unwrapping data key: %v", err)
+	}
+	ciphertext := encrypted[0].Data["username"]
+	ciphertexts, err := DecryptSecrets(encrypted, unwrappedKey)
+	if err != nil {
+		t.Fatalf("Error decrypting secrets: %v", err)
+	}
+	if string(encrypted[0].Data["username"]) != "root" {
+		t.Fatalf("Expected decrypted data %q, found %q", "root", encrypted[0].Data["username"])
+	}
+	if _, ok := encrypted[0].Annotations[EncryptedAnnotationKey]; ok {
+		t.Fatalf("Expected encrypted annotation to be removed")
+	}
+
+	// Unchanged data keeps its ciphertext, and changed data is encrypted
+	// again.
+	reencrypted, _, err := EncryptSecrets(encrypted, unwrappedKey, ciphertexts)
+	if err != nil {
+		t.Fatalf("Error encrypting secrets: %v", err)
+	}
+	if !bytes.Equal(reencrypted[0].Data["username"], ciphertext) {
+		t.Fatalf("Expected unchanged data to keep its ciphertext")
+	}
+	encrypted[0].Data["username"] = []byte("admin")
+	reencrypted, _, err = EncryptSecrets(encrypted, unwrappedKey, ciphertexts)
+	if err != nil {
+		t.Fatalf("Error encrypting secrets: %v", err)
+	}
+	if bytes.Equal(reencrypted[0].Data["username"], ciphertext) {
+		t.Fatalf("Expected changed data to be encrypted again")
+	}
+
+	os.Setenv(PassphraseEnvVar, "wrong")
+	if _, err := DataKey(cfg, &PassphraseProvider{}); err == nil {
+		t.Fatalf("Expected error unwrapping data key with the wrong passphrase")
+	}
+}
+
+func TestKeyFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-encryption-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	provider := &KeyFileProvider{KeyFile: filepath.Join(dir, "state.key")}
+
+	cfg, dataKey, err := NewConfig(provider)
+	if err != nil {
+		t.Fatalf("Error creating config: %v", err)
+	}
+	unwrappedKey, err := DataKey(cfg, provider)
+	if err != nil {
+		t.Fatalf("Error unwrapping data key: %v", err)
+	}
+	if !bytes.Equal(dataKey, unwrappedKey) {
+		t.Fatalf("Expected unwrapped data key to match")
+	}
+	if _, err := DataKey(cfg, &PassphraseProvider{}); err == nil {
+		t.Fatalf("Expected error unwrapping data key with a different provider")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// PassphraseProviderName is the name of the provider that derives the
+	// key-encryption key from a passphrase.
+	PassphraseProviderName = "passphrase"
+	// KeyFileProviderName is the name of the provider that reads the
+	// key-encryption key from a local key file.
+	KeyFileProviderName = "keyfile"
+	// PassphraseEnvVar is the environment variable that holds the passphrase.
+	PassphraseEnvVar = "CCTL_STATE_PASSPHRASE"
+	// DefaultIterations is the number of PBKDF2 iterations used to derive the
+	// key-encryption key from a passphrase.
+	DefaultIterations = 100000
+	saltSize          = 16
+)
+
+// KeyProvider provides the key-encryption key used to wrap the data key.
+type KeyProvider interface {
+	// Name returns the name recorded in the Config.
+	Name() string
+	// Init records any provider parameters in a new Config.
+	Init(cfg *Config) error
+	// KeyEncryptionKey returns the key-encryption key for the Config.
+	KeyEncryptionKey(cfg *Config) ([]byte, error)
+}
+
+// PassphraseProvider derives the key-encryption key from a passphrase. The
+// passphrase is read from the PassphraseEnvVar environment variable, from a
+// file, or from a prompt on the terminal, in that order.
+type PassphraseProvider struct {
+	// PassphraseFile is the file that holds the passphrase.
+	// +optional
+	PassphraseFile string
+
+	passphrase []byte
+}
+
+// Name implements KeyProvider
+func (p *PassphraseProvider) Name() string {
+	return PassphraseProviderName
+}
+
+// Init implements KeyProvider
+func (p *PassphraseProvider) Init(cfg *Config) error {
+	salt, err := randomBytes(saltSize)
+	if err != nil {
+		return fmt.Errorf("unable to generate salt: %v", err)
+	}
+	cfg.Salt = salt
+	cfg.Iterations = DefaultIterations
+	return nil
+}
+
+// KeyEncryptionKey implements KeyProvider
+func (p *PassphraseProvider) KeyEncryptionKey(cfg *Config) ([]byte, error) {
+	if len(cfg.Salt) == 0 || cfg.Iterations <= 0 {
+		return nil, fmt.Errorf("passphrase encryption config is missing salt or iterations")
+	}
+	passphrase, err := p.readPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key(passphrase, cfg.Salt, cfg.Iterations, KeySize, sha256.New), nil
+}
+
+func (p *PassphraseProvider) readPassphrase() ([]byte, error) {
+	if len(p.passphrase) != 0 {
+		return p.passphrase, nil
+	}
+	switch {
+	case len(os.Getenv(PassphraseEnvVar)) != 0:
+		p.passphrase = []byte(os.Getenv(PassphraseEnvVar))
+	case len(p.PassphraseFile) != 0:
+		b, err := ioutil.ReadFile(p.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read passphrase file %q: %v", p.PassphraseFile, err)
+		}
+		p.passphrase = bytes.TrimRight(b, "\r\n")
+	case terminal.IsTerminal(int(os.Stdin.Fd())):
+		fmt.Fprint(os.Stderr, "State passphrase: ")
+		b, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read passphrase: %v", err)
+		}
+		p.passphrase = b
+	default:
+		return nil, fmt.Errorf("no passphrase given: set %s, use a passphrase file, or run from a terminal", PassphraseEnvVar)
+	}
+	if len(p.passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	return p.passphrase, nil
+}
+
+// KeyFileProvider reads the key-encryption key from a local key file. The key
+// file acts as a local key management service: it can be kept on separate,
+// restricted storage from the state file.
+type KeyFileProvider struct {
+	// KeyFile is the file that holds the key-encryption key.
+	KeyFile string
+}
+
+// Name implements KeyProvider
+func (p *KeyFileProvider) Name() string {
+	return KeyFileProviderName
+}
+
+// Init implements KeyProvider. If the key file does not exist, a random key is
+// generated and written to it.
+func (p *KeyFileProvider) Init(cfg *Config) error {
+	if _, err := os.Stat(p.KeyFile); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("unable to stat key file %q: %v", p.KeyFile, err)
+	}
+	key, err := randomBytes(KeySize)
+	if err != nil {
+		return fmt.Errorf("unable to generate key: %v", err)
+	}
+	if err := ioutil.WriteFile(p.KeyFile, key, 0400); err != nil {
+		return fmt.Errorf("unable to write key file %q: %v", p.KeyFile, err)
+	}
+	return nil
+}
+
+// KeyEncryptionKey implements KeyProvider
+func (p *KeyFileProvider) KeyEncryptionKey(cfg *Config) ([]byte, error) {
+	key, err := ioutil.ReadFile(p.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key file %q: %v", p.KeyFile, err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key file %q must contain exactly %d bytes, found %d", p.KeyFile, KeySize, len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3 is a minimal client for S3-compatible object stores. It supports
+// just enough of the API to store the cctl state: getting and conditionally
+// putting an object. Requests are signed with AWS Signature Version 4, and use
+// path-style URLs so that MinIO and other S3-compatible stores work.
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRegion is used if no region is configured.
+	DefaultRegion = "us-east-1"
+
+	amzDateFormat   = "20060102T150405Z"
+	shortDateFormat = "20060102"
+	signAlgorithm   = "AWS4-HMAC-SHA256"
+)
+
+var (
+	// ErrNotFound is returned when the object does not exist.
+	ErrNotFound = errors.New("object not found")
+	// ErrPreconditionFailed is returned when a conditional put fails because
+	// the object changed.
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Client is a client for an S3-compatible object store.
+type Client struct {
+	// Endpoint is the URL of the object store, e.g. http://localhost:9000.
+	// If empty, the AWS S3 endpoint of the region is used.
+	// +optional
+	Endpoint string
+	// Region is the region of the bucket.
+	Region string
+	// AccessKeyID and SecretAccessKey are the credentials used to sign
+	// requests. If empty, requests are not signed.
+	// +optional
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is the token of temporary credentials.
+	// +optional
+	SessionToken string
+	// HTTPClient is used to send requests. If nil, http.DefaultClient is used.
+	// +optional
+	HTTPClient *http.Client
+
+	now func() time.Time
+}
+
+// NewClientFromEnv returns a client configured with the standard AWS
+// environment variables: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN, AWS_REGION (or AWS_DEFAULT_REGION), and
+// AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL).
+func NewClientFromEnv() *Client {
+	c := &Client{
+		Endpoint:        firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		Region:          firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.Region == "" {
+		c.Region = DefaultRegion
+	}
+	return c
+}
+
+// GetObject returns the content and the ETag of the object.
+func (c *Client) GetObject(bucket, key string) ([]byte, string, error) {
+	req, err := c.newRequest(http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read response: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return body, resp.Header.Get("ETag"), nil
+	case http.StatusNotFound:
+		return nil, "", ErrNotFound
+	default:
+		return nil, "", responseError(req, resp, body)
+	}
+}
+
+// PutObject stores the content in the object, and returns its new ETag. If
+// ifMatch is not empty, the object is only replaced if its ETag matches.
+// Otherwise, the object is only created if it does not exist. If the condition
+// is not met, ErrPreconditionFailed is returned.
+func (c *Client) PutObject(bucket, key string, content []byte, ifMatch string) (string, error) {
+	req, err := c.newRequest(http.MethodPut, bucket, key, content)
+	if err != nil {
+		return "", err
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	} else {
+		req.Header.Set("If-None-Match", "*")
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read response: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("ETag"), nil
+	// A conditional create of an existing object may also be reported as a
+	// conflict.
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return "", ErrPreconditionFailed
+	default:
+		return "", responseError(req, resp, body)
+	}
+}
+
+func (c *Client) newRequest(method, bucket, key string, content []byte) (*http.Request, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.Region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse endpoint %q: %v", endpoint, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %v", err)
+	}
+	req.ContentLength = int64(len(content))
+	c.sign(req, content)
+	return req, nil
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to %s %s: %v", req.Method, req.URL, err)
+	}
+	return resp, nil
+}
+
+// sign adds the headers of an AWS Signature Version 4 to the request.
+func (c *Client) sign(req *http.Request, content []byte) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	t := now().UTC()
+	payloadHash := hashHex(content)
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return
+	}
+
+	headerNames := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if c.SessionToken != "" {
+		headerNames = append(headerNames, "x-amz-security-token")
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", t.Format(shortDateFormat), c.Region)
+	stringToSign := strings.Join([]string{
+		signAlgorithm,
+		t.Format(amzDateFormat),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), []byte(t.Format(shortDateFormat)))
+	key = hmacSHA256(key, []byte(c.Region))
+	key = hmacSHA256(key, []byte("s3"))
+	key = hmacSHA256(key, []byte("aws4_request"))
+	signature := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func responseError(req *http.Request, resp *http.Response, body []byte) error {
+	return fmt.Errorf("unable to %s %s: %s: %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(body)))
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
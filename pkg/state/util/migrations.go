@@ -17,7 +17,7 @@ limitations under the License.
 package util
 
 import (
-	log "github.com/platform9/cctl/pkg/logrus"
+	"fmt"
 
 	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
 	sputil "github.com/platform9/ssh-provider/pkg/controller"
@@ -66,7 +66,7 @@ func ClusterConfigForV0AndV1Cluster() *spv1.ClusterConfig {
 }
 
 // StateV2FromStateV1 migrates state from V1 to V2
-func StateV2FromStateV1(stateV1 *v1.State) *v2.State {
+func StateV2FromStateV1(stateV1 *v1.State) (*v2.State, error) {
 	stateV2 := v2.State{
 		SchemaVersion: v2.Version,
 		Filename:      stateV1.Filename,
@@ -76,18 +76,18 @@ func StateV2FromStateV1(stateV1 *v1.State) *v2.State {
 	}
 	cluster, err := stateV2.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
 	if err != nil {
-		log.Fatalf("unable to get cluster %s: %v", common.DefaultClusterName, err)
+		return nil, fmt.Errorf("unable to get cluster %s: %v", common.DefaultClusterName, err)
 	}
 	clusterSpec, err := sputil.GetClusterSpec(*cluster)
 	if err != nil {
-		log.Fatalf("unable to get cluster spec %s: %v", common.DefaultClusterName, err)
+		return nil, fmt.Errorf("unable to get cluster spec %s: %v", common.DefaultClusterName, err)
 	}
 	clusterSpec.ClusterConfig = ClusterConfigForV0AndV1Cluster()
 	if err := sputil.PutClusterSpec(*clusterSpec, cluster); err != nil {
-		log.Fatalf("Unable to update cluster spec %s: %v", common.DefaultClusterName, err)
+		return nil, fmt.Errorf("unable to update cluster spec %s: %v", common.DefaultClusterName, err)
 	}
 	if _, err = stateV2.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Update(cluster); err != nil {
-		log.Fatalf("unable to update cluster spec %s: %v", common.DefaultClusterName, err)
+		return nil, fmt.Errorf("unable to update cluster spec %s: %v", common.DefaultClusterName, err)
 	}
-	return &stateV2
+	return &stateV2, nil
 }
@@ -75,7 +75,10 @@ func TestStateV2FromStateV1(t *testing.T) {
 	if err := stateV1.PushToAPIs(); err != nil {
 		t.Fatalf("Error reading from state: %v", err)
 	}
-	stateV2 := stateutil.StateV2FromStateV1(stateV1)
+	stateV2, err := stateutil.StateV2FromStateV1(stateV1)
+	if err != nil {
+		t.Fatalf("Error migrating state: %v", err)
+	}
 
 	// Test in-memory migration
 	expectedSchemaVersion := v2.Version
@@ -0,0 +1,235 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	spclientfake "github.com/platform9/ssh-provider/pkg/client/clientset_generated/clientset/fake"
+	kubeclientfake "k8s.io/client-go/kubernetes/fake"
+	clusterclientfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+
+	"github.com/platform9/cctl/pkg/state/v0"
+	"github.com/platform9/cctl/pkg/state/v1"
+	"github.com/platform9/cctl/pkg/state/v2"
+)
+
+// Migration migrates serialized state from one schema version to another.
+type Migration struct {
+	From    int
+	To      int
+	Migrate func(stateBytes []byte) ([]byte, error)
+}
+
+// registry maps a schema version to the migration from that version.
+var registry = map[int]Migration{}
+
+func init() {
+	MustRegister(Migration{From: 0, To: 1, Migrate: migrateV0ToV1})
+	MustRegister(Migration{From: 1, To: 2, Migrate: migrateV1ToV2})
+}
+
+// Register adds the migration to the registry. Only one migration from each
+// schema version can be registered.
+func Register(m Migration) error {
+	if m.To <= m.From {
+		return fmt.Errorf("migration from version %d must be to a later version, not %d", m.From, m.To)
+	}
+	if _, ok := registry[m.From]; ok {
+		return fmt.Errorf("a migration from version %d is already registered", m.From)
+	}
+	registry[m.From] = m
+	return nil
+}
+
+// MustRegister is like Register, but panics if the migration cannot be
+// registered.
+func MustRegister(m Migration) {
+	if err := Register(m); err != nil {
+		panic(err)
+	}
+}
+
+// LatestVersion returns the latest schema version that state can be migrated
+// to.
+func LatestVersion() int {
+	latest := 0
+	for _, m := range registry {
+		if m.To > latest {
+			latest = m.To
+		}
+	}
+	return latest
+}
+
+// Chain returns the migrations, in order, that migrate state from one schema
+// version to another.
+func Chain(from, to int) ([]Migration, error) {
+	chain := []Migration{}
+	for version := from; version != to; {
+		m, ok := registry[version]
+		if !ok || m.To > to {
+			return nil, fmt.Errorf("no migration from version %d to version %d", version, to)
+		}
+		chain = append(chain, m)
+		version = m.To
+	}
+	return chain, nil
+}
+
+// Migrate migrates serialized state to the latest schema version. It returns
+// the migrated state, and the chain of migrations that was applied.
+func Migrate(stateBytes []byte) ([]byte, []Migration, error) {
+	from, err := Version(bytes.NewReader(stateBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := Chain(from, LatestVersion())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, m := range chain {
+		if stateBytes, err = m.Migrate(stateBytes); err != nil {
+			return nil, nil, fmt.Errorf("unable to migrate from version %d to version %d: %v", m.From, m.To, err)
+		}
+	}
+	return stateBytes, chain, nil
+}
+
+// StoreMigration is the migration of the state in a store.
+type StoreMigration struct {
+	StateBytes    []byte
+	MigratedBytes []byte
+	Chain         []Migration
+	// BackupFilename is the name of the backup made of the state file before
+	// it was migrated. It is empty if no backup was made.
+	BackupFilename string
+}
+
+// MigrateStore migrates the state in the store to the latest schema version.
+// The state file is backed up before the migrated state is written. If dryRun
+// is set, the state file is neither backed up nor written.
+func MigrateStore(store *v2.FileStore, dryRun bool) (*StoreMigration, error) {
+	stateBytes, err := store.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read state file: %v", err)
+	}
+	migratedBytes, chain, err := Migrate(stateBytes)
+	if err != nil {
+		return nil, err
+	}
+	sm := &StoreMigration{
+		StateBytes:    stateBytes,
+		MigratedBytes: migratedBytes,
+		Chain:         chain,
+	}
+	if len(chain) == 0 || dryRun {
+		return sm, nil
+	}
+	backupFilename := BackupFilename(store.Filename, chain[0].From, time.Now())
+	if err := writeBackup(backupFilename, stateBytes); err != nil {
+		return nil, fmt.Errorf("unable to back up state file: %v", err)
+	}
+	sm.BackupFilename = backupFilename
+	if err := store.Write(migratedBytes); err != nil {
+		return nil, fmt.Errorf("unable to write state file: %v", err)
+	}
+	return sm, nil
+}
+
+// writeBackup writes the backup file, and syncs it to disk. An existing file
+// is never overwritten.
+func writeBackup(filename string, data []byte) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, v2.FileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// BackupFilename returns the name of the timestamped backup made of the state
+// file before it is migrated from the schema version.
+func BackupFilename(filename string, version int, t time.Time) string {
+	return fmt.Sprintf("%s.v%d-%s.bak", filename, version, t.UTC().Format("20060102T150405Z"))
+}
+
+func migrateV0ToV1(stateBytes []byte) ([]byte, error) {
+	return withTempFile(stateBytes, func(filename string) error {
+		stateV0 := v0.NewWithFile(filename, kubeclientfake.NewSimpleClientset(), clusterclientfake.NewSimpleClientset(), spclientfake.NewSimpleClientset())
+		if err := stateV0.PushToAPIs(); err != nil {
+			return fmt.Errorf("unable to read state: %v", err)
+		}
+		stateV1 := StateV1FromStateV0(stateV0)
+		if err := stateV1.PullFromAPIs(); err != nil {
+			return fmt.Errorf("unable to write state: %v", err)
+		}
+		return nil
+	})
+}
+
+func migrateV1ToV2(stateBytes []byte) ([]byte, error) {
+	return withTempFile(stateBytes, func(filename string) error {
+		stateV1 := v1.NewWithFile(filename, kubeclientfake.NewSimpleClientset(), clusterclientfake.NewSimpleClientset(), spclientfake.NewSimpleClientset())
+		if err := stateV1.PushToAPIs(); err != nil {
+			return fmt.Errorf("unable to read state: %v", err)
+		}
+		stateV2, err := StateV2FromStateV1(stateV1)
+		if err != nil {
+			return err
+		}
+		store := v2.NewFileStore(filename)
+		store.Backups = 0
+		stateV2.Store = store
+		defer store.Unlock()
+		if err := stateV2.PullFromAPIs(); err != nil {
+			return fmt.Errorf("unable to write state: %v", err)
+		}
+		return nil
+	})
+}
+
+// withTempFile writes the state to a file in a temporary directory, calls fn
+// with the name of the file, and returns the content of the file afterwards.
+// States before V2 can only be read from and written to a file.
+func withTempFile(stateBytes []byte, fn func(filename string) error) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "cctl-migrate")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.yaml")
+	if err := ioutil.WriteFile(filename, stateBytes, v2.FileMode); err != nil {
+		return nil, fmt.Errorf("unable to write %q: %v", filename, err)
+	}
+	if err := fn(filename); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filename)
+}
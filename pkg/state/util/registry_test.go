@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/platform9/cctl/pkg/state/v2"
+)
+
+// withRegistry replaces the registry with one of the migrations, and returns
+// a function that restores the registry.
+func withRegistry(t *testing.T, migrations ...Migration) func() {
+	saved := registry
+	registry = map[int]Migration{}
+	for _, m := range migrations {
+		if err := Register(m); err != nil {
+			registry = saved
+			t.Fatalf("Error registering migration: %v", err)
+		}
+	}
+	return func() { registry = saved }
+}
+
+// testMigration returns a migration that only changes the schema version.
+func testMigration(from, to int) Migration {
+	return Migration{
+		From: from,
+		To:   to,
+		Migrate: func(stateBytes []byte) ([]byte, error) {
+			return []byte(strings.Replace(string(stateBytes), fmt.Sprintf("schemaVersion: %d", from), fmt.Sprintf("schemaVersion: %d", to), 1)), nil
+		},
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer withRegistry(t, testMigration(0, 1))()
+	tests := []struct {
+		name      string
+		migration Migration
+		expectErr bool
+	}{
+		{"next version", testMigration(1, 2), false},
+		{"later version", testMigration(2, 4), false},
+		{"same version", testMigration(4, 4), true},
+		{"earlier version", testMigration(5, 3), true},
+		{"already registered", testMigration(0, 2), true},
+	}
+	for _, test := range tests {
+		err := Register(test.migration)
+		if test.expectErr && err == nil {
+			t.Fatalf("%s: Expected an error registering migration from %d to %d", test.name, test.migration.From, test.migration.To)
+		}
+		if !test.expectErr && err != nil {
+			t.Fatalf("%s: Error registering migration: %v", test.name, err)
+		}
+	}
+	if LatestVersion() != 4 {
+		t.Fatalf("Expected latest version 4, found %d", LatestVersion())
+	}
+}
+
+func TestChain(t *testing.T) {
+	defer withRegistry(t, testMigration(0, 1), testMigration(1, 2), testMigration(2, 4), testMigration(5, 6))()
+	tests := []struct {
+		name      string
+		from      int
+		to        int
+		expected  []int
+		expectErr bool
+	}{
+		{"no migration", 2, 2, []int{}, false},
+		{"one step", 0, 1, []int{0}, false},
+		{"several steps", 0, 4, []int{0, 1, 2}, false},
+		{"from a later version", 1, 4, []int{1, 2}, false},
+		{"step past the target", 0, 3, nil, true},
+		{"missing step", 0, 6, nil, true},
+		{"unknown version", 3, 4, nil, true},
+	}
+	for _, test := range tests {
+		chain, err := Chain(test.from, test.to)
+		if test.expectErr {
+			if err == nil {
+				t.Fatalf("%s: Expected an error resolving the chain from %d to %d, found %v", test.name, test.from, test.to, chain)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Error resolving the chain from %d to %d: %v", test.name, test.from, test.to, err)
+		}
+		actual := []int{}
+		for _, m := range chain {
+			actual = append(actual, m.From)
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(test.expected) {
+			t.Fatalf("%s: Expected migrations from versions %v, found %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	failing := Migration{
+		From: 2,
+		To:   3,
+		Migrate: func(stateBytes []byte) ([]byte, error) {
+			return nil, fmt.Errorf("failed")
+		},
+	}
+	tests := []struct {
+		name       string
+		migrations []Migration
+		state      string
+		expected   string
+		expectErr  bool
+	}{
+		{"latest version", []Migration{testMigration(0, 1)}, "schemaVersion: 1\n", "schemaVersion: 1\n", false},
+		{"chain", []Migration{testMigration(0, 1), testMigration(1, 3)}, "schemaVersion: 0\n", "schemaVersion: 3\n", false},
+		{"missing step", []Migration{testMigration(0, 1), testMigration(2, 3)}, "schemaVersion: 0\n", "", true},
+		{"failing step", []Migration{testMigration(1, 2), failing}, "schemaVersion: 1\n", "", true},
+	}
+	for _, test := range tests {
+		restore := withRegistry(t, test.migrations...)
+		actual, _, err := Migrate([]byte(test.state))
+		restore()
+		if test.expectErr {
+			if err == nil {
+				t.Fatalf("%s: Expected an error migrating, found %q", test.name, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Error migrating: %v", test.name, err)
+		}
+		if string(actual) != test.expected {
+			t.Fatalf("%s: Expected migrated state %q, found %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	defer withRegistry(t, testMigration(0, 1), testMigration(1, 2))()
+	state := "schemaVersion: 0\n"
+	tests := []struct {
+		name     string
+		dryRun   bool
+		expected string
+	}{
+		{"dry run", true, state},
+		{"migration", false, "schemaVersion: 2\n"},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("/tmp", "cctl-registry-test")
+		if err != nil {
+			t.Fatalf("Error creating temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "state.yaml")
+		if err := ioutil.WriteFile(filename, []byte(state), v2.FileMode); err != nil {
+			t.Fatal(err)
+		}
+		sm, err := MigrateStore(v2.NewFileStore(filename), test.dryRun)
+		if err != nil {
+			t.Fatalf("%s: Error migrating state file: %v", test.name, err)
+		}
+		if len(sm.Chain) != 2 || string(sm.MigratedBytes) != "schemaVersion: 2\n" {
+			t.Fatalf("%s: Expected 2 migrations to version 2, found %d migrations to %q", test.name, len(sm.Chain), sm.MigratedBytes)
+		}
+		actual, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != test.expected {
+			t.Fatalf("%s: Expected state file %q, found %q", test.name, test.expected, actual)
+		}
+		if test.dryRun {
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || sm.BackupFilename != "" {
+				t.Fatalf("%s: Expected no backup of the state file, found %d files and backup %q", test.name, len(files), sm.BackupFilename)
+			}
+			continue
+		}
+		if !strings.HasPrefix(sm.BackupFilename, filename+".v0-") {
+			t.Fatalf("%s: Expected a backup of version 0, found %q", test.name, sm.BackupFilename)
+		}
+		backup, err := ioutil.ReadFile(sm.BackupFilename)
+		if err != nil {
+			t.Fatalf("%s: Error reading backup: %v", test.name, err)
+		}
+		if string(backup) != state {
+			t.Fatalf("%s: Expected backup %q, found %q", test.name, state, backup)
+		}
+	}
+}
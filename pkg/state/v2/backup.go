@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Backup describes a previous version of the state file.
+type Backup struct {
+	Index   int
+	Path    string
+	ModTime time.Time
+}
+
+// BackupFilename returns the name of the nth previous version of the state
+// file, where 1 is the most recent.
+func BackupFilename(filename string, n int) string {
+	return fmt.Sprintf("%s.%d", filename, n)
+}
+
+// ListBackups returns the previous versions of the state file, most recent
+// first.
+func ListBackups(filename string) ([]Backup, error) {
+	backups := []Backup{}
+	for n := 1; ; n++ {
+		path := BackupFilename(filename, n)
+		fi, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return backups, nil
+			}
+			return nil, fmt.Errorf("unable to stat %q: %v", path, err)
+		}
+		backups = append(backups, Backup{
+			Index:   n,
+			Path:    path,
+			ModTime: fi.ModTime(),
+		})
+	}
+}
+
+// rotateBackups shifts the previous versions of the state file by one, and
+// keeps the current state file as the most recent version. Nothing is rotated
+// if the state file is empty or already has the new content.
+func (fs *FileStore) rotateBackups(newStateBytes []byte) error {
+	if fs.Backups <= 0 {
+		return nil
+	}
+	currentStateBytes, err := ioutil.ReadFile(fs.Filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to read %q: %v", fs.Filename, err)
+	}
+	if len(currentStateBytes) == 0 || bytes.Equal(currentStateBytes, newStateBytes) {
+		return nil
+	}
+	if err := os.Remove(BackupFilename(fs.Filename, fs.Backups)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove oldest backup: %v", err)
+	}
+	for n := fs.Backups - 1; n >= 1; n-- {
+		if err := os.Rename(BackupFilename(fs.Filename, n), BackupFilename(fs.Filename, n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to rotate backup: %v", err)
+		}
+	}
+	// The state file is linked, not renamed, so that it is never missing.
+	if err := os.Link(fs.Filename, BackupFilename(fs.Filename, 1)); err != nil {
+		if err := ioutil.WriteFile(BackupFilename(fs.Filename, 1), currentStateBytes, FileMode); err != nil {
+			return fmt.Errorf("unable to write backup: %v", err)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup replaces the state file with its nth previous version. The
+// replaced state file becomes the most recent previous version, so a restore
+// can itself be undone.
+func (fs *FileStore) RestoreBackup(n int) error {
+	if err := fs.Lock(); err != nil {
+		return err
+	}
+	path := BackupFilename(fs.Filename, n)
+	backupBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read backup %q: %v", path, err)
+	}
+	backup := State{}
+	if err := yaml.Unmarshal(backupBytes, &backup); err != nil {
+		return fmt.Errorf("unable to unmarshal backup %q from YAML: %v", path, err)
+	}
+	if backup.SchemaVersion != Version {
+		return fmt.Errorf("unexpected backup %q version. Expecting schemaVersion %v, got %v instead", path, Version, backup.SchemaVersion)
+	}
+	return fs.Write(backupBytes)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	spclientfake "github.com/platform9/ssh-provider/pkg/client/clientset_generated/clientset/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientfake "k8s.io/client-go/kubernetes/fake"
+	clusterclientfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+
+	state "github.com/platform9/cctl/pkg/state/v2"
+)
+
+func TestBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-backup-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.yaml")
+
+	store := state.NewFileStore(filename)
+	store.Backups = 2
+	s := state.New(store, kubeclientfake.NewSimpleClientset(), clusterclientfake.NewSimpleClientset(), spclientfake.NewSimpleClientset())
+	defer s.Unlock()
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	// Each write changes the state, so each write rotates the backups.
+	for _, name := range []string{"a", "b", "c", "d"} {
+		secret := corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: testNamespace,
+			},
+		}
+		if _, err := s.KubeClient.CoreV1().Secrets(testNamespace).Create(&secret); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.PullFromAPIs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An unchanged state does not rotate the backups.
+	if err := s.PullFromAPIs(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := state.ListBackups(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, found %d", len(backups))
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The state file, its lock file and two backups.
+	if len(files) != 4 {
+		t.Fatalf("Expected 4 files in %q, found %d", dir, len(files))
+	}
+
+	expected, err := ioutil.ReadFile(state.BackupFilename(filename, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RestoreBackup(2); err != nil {
+		t.Fatalf("Error restoring backup: %v", err)
+	}
+	actual, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected) != string(actual) {
+		t.Fatalf("Expected restored state %q, found %q", expected, actual)
+	}
+}
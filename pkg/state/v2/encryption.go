@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+
+	"github.com/platform9/cctl/pkg/state/encryption"
+)
+
+// EnableEncryption makes the next write encrypt secret data with a new data
+// key, wrapped with a key-encryption key from the provider.
+func (s *State) EnableEncryption(provider encryption.KeyProvider) error {
+	if s.Encryption != nil {
+		return fmt.Errorf("secrets in state %q are already encrypted", s.store())
+	}
+	cfg, dataKey, err := encryption.NewConfig(provider)
+	if err != nil {
+		return fmt.Errorf("unable to create encryption config: %v", err)
+	}
+	s.KeyProvider = provider
+	s.Encryption = cfg
+	s.dataKey = dataKey
+	s.ciphertexts = nil
+	return nil
+}
+
+// DisableEncryption makes the next write store secret data in plain text.
+func (s *State) DisableEncryption() error {
+	if s.Encryption == nil {
+		return fmt.Errorf("secrets in state %q are not encrypted", s.store())
+	}
+	s.Encryption = nil
+	s.dataKey = nil
+	s.ciphertexts = nil
+	return nil
+}
+
+func (s *State) unwrapDataKey() error {
+	if s.dataKey != nil {
+		return nil
+	}
+	if s.KeyProvider == nil {
+		return fmt.Errorf("secrets in state %q are encrypted with a key from provider %q, but no key provider is configured", s.store(), s.Encryption.Provider)
+	}
+	dataKey, err := encryption.DataKey(s.Encryption, s.KeyProvider)
+	if err != nil {
+		return fmt.Errorf("unable to get data key: %v", err)
+	}
+	s.dataKey = dataKey
+	return nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/platform9/cctl/pkg/state/encryption"
+	state "github.com/platform9/cctl/pkg/state/v2"
+)
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-encryption-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.yaml")
+	provider := &encryption.KeyFileProvider{KeyFile: filepath.Join(dir, "state.key")}
+	secretValue := []byte("very-secret-value")
+
+	s := newTestState(filename)
+	s.KeyProvider = provider
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: testNamespace,
+		},
+		Data: map[string][]byte{"value": secretValue},
+	}
+	if _, err := s.KubeClient.CoreV1().Secrets(testNamespace).Create(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableEncryption(provider); err != nil {
+		t.Fatalf("Error enabling encryption: %v", err)
+	}
+	if err := s.PullFromAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	s.Unlock()
+
+	stateBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stateBytes, []byte("very-secret-value")) || bytes.Contains(stateBytes, []byte("dmVyeS1zZWNyZXQtdmFsdWU")) {
+		t.Fatalf("Expected secret data to be encrypted in the state file")
+	}
+
+	s = newTestState(filename)
+	s.KeyProvider = provider
+	defer s.Unlock()
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatalf("Error reading encrypted state: %v", err)
+	}
+	actual, err := s.KubeClient.CoreV1().Secrets(testNamespace).Get("secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual.Data["value"], secretValue) {
+		t.Fatalf("Expected secret data %q, found %q", secretValue, actual.Data["value"])
+	}
+}
+
+func TestEncryptedWriteUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-encryption-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.yaml")
+	provider := &encryption.KeyFileProvider{KeyFile: filepath.Join(dir, "state.key")}
+
+	s := newTestState(filename)
+	s.KeyProvider = provider
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: testNamespace,
+		},
+		Data: map[string][]byte{"value": []byte("very-secret-value")},
+	}
+	if _, err := s.KubeClient.CoreV1().Secrets(testNamespace).Create(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableEncryption(provider); err != nil {
+		t.Fatalf("Error enabling encryption: %v", err)
+	}
+	if err := s.PullFromAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	s.Unlock()
+
+	// Read the encrypted state, and write it twice without changes.
+	s = newTestState(filename)
+	s.KeyProvider = provider
+	s.Journal = state.NewJournal(state.JournalFilename(filename))
+	defer s.Unlock()
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := state.ListBackups(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.PullFromAPIs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err := state.ListBackups(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != len(backups) {
+		t.Fatalf("Expected %d backups, found %d", len(backups), len(actual))
+	}
+	revisions, err := s.Journal.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("Expected 1 journal revision, found %d", len(revisions))
+	}
+}
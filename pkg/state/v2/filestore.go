@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore stores the state in a local file. The file is locked with an
+// advisory lock, written atomically, and its previous versions are kept as
+// backups.
+type FileStore struct {
+	Filename string
+	// Backups is the number of previous versions of the state file to keep.
+	Backups int
+
+	lockFile *os.File
+}
+
+// NewFileStore returns a store for the state file.
+func NewFileStore(filename string) *FileStore {
+	return &FileStore{
+		Filename: filename,
+		Backups:  DefaultBackups,
+	}
+}
+
+func (fs *FileStore) String() string {
+	return fs.Filename
+}
+
+// Read implements StateStore. If the file does not exist, it will be created.
+func (fs *FileStore) Read() ([]byte, error) {
+	file, err := os.OpenFile(fs.Filename, os.O_RDONLY|os.O_CREATE, FileMode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %q: %v", fs.Filename, err)
+	}
+	defer file.Close()
+	stateBytes, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read from %q: %v", fs.Filename, err)
+	}
+	return stateBytes, nil
+}
+
+// Write implements StateStore. It replaces the state file atomically: the bytes are written to a
+// temporary file in the same directory, synced to disk, and renamed over the
+// state file. The previous state file is kept as a backup.
+func (fs *FileStore) Write(stateBytes []byte) error {
+	dir, base := filepath.Split(fs.Filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmpFile, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file for %q: %v", fs.Filename, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(stateBytes); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("unable to write to %q: %v", tmpFile.Name(), err)
+	}
+	if err := tmpFile.Chmod(FileMode); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("unable to set permissions of %q: %v", tmpFile.Name(), err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("unable to sync %q: %v", tmpFile.Name(), err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("unable to close %q: %v", tmpFile.Name(), err)
+	}
+	if err := fs.rotateBackups(stateBytes); err != nil {
+		return fmt.Errorf("unable to back up %q: %v", fs.Filename, err)
+	}
+	if err := os.Rename(tmpFile.Name(), fs.Filename); err != nil {
+		return fmt.Errorf("unable to replace %q: %v", fs.Filename, err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs the directory, so that a rename within it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("unable to open directory %q: %v", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("unable to sync directory %q: %v", dir, err)
+	}
+	return nil
+}
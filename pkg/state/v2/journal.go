@@ -0,0 +1,649 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/platform9/cctl/pkg/state/encryption"
+)
+
+// Revision records one write of the state. The state at a revision is
+// reconstructed by applying the patches of all revisions up to and including
+// it, in order, to an empty state.
+type Revision struct {
+	Revision  int              `json:"revision"`
+	Timestamp time.Time        `json:"timestamp"`
+	Command   string           `json:"command"`
+	Patch     []PatchOperation `json:"patch"`
+}
+
+// PatchOperation is a JSON patch (RFC 6902) operation. Only the add, remove
+// and replace operations are used.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JournalFilename returns the name of the journal of the state file.
+func JournalFilename(filename string) string {
+	return filename + ".history"
+}
+
+// Journal is a local, append-only record of the revisions of the state. Each
+// line of the journal file is one Revision, encoded as JSON.
+type Journal struct {
+	Filename string
+}
+
+// NewJournal returns the journal stored in the file.
+func NewJournal(filename string) *Journal {
+	return &Journal{
+		Filename: filename,
+	}
+}
+
+// Revisions returns all revisions, oldest first.
+func (j *Journal) Revisions() ([]Revision, error) {
+	file, err := os.Open(j.Filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Revision{}, nil
+		}
+		return nil, fmt.Errorf("unable to open journal %q: %v", j.Filename, err)
+	}
+	defer file.Close()
+	revisions := []Revision{}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) != 0 {
+			rev := Revision{}
+			if err := json.Unmarshal(line, &rev); err != nil {
+				return nil, fmt.Errorf("unable to unmarshal revision %d of journal %q from JSON: %v", len(revisions)+1, j.Filename, err)
+			}
+			revisions = append(revisions, rev)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return revisions, nil
+}
+
+// Document returns the state at the revision, as JSON. Revision 0 is the empty
+// state.
+func (j *Journal) Document(revision int) ([]byte, error) {
+	revisions, err := j.Revisions()
+	if err != nil {
+		return nil, err
+	}
+	if revision < 0 || revision > len(revisions) {
+		return nil, fmt.Errorf("revision %d not found. The latest revision is %d", revision, len(revisions))
+	}
+	doc, err := replay(revisions[:revision])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// Append records the serialized state as a new revision, if it differs from
+// the latest revision. The data of secrets that are not encrypted is recorded
+// as a hash.
+func (j *Journal) Append(stateBytes []byte, command string) error {
+	revisions, err := j.Revisions()
+	if err != nil {
+		return err
+	}
+	from, err := replay(revisions)
+	if err != nil {
+		return err
+	}
+	to, err := decodeState(stateBytes)
+	if err != nil {
+		return err
+	}
+	redactSecretData(to)
+	patch, err := Diff(from, to)
+	if err != nil {
+		return err
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	rev := Revision{
+		Revision:  len(revisions) + 1,
+		Timestamp: time.Now(),
+		Command:   command,
+		Patch:     patch,
+	}
+	revBytes, err := json.Marshal(rev)
+	if err != nil {
+		return fmt.Errorf("unable to marshal revision to JSON: %v", err)
+	}
+	file, err := os.OpenFile(j.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, FileMode)
+	if err != nil {
+		return fmt.Errorf("unable to open journal %q: %v", j.Filename, err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(revBytes, '\n')); err != nil {
+		return fmt.Errorf("unable to write to journal %q: %v", j.Filename, err)
+	}
+	return file.Sync()
+}
+
+// Compact rewrites the journal so that it holds no plain text secret data.
+// Journals written before secret data was redacted hold the data of secrets
+// that are not encrypted. Revisions that no longer change the state are
+// dropped, and the remaining revisions are numbered again. It returns true if
+// the journal was changed.
+func (j *Journal) Compact() (bool, error) {
+	revisions, err := j.Revisions()
+	if err != nil {
+		return false, err
+	}
+	compacted := []Revision{}
+	var doc interface{} = map[string]interface{}{}
+	var from interface{} = map[string]interface{}{}
+	for _, rev := range revisions {
+		if doc, err = applyPatch(doc, rev.Patch); err != nil {
+			return false, fmt.Errorf("unable to apply revision %d: %v", rev.Revision, err)
+		}
+		to, err := copyDocument(doc)
+		if err != nil {
+			return false, err
+		}
+		redactSecretData(to)
+		patch, err := Diff(from, to)
+		if err != nil {
+			return false, err
+		}
+		from = to
+		if len(patch) == 0 {
+			continue
+		}
+		compacted = append(compacted, Revision{
+			Revision:  len(compacted) + 1,
+			Timestamp: rev.Timestamp,
+			Command:   rev.Command,
+			Patch:     patch,
+		})
+	}
+	oldBytes, err := marshalRevisions(revisions)
+	if err != nil {
+		return false, err
+	}
+	newBytes, err := marshalRevisions(compacted)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(oldBytes, newBytes) {
+		return false, nil
+	}
+	tmpFilename := j.Filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, newBytes, FileMode); err != nil {
+		return false, fmt.Errorf("unable to write journal %q: %v", tmpFilename, err)
+	}
+	if err := os.Rename(tmpFilename, j.Filename); err != nil {
+		return false, fmt.Errorf("unable to replace journal %q: %v", j.Filename, err)
+	}
+	return true, nil
+}
+
+func marshalRevisions(revisions []Revision) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, rev := range revisions {
+		revBytes, err := json.Marshal(rev)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal revision to JSON: %v", err)
+		}
+		buf.Write(revBytes)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// Rollback replaces the state with its revision. The rollback is itself
+// recorded as a new revision. The journal does not record the data of secrets
+// that are not encrypted, so these secrets keep their current data, and the
+// rollback is refused if that data changed after the revision.
+func (s *State) Rollback(revision int) error {
+	if s.Journal == nil {
+		return fmt.Errorf("no journal is configured for state %q", s.store())
+	}
+	if err := s.Lock(); err != nil {
+		return err
+	}
+	docBytes, err := s.Journal.Document(revision)
+	if err != nil {
+		return err
+	}
+	// The store is read first, so that stores that detect concurrent changes
+	// accept the write.
+	currentBytes, err := s.store().Read()
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(docBytes, &doc); err != nil {
+		return fmt.Errorf("unable to unmarshal revision %d: %v", revision, err)
+	}
+	if hasRedactedSecretData(doc) {
+		if err := s.decode(currentBytes); err != nil {
+			return fmt.Errorf("unable to read current secret data: %v", err)
+		}
+		if err := restoreSecretData(doc, s.SecretList.Items, revision); err != nil {
+			return err
+		}
+		if docBytes, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("unable to marshal revision %d: %v", revision, err)
+		}
+	}
+	rolledBack := State{}
+	if err := json.Unmarshal(docBytes, &rolledBack); err != nil {
+		return fmt.Errorf("unable to unmarshal revision %d: %v", revision, err)
+	}
+	if rolledBack.SchemaVersion != Version {
+		return fmt.Errorf("unexpected revision %d version. Expecting schemaVersion %v, got %v instead", revision, Version, rolledBack.SchemaVersion)
+	}
+	stateBytes, err := yaml.JSONToYAML(docBytes)
+	if err != nil {
+		return fmt.Errorf("unable to convert revision %d to YAML: %v", revision, err)
+	}
+	return s.writeRevision(stateBytes)
+}
+
+// writeRevision writes the serialized state to the store, and records it in
+// the journal.
+func (s *State) writeRevision(stateBytes []byte) error {
+	if err := s.store().Write(stateBytes); err != nil {
+		return err
+	}
+	if s.Journal == nil {
+		return nil
+	}
+	if err := s.Journal.Append(stateBytes, strings.Join(os.Args, " ")); err != nil {
+		return fmt.Errorf("state was written, but could not be recorded in the journal: %v", err)
+	}
+	return nil
+}
+
+// Diff returns the JSON patch that changes the from document into the to
+// document. Both documents are JSON values decoded into interface{}.
+func Diff(from, to interface{}) ([]PatchOperation, error) {
+	patch := []PatchOperation{}
+	if err := diff("", from, to, &patch); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// DiffDocuments returns the JSON patch between two JSON documents.
+func DiffDocuments(fromBytes, toBytes []byte) ([]PatchOperation, error) {
+	var from, to interface{}
+	if err := json.Unmarshal(fromBytes, &from); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal JSON: %v", err)
+	}
+	if err := json.Unmarshal(toBytes, &to); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal JSON: %v", err)
+	}
+	return Diff(from, to)
+}
+
+func diff(path string, from, to interface{}, patch *[]PatchOperation) error {
+	switch fromValue := from.(type) {
+	case map[string]interface{}:
+		toValue, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(fromValue) {
+			if _, ok := toValue[key]; !ok {
+				*patch = append(*patch, PatchOperation{Op: "remove", Path: path + "/" + escapePathKey(key)})
+			}
+		}
+		for _, key := range sortedKeys(toValue) {
+			keyPath := path + "/" + escapePathKey(key)
+			if fv, ok := fromValue[key]; ok {
+				if err := diff(keyPath, fv, toValue[key], patch); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := appendOperation(patch, "add", keyPath, toValue[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		toValue, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(fromValue) && i < len(toValue); i++ {
+			if err := diff(fmt.Sprintf("%s/%d", path, i), fromValue[i], toValue[i], patch); err != nil {
+				return err
+			}
+		}
+		for i := len(fromValue); i < len(toValue); i++ {
+			if err := appendOperation(patch, "add", fmt.Sprintf("%s/%d", path, i), toValue[i]); err != nil {
+				return err
+			}
+		}
+		// Elements are removed from the end, so that indexes stay valid.
+		for i := len(fromValue) - 1; i >= len(toValue); i-- {
+			*patch = append(*patch, PatchOperation{Op: "remove", Path: fmt.Sprintf("%s/%d", path, i)})
+		}
+		return nil
+	}
+	if jsonEqual(from, to) {
+		return nil
+	}
+	return appendOperation(patch, "replace", path, to)
+}
+
+func appendOperation(patch *[]PatchOperation, op, path string, value interface{}) error {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("unable to marshal value of %q to JSON: %v", path, err)
+	}
+	*patch = append(*patch, PatchOperation{Op: op, Path: path, Value: valueBytes})
+	return nil
+}
+
+// replay applies the patches of the revisions to an empty document.
+func replay(revisions []Revision) (interface{}, error) {
+	var doc interface{} = map[string]interface{}{}
+	for _, rev := range revisions {
+		var err error
+		if doc, err = applyPatch(doc, rev.Patch); err != nil {
+			return nil, fmt.Errorf("unable to apply revision %d: %v", rev.Revision, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyPatch(doc interface{}, patch []PatchOperation) (interface{}, error) {
+	for _, op := range patch {
+		var value interface{}
+		if op.Op != "remove" {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("unable to unmarshal value of %q: %v", op.Path, err)
+			}
+		}
+		if op.Path == "" {
+			if op.Op != "replace" {
+				return nil, fmt.Errorf("unsupported operation %q on the document root", op.Op)
+			}
+			doc = value
+			continue
+		}
+		var err error
+		if doc, err = applyOperation(doc, strings.Split(op.Path, "/")[1:], op.Op, value); err != nil {
+			return nil, fmt.Errorf("unable to %s %q: %v", op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// applyOperation applies the operation at the path below the node, and returns
+// the changed node.
+func applyOperation(node interface{}, path []string, op string, value interface{}) (interface{}, error) {
+	key := unescapePathKey(path[0])
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(path) > 1 {
+			child, ok := n[key]
+			if !ok {
+				return nil, fmt.Errorf("key %q not found", key)
+			}
+			changed, err := applyOperation(child, path[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			n[key] = changed
+			return n, nil
+		}
+		switch op {
+		case "add", "replace":
+			n[key] = value
+		case "remove":
+			delete(n, key)
+		default:
+			return nil, fmt.Errorf("unsupported operation %q", op)
+		}
+		return n, nil
+	case []interface{}:
+		var i int
+		if _, err := fmt.Sscanf(key, "%d", &i); err != nil || i < 0 || i > len(n) {
+			return nil, fmt.Errorf("invalid index %q", key)
+		}
+		if len(path) > 1 || op != "add" {
+			if i == len(n) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+		}
+		if len(path) > 1 {
+			changed, err := applyOperation(n[i], path[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = changed
+			return n, nil
+		}
+		switch op {
+		case "add":
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+		case "replace":
+			n[i] = value
+		case "remove":
+			n = append(n[:i], n[i+1:]...)
+		default:
+			return nil, fmt.Errorf("unsupported operation %q", op)
+		}
+		return n, nil
+	default:
+		return nil, fmt.Errorf("key %q not found", key)
+	}
+}
+
+func decodeState(stateBytes []byte) (interface{}, error) {
+	if len(bytes.TrimSpace(stateBytes)) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	jsonBytes, err := yaml.YAMLToJSON(stateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert state from YAML to JSON: %v", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(jsonBytes, &doc); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal state from JSON: %v", err)
+	}
+	return doc, nil
+}
+
+// redactedDataPrefix marks secret data that is recorded in the journal as a
+// hash, so that changes to the data are visible, but the data is not.
+const redactedDataPrefix = "sha256:"
+
+// journalSecrets returns the secrets in the state document.
+func journalSecrets(doc interface{}) []map[string]interface{} {
+	secrets := []map[string]interface{}{}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return secrets
+	}
+	secretList, ok := root["secretList"].(map[string]interface{})
+	if !ok {
+		return secrets
+	}
+	items, ok := secretList["items"].([]interface{})
+	if !ok {
+		return secrets
+	}
+	for _, item := range items {
+		if secret, ok := item.(map[string]interface{}); ok {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// journalSecretMetadata returns the namespace and name of the secret, and
+// whether its data is encrypted.
+func journalSecretMetadata(secret map[string]interface{}) (string, string, bool) {
+	metadata, _ := secret["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	name, _ := metadata["name"].(string)
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	_, encrypted := annotations[encryption.EncryptedAnnotationKey]
+	return namespace, name, encrypted
+}
+
+// redactSecretData replaces the data of secrets that are not encrypted with a
+// hash of the data.
+func redactSecretData(doc interface{}) {
+	for _, secret := range journalSecrets(doc) {
+		if _, _, encrypted := journalSecretMetadata(secret); encrypted {
+			continue
+		}
+		data, _ := secret["data"].(map[string]interface{})
+		for k, v := range data {
+			value, ok := v.(string)
+			if !ok || strings.HasPrefix(value, redactedDataPrefix) {
+				continue
+			}
+			plaintext, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				plaintext = []byte(value)
+			}
+			data[k] = redactedValue(plaintext)
+		}
+	}
+}
+
+func hasRedactedSecretData(doc interface{}) bool {
+	for _, secret := range journalSecrets(doc) {
+		data, _ := secret["data"].(map[string]interface{})
+		for _, v := range data {
+			if value, ok := v.(string); ok && strings.HasPrefix(value, redactedDataPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactedValue returns the hash that the journal records instead of the
+// secret data.
+func redactedValue(plaintext []byte) string {
+	sum := sha256.Sum256(plaintext)
+	return redactedDataPrefix + hex.EncodeToString(sum[:])
+}
+
+// restoreSecretData replaces redacted secret data in the document with the
+// current data of the secret. The current data must match the recorded hash,
+// so that the document does not mix old objects with newer secret data.
+func restoreSecretData(doc interface{}, current []corev1.Secret, revision int) error {
+	for _, secret := range journalSecrets(doc) {
+		namespace, name, _ := journalSecretMetadata(secret)
+		data, _ := secret["data"].(map[string]interface{})
+		for k, v := range data {
+			value, ok := v.(string)
+			if !ok || !strings.HasPrefix(value, redactedDataPrefix) {
+				continue
+			}
+			currentValue, ok := currentSecretData(current, namespace, name, k)
+			if !ok {
+				return fmt.Errorf("key %q of secret %q at revision %d is not recorded in the journal, and the current state does not have it", k, name, revision)
+			}
+			if redactedValue(currentValue) != value {
+				return fmt.Errorf("key %q of secret %q changed after revision %d, and the journal does not record its data at that revision. Refusing to roll back, because the secret would not match the state", k, name, revision)
+			}
+			data[k] = base64.StdEncoding.EncodeToString(currentValue)
+		}
+	}
+	return nil
+}
+
+func currentSecretData(secrets []corev1.Secret, namespace, name, key string) ([]byte, bool) {
+	for _, secret := range secrets {
+		if secret.Namespace == namespace && secret.Name == name {
+			value, ok := secret.Data[key]
+			return value, ok
+		}
+	}
+	return nil, false
+}
+
+func copyDocument(doc interface{}) (interface{}, error) {
+	docBytes, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal state to JSON: %v", err)
+	}
+	var docCopy interface{}
+	if err := json.Unmarshal(docBytes, &docCopy); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal state from JSON: %v", err)
+	}
+	return docCopy, nil
+}
+
+func jsonEqual(a, b interface{}) bool {
+	aBytes, errA := json.Marshal(a)
+	bBytes, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aBytes, bBytes)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	pathKeyEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pathKeyUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+func escapePathKey(key string) string {
+	return pathKeyEscaper.Replace(key)
+}
+
+func unescapePathKey(key string) string {
+	return pathKeyUnescaper.Replace(key)
+}
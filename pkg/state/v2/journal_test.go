@@ -0,0 +1,280 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	state "github.com/platform9/cctl/pkg/state/v2"
+)
+
+func TestDiff(t *testing.T) {
+	from := `{"a":{"b":1,"c":[1,2,3],"d~/e":null},"f":"g"}`
+	to := `{"a":{"b":2,"c":[1],"d~/e":{"x":true}},"h":[]}`
+	patch, err := state.DiffDocuments([]byte(from), []byte(to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []state.PatchOperation{
+		{Op: "remove", Path: "/f"},
+		{Op: "replace", Path: "/a/b", Value: json.RawMessage(`2`)},
+		{Op: "remove", Path: "/a/c/2"},
+		{Op: "remove", Path: "/a/c/1"},
+		{Op: "replace", Path: "/a/d~0~1e", Value: json.RawMessage(`{"x":true}`)},
+		{Op: "add", Path: "/h", Value: json.RawMessage(`[]`)},
+	}
+	if !cmp.Equal(expected, patch) {
+		t.Fatalf("Expected patch %v, found %v", expected, patch)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-journal-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.yaml")
+
+	s := newTestState(filename)
+	s.Journal = state.NewJournal(state.JournalFilename(filename))
+	defer s.Unlock()
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		secret := corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: testNamespace,
+			},
+		}
+		if _, err := s.KubeClient.CoreV1().Secrets(testNamespace).Create(&secret); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.PullFromAPIs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An unchanged state is not recorded.
+	if err := s.PullFromAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := s.Journal.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("Expected 2 revisions, found %d", len(revisions))
+	}
+
+	if err := s.Rollback(1); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+	revisions, err = s.Journal.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("Expected rollback to be recorded as revision 3, found %d revisions", len(revisions))
+	}
+	first, err := s.Journal.Document(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := s.Journal.Document(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != string(latest) {
+		t.Fatalf("Expected revision 3 %s to equal revision 1 %s", latest, first)
+	}
+
+	rolledBack := newTestState(filename)
+	s.Unlock()
+	defer rolledBack.Unlock()
+	if err := rolledBack.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	secretList, err := rolledBack.KubeClient.CoreV1().Secrets(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secretList.Items) != 1 || secretList.Items[0].Name != "a" {
+		t.Fatalf("Expected only secret %q after rollback, found %v", "a", secretList.Items)
+	}
+}
+
+func TestJournalSecretData(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-journal-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.yaml")
+	journalFilename := state.JournalFilename(filename)
+
+	s := newTestState(filename)
+	s.Journal = state.NewJournal(journalFilename)
+	defer s.Unlock()
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: testNamespace,
+		},
+		Data: map[string][]byte{"value": []byte("very-secret-value")},
+	}
+	if _, err := s.KubeClient.CoreV1().Secrets(testNamespace).Create(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PullFromAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	journalBytes, err := ioutil.ReadFile(journalFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(journalBytes, []byte("dmVyeS1zZWNyZXQtdmFsdWU")) || !bytes.Contains(journalBytes, []byte("sha256:")) {
+		t.Fatalf("Expected the journal to record a hash of the secret data, found %s", journalBytes)
+	}
+
+	// The rollback keeps the current secret data.
+	if err := s.Rollback(1); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+	rolledBack := newTestState(filename)
+	s.Unlock()
+	defer rolledBack.Unlock()
+	if err := rolledBack.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := rolledBack.KubeClient.CoreV1().Secrets(testNamespace).Get("secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual.Data["value"]) != "very-secret-value" {
+		t.Fatalf("Expected secret data %q after rollback, found %q", "very-secret-value", actual.Data["value"])
+	}
+
+	// A journal written before secret data was redacted is compacted.
+	plaintext := `{"revision":1,"timestamp":"2019-01-01T00:00:00Z","command":"cctl","patch":[{"op":"replace","path":"","value":{"schemaVersion":2,"secretList":{"items":[{"metadata":{"name":"secret","namespace":"default"},"data":{"value":"dmVyeS1zZWNyZXQtdmFsdWU="}}]}}}]}
+{"revision":2,"timestamp":"2019-01-01T00:00:01Z","command":"cctl","patch":[{"op":"replace","path":"/secretList/items/0/data/value","value":"dmVyeS1zZWNyZXQtdmFsdWU="}]}
+`
+	if err := ioutil.WriteFile(journalFilename, []byte(plaintext), 0600); err != nil {
+		t.Fatal(err)
+	}
+	journal := state.NewJournal(journalFilename)
+	changed, err := journal.Compact()
+	if err != nil {
+		t.Fatalf("Error compacting journal: %v", err)
+	}
+	if !changed {
+		t.Fatalf("Expected the journal to be compacted")
+	}
+	journalBytes, err = ioutil.ReadFile(journalFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(journalBytes, []byte("dmVyeS1zZWNyZXQtdmFsdWU")) {
+		t.Fatalf("Expected the compacted journal to hold no plain text secret data, found %s", journalBytes)
+	}
+	revisions, err := journal.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("Expected 1 revision after compacting, found %d", len(revisions))
+	}
+	if changed, err := journal.Compact(); err != nil || changed {
+		t.Fatalf("Expected a compacted journal to be unchanged, found changed %v and error %v", changed, err)
+	}
+}
+
+func TestJournalRollbackChangedSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-journal-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.yaml")
+
+	s := newTestState(filename)
+	s.Journal = state.NewJournal(state.JournalFilename(filename))
+	defer s.Unlock()
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: testNamespace,
+		},
+		Data: map[string][]byte{"tls.crt": []byte("old-ca")},
+	}
+	if _, err := s.KubeClient.CoreV1().Secrets(testNamespace).Create(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PullFromAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["tls.crt"] = []byte("new-ca")
+	if _, err := s.KubeClient.CoreV1().Secrets(testNamespace).Update(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PullFromAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	stateBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Rollback(1)
+	if err == nil {
+		t.Fatalf("Expected rolling back across a change of secret data to fail")
+	}
+	if !strings.Contains(err.Error(), `"tls.crt"`) || !strings.Contains(err.Error(), `"ca"`) {
+		t.Fatalf("Expected the error to name the secret and key, found %v", err)
+	}
+	actual, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stateBytes, actual) {
+		t.Fatalf("Expected the refused rollback to leave the state file unchanged")
+	}
+	revisions, err := s.Journal.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("Expected 2 revisions, found %d", len(revisions))
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// LockInfo describes the process that holds the state lock.
+type LockInfo struct {
+	PID       int       `json:"pid"`
+	User      string    `json:"user"`
+	Command   string    `json:"command"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (li *LockInfo) String() string {
+	return fmt.Sprintf("PID %d (user %q, command %q) since %s", li.PID, li.User, li.Command, li.Timestamp.Format(time.RFC3339))
+}
+
+// LockFilename returns the name of the lock file that guards the state file.
+func LockFilename(filename string) string {
+	return filename + ".lock"
+}
+
+// Lock implements StateStore. It takes an exclusive advisory lock on the state
+// file. The lock is held until Unlock is called or the process exits. Calling
+// Lock while the lock is held is a no-op.
+func (fs *FileStore) Lock() error {
+	if fs.lockFile != nil {
+		return nil
+	}
+	lockFilename := LockFilename(fs.Filename)
+	file, err := os.OpenFile(lockFilename, os.O_RDWR|os.O_CREATE, FileMode)
+	if err != nil {
+		return fmt.Errorf("unable to open lock file %q: %v", lockFilename, err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err != syscall.EWOULDBLOCK {
+			return fmt.Errorf("unable to lock %q: %v", lockFilename, err)
+		}
+		holder, err := ReadLockInfo(fs.Filename)
+		if err != nil || holder == nil {
+			return fmt.Errorf("state file %q is locked by another cctl process", fs.Filename)
+		}
+		return fmt.Errorf("state file %q is locked by %s", fs.Filename, holder)
+	}
+	lockInfoBytes, err := yaml.Marshal(newLockInfo())
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to marshal lock info to YAML: %v", err)
+	}
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to truncate lock file %q: %v", lockFilename, err)
+	}
+	if _, err := file.WriteAt(lockInfoBytes, 0); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write to lock file %q: %v", lockFilename, err)
+	}
+	fs.lockFile = file
+	return nil
+}
+
+// Unlock implements StateStore. It releases the lock taken by Lock. Calling
+// Unlock while the lock is not held is a no-op.
+func (fs *FileStore) Unlock() error {
+	if fs.lockFile == nil {
+		return nil
+	}
+	defer func() {
+		fs.lockFile.Close()
+		fs.lockFile = nil
+	}()
+	// Clear the lock info before releasing the lock, so that no other
+	// process reads stale holder information.
+	if err := fs.lockFile.Truncate(0); err != nil {
+		return fmt.Errorf("unable to truncate lock file %q: %v", fs.lockFile.Name(), err)
+	}
+	if err := syscall.Flock(int(fs.lockFile.Fd()), syscall.LOCK_UN); err != nil {
+		return fmt.Errorf("unable to unlock %q: %v", fs.lockFile.Name(), err)
+	}
+	return nil
+}
+
+// ReadLockInfo returns the lock holder recorded for the state file. It returns
+// nil if no holder is recorded.
+func ReadLockInfo(filename string) (*LockInfo, error) {
+	lockFilename := LockFilename(filename)
+	lockInfoBytes, err := ioutil.ReadFile(lockFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read lock file %q: %v", lockFilename, err)
+	}
+	if len(lockInfoBytes) == 0 {
+		return nil, nil
+	}
+	li := LockInfo{}
+	if err := yaml.Unmarshal(lockInfoBytes, &li); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal lock info from YAML: %v", err)
+	}
+	return &li, nil
+}
+
+// ForceUnlock clears the lock holder recorded for the state file, and returns
+// it. The kernel releases the lock when the process that holds it exits, so
+// only the recorded holder can be stale. It returns an error if a process
+// still holds the lock.
+func ForceUnlock(filename string) (*LockInfo, error) {
+	holder, err := ReadLockInfo(filename)
+	if err != nil {
+		return nil, err
+	}
+	lockFilename := LockFilename(filename)
+	file, err := os.OpenFile(lockFilename, os.O_RDWR, FileMode)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return holder, nil
+		}
+		return nil, fmt.Errorf("unable to open lock file %q: %v", lockFilename, err)
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err != syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("unable to lock %q: %v", lockFilename, err)
+		}
+		if holder == nil {
+			return nil, fmt.Errorf("state file %q is locked by another cctl process that is still running", filename)
+		}
+		return nil, fmt.Errorf("state file %q is locked by %s, which is still running", filename, holder)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	if err := file.Truncate(0); err != nil {
+		return nil, fmt.Errorf("unable to truncate lock file %q: %v", lockFilename, err)
+	}
+	return holder, nil
+}
+
+func newLockInfo() *LockInfo {
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	return &LockInfo{
+		PID:       os.Getpid(),
+		User:      username,
+		Command:   strings.Join(os.Args, " "),
+		Timestamp: time.Now(),
+	}
+}
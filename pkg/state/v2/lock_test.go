@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	spclientfake "github.com/platform9/ssh-provider/pkg/client/clientset_generated/clientset/fake"
+	kubeclientfake "k8s.io/client-go/kubernetes/fake"
+	clusterclientfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+
+	state "github.com/platform9/cctl/pkg/state/v2"
+)
+
+func newTestState(filename string) *state.State {
+	return state.NewWithFile(filename, kubeclientfake.NewSimpleClientset(), clusterclientfake.NewSimpleClientset(), spclientfake.NewSimpleClientset())
+}
+
+func TestLock(t *testing.T) {
+	file, err := ioutil.TempFile("/tmp", "cctl-lock-test")
+	if err != nil {
+		t.Fatalf("Error creating temp state file: %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	defer os.Remove(state.LockFilename(file.Name()))
+
+	first := newTestState(file.Name())
+	second := newTestState(file.Name())
+
+	if err := first.Lock(); err != nil {
+		t.Fatalf("Expected first lock to succeed, got: %v", err)
+	}
+	holder, err := state.ReadLockInfo(file.Name())
+	if err != nil {
+		t.Fatalf("Error reading lock info: %v", err)
+	}
+	if holder == nil || holder.PID != os.Getpid() {
+		t.Fatalf("Expected lock holder PID %d, found %v", os.Getpid(), holder)
+	}
+	if err := second.PullFromAPIs(); err == nil {
+		t.Fatalf("Expected write to fail while the state file is locked")
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Error unlocking: %v", err)
+	}
+	if err := second.PullFromAPIs(); err != nil {
+		t.Fatalf("Expected write to succeed after unlock, got: %v", err)
+	}
+
+	// The lock of a running process is never removed.
+	if _, err := state.ForceUnlock(file.Name()); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("PID %d", os.Getpid())) {
+		t.Fatalf("Expected forced unlock to fail while the lock is held, and name the holder, got: %v", err)
+	}
+	if err := second.Unlock(); err != nil {
+		t.Fatalf("Error unlocking: %v", err)
+	}
+
+	// A holder recorded by a process that exited without unlocking is
+	// stale.
+	stale := []byte("pid: 1\nuser: root\ncommand: cctl\ntimestamp: \"2019-01-01T00:00:00Z\"\n")
+	if err := ioutil.WriteFile(state.LockFilename(file.Name()), stale, 0600); err != nil {
+		t.Fatal(err)
+	}
+	holder, err = state.ForceUnlock(file.Name())
+	if err != nil {
+		t.Fatalf("Error forcing unlock: %v", err)
+	}
+	if holder == nil || holder.PID != 1 {
+		t.Fatalf("Expected stale lock holder PID 1, found %v", holder)
+	}
+	if holder, err := state.ReadLockInfo(file.Name()); err != nil || holder != nil {
+		t.Fatalf("Expected no lock holder after forced unlock, found %v, %v", holder, err)
+	}
+	if err := first.Lock(); err != nil {
+		t.Fatalf("Expected lock to succeed after forced unlock, got: %v", err)
+	}
+	first.Unlock()
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+
+	"github.com/platform9/cctl/pkg/state/s3"
+)
+
+// S3Store stores the state in an object in an S3-compatible object store.
+// Instead of a lock, it uses optimistic concurrency: a write only succeeds if
+// the object has not changed since it was read. No previous versions are kept;
+// enable versioning on the bucket to keep them.
+type S3Store struct {
+	Client *s3.Client
+	Bucket string
+	Key    string
+
+	// etag is the ETag of the object when it was last read or written. It is
+	// empty if the object did not exist.
+	etag string
+}
+
+// NewS3Store returns a store for the object.
+func NewS3Store(client *s3.Client, bucket, key string) *S3Store {
+	return &S3Store{
+		Client: client,
+		Bucket: bucket,
+		Key:    key,
+	}
+}
+
+func (ss *S3Store) String() string {
+	return fmt.Sprintf("s3://%s/%s", ss.Bucket, ss.Key)
+}
+
+// Lock implements StateStore. It is a no-op, because concurrent changes are
+// detected by Write.
+func (ss *S3Store) Lock() error {
+	return nil
+}
+
+// Unlock implements StateStore. It is a no-op.
+func (ss *S3Store) Unlock() error {
+	return nil
+}
+
+// Read implements StateStore.
+func (ss *S3Store) Read() ([]byte, error) {
+	stateBytes, etag, err := ss.Client.GetObject(ss.Bucket, ss.Key)
+	if err == s3.ErrNotFound {
+		ss.etag = ""
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %v", ss, err)
+	}
+	ss.etag = etag
+	return stateBytes, nil
+}
+
+// Write implements StateStore. It fails if the object was changed by another
+// process since it was last read or written by this store.
+func (ss *S3Store) Write(stateBytes []byte) error {
+	etag, err := ss.Client.PutObject(ss.Bucket, ss.Key, stateBytes, ss.etag)
+	if err == s3.ErrPreconditionFailed {
+		return fmt.Errorf("state %q was changed by another process since it was read. Run the command again to apply it to the changed state", ss)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %q: %v", ss, err)
+	}
+	ss.etag = etag
+	return nil
+}
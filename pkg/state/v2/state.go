@@ -18,11 +18,11 @@ package v2
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
 
 	"github.com/ghodss/yaml"
 
+	"github.com/platform9/cctl/pkg/state/encryption"
+
 	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -36,6 +36,9 @@ import (
 const (
 	// FileMode defines the file mode used to create the state file.
 	FileMode = 0600
+	// DefaultBackups defines the number of previous versions of the state
+	// file that are kept.
+	DefaultBackups = 5
 )
 
 type SchemaVersion int
@@ -47,22 +50,36 @@ const Version = SchemaVersion(2)
 type State struct {
 	SchemaVersion SchemaVersion           `json:"schemaVersion"`
 	Filename      string                  `json:"-"`
+	Store         StateStore              `json:"-"`
 	KubeClient    kubernetes.Interface    `json:"-"`
 	ClusterClient clusterclient.Interface `json:"-"`
 	SPClient      spclient.Interface      `json:"-"`
+	KeyProvider   encryption.KeyProvider  `json:"-"`
+	Journal       *Journal                `json:"-"`
 
+	Encryption             *encryption.Config          `json:"encryption,omitempty"`
 	SecretList             corev1.SecretList           `json:"secretList,omitempty"`
 	ClusterList            clusterv1.ClusterList       `json:"clusterList,omitempty"`
 	MachineList            clusterv1.MachineList       `json:"machineList,omitempty"`
 	ProvisionedMachineList spv1.ProvisionedMachineList `json:"provisionedMachineList,omitempty"`
+
+	dataKey     []byte
+	ciphertexts encryption.Ciphertexts
 }
 
 // NewWithFile returns the state ready to sync objects between the APIs and the
 // file.
 func NewWithFile(filename string, kubeClient kubernetes.Interface, clusterClient clusterclient.Interface, spClient spclient.Interface) *State {
+	s := New(NewFileStore(filename), kubeClient, clusterClient, spClient)
+	s.Filename = filename
+	return s
+}
+
+// New returns the state ready to sync objects between the APIs and the store.
+func New(store StateStore, kubeClient kubernetes.Interface, clusterClient clusterclient.Interface, spClient spclient.Interface) *State {
 	s := State{
 		SchemaVersion: Version,
-		Filename:      filename,
+		Store:         store,
 		KubeClient:    kubeClient,
 		ClusterClient: clusterClient,
 		SPClient:      spClient,
@@ -75,13 +92,35 @@ func NewWithFile(filename string, kubeClient kubernetes.Interface, clusterClient
 	return &s
 }
 
+// store returns the store of the state. If no store is set, the state is
+// stored in Filename.
+func (s *State) store() StateStore {
+	if s.Store == nil {
+		s.Store = NewFileStore(s.Filename)
+	}
+	return s.Store
+}
+
+// Lock locks the store of the state. See StateStore.
+func (s *State) Lock() error {
+	return s.store().Lock()
+}
+
+// Unlock unlocks the store of the state. See StateStore.
+func (s *State) Unlock() error {
+	return s.store().Unlock()
+}
+
 func (s *State) read() error {
-	file, err := os.OpenFile(s.Filename, os.O_RDONLY|os.O_CREATE, FileMode)
+	stateBytes, err := s.store().Read()
 	if err != nil {
-		return fmt.Errorf("unable to open %q: %v", s.Filename, err)
+		return err
 	}
-	defer file.Close()
-	stateBytes, err := ioutil.ReadAll(file)
+	return s.decode(stateBytes)
+}
+
+// decode unmarshals the serialized state, and decrypts its secrets.
+func (s *State) decode(stateBytes []byte) error {
 	if err := yaml.Unmarshal(stateBytes, s); err != nil {
 		return fmt.Errorf("unable to unmarshal state from YAML: %v", err)
 	}
@@ -89,29 +128,47 @@ func (s *State) read() error {
 		return fmt.Errorf("unexpected state file version. Expecting schemaVersion %v, got %v instead."+
 			" Please update the state file by running cctl with the migrate command", Version, s.SchemaVersion)
 	}
+	if s.Encryption != nil {
+		if err := s.unwrapDataKey(); err != nil {
+			return err
+		}
+		ciphertexts, err := encryption.DecryptSecrets(s.SecretList.Items, s.dataKey)
+		if err != nil {
+			return fmt.Errorf("unable to decrypt secrets: %v", err)
+		}
+		s.ciphertexts = ciphertexts
+	}
 	return nil
 }
 
 func (s *State) write() error {
-	file, err := os.OpenFile(s.Filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, FileMode)
-	if err != nil {
-		return fmt.Errorf("unable to open %q: %v", s.Filename, err)
+	out := *s
+	if s.Encryption != nil {
+		if err := s.unwrapDataKey(); err != nil {
+			return err
+		}
+		encryptedSecrets, ciphertexts, err := encryption.EncryptSecrets(s.SecretList.Items, s.dataKey, s.ciphertexts)
+		if err != nil {
+			return fmt.Errorf("unable to encrypt secrets: %v", err)
+		}
+		out.SecretList.Items = encryptedSecrets
+		s.ciphertexts = ciphertexts
 	}
-	defer file.Close()
-	stateBytes, err := yaml.Marshal(s)
+	stateBytes, err := yaml.Marshal(&out)
 	if err != nil {
 		return fmt.Errorf("unable to marshal state to YAML: %v", err)
 	}
-	_, err = file.Write(stateBytes)
-	if err != nil {
-		return fmt.Errorf("unable to write to %q: %v", s.Filename, err)
-	}
-	return nil
+	return s.writeRevision(stateBytes)
 }
 
-// PushToAPIs reads objects in the state file and creates them using the APIs.
-// If the file does not exist, it will be created.
+// PushToAPIs reads objects in the store and creates them using the APIs. If
+// the state does not exist, it will be created. The store is locked until
+// Unlock is called or the process exits, so that the read-modify-write cycle
+// ending in PullFromAPIs is not interleaved with another process.
 func (s *State) PushToAPIs() error {
+	if err := s.Lock(); err != nil {
+		return err
+	}
 	if err := s.read(); err != nil {
 		return err
 	}
@@ -138,9 +195,12 @@ func (s *State) PushToAPIs() error {
 	return nil
 }
 
-// PullFromAPIs stores API objects in the state file. If the file does not
-// exist, it will be created.
+// PullFromAPIs stores API objects in the store. If the state does not exist,
+// it will be created.
 func (s *State) PullFromAPIs() error {
+	if err := s.Lock(); err != nil {
+		return err
+	}
 	secretList, err := s.KubeClient.CoreV1().Secrets(corev1.NamespaceAll).List(metav1.ListOptions{})
 	if err != nil {
 		return err
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/platform9/cctl/pkg/state/s3"
+)
+
+// StateStore persists the serialized state.
+type StateStore interface {
+	// Lock guards the read-modify-write cycle of the state against other
+	// processes. Stores that detect concurrent changes when writing may
+	// implement it as a no-op.
+	Lock() error
+	// Unlock releases the lock taken by Lock.
+	Unlock() error
+	// Read returns the serialized state. It returns no bytes if the state does
+	// not exist.
+	Read() ([]byte, error)
+	// Write replaces the serialized state.
+	Write(stateBytes []byte) error
+	// String describes where the state is stored.
+	String() string
+}
+
+// NewStore returns the store for the state URL. A URL without a scheme is the
+// path of a state file. Supported schemes are:
+//
+//	file:///etc/cctl-state.yaml
+//	s3://bucket/key?endpoint=http://localhost:9000&region=us-east-1
+//
+// The S3 credentials, and defaults for the endpoint and region, are read from
+// the standard AWS environment variables.
+func NewStore(stateURL string) (StateStore, error) {
+	if !strings.Contains(stateURL, "://") {
+		return NewFileStore(stateURL), nil
+	}
+	u, err := url.Parse(stateURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse state URL %q: %v", stateURL, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("state URL %q has no path", stateURL)
+		}
+		return NewFileStore(u.Host + u.Path), nil
+	case "s3":
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, fmt.Errorf("state URL %q must name a bucket and key, e.g. s3://bucket/cctl-state.yaml", stateURL)
+		}
+		client := s3.NewClientFromEnv()
+		if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+			client.Endpoint = endpoint
+		}
+		if region := u.Query().Get("region"); region != "" {
+			client.Region = region
+		}
+		return NewS3Store(client, u.Host, key), nil
+	default:
+		return nil, fmt.Errorf("unsupported state URL scheme %q. Supported schemes are file and s3", u.Scheme)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2_test
+
+import (
+	"testing"
+
+	spclientfake "github.com/platform9/ssh-provider/pkg/client/clientset_generated/clientset/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientfake "k8s.io/client-go/kubernetes/fake"
+	clusterclientfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+
+	"github.com/platform9/cctl/pkg/state/s3/s3test"
+	state "github.com/platform9/cctl/pkg/state/v2"
+)
+
+func TestNewStore(t *testing.T) {
+	testcases := []struct {
+		url      string
+		expected string
+	}{
+		{"/etc/cctl-state.yaml", "/etc/cctl-state.yaml"},
+		{"file:///etc/cctl-state.yaml", "/etc/cctl-state.yaml"},
+		{"s3://bucket/dir/cctl-state.yaml", "s3://bucket/dir/cctl-state.yaml"},
+	}
+	for _, tc := range testcases {
+		store, err := state.NewStore(tc.url)
+		if err != nil {
+			t.Fatalf("Error creating store for %q: %v", tc.url, err)
+		}
+		if store.String() != tc.expected {
+			t.Fatalf("Expected store %q for %q, found %q", tc.expected, tc.url, store)
+		}
+	}
+	for _, url := range []string{"s3://bucket", "gs://bucket/key"} {
+		if _, err := state.NewStore(url); err == nil {
+			t.Fatalf("Expected error creating store for %q", url)
+		}
+	}
+}
+
+func TestS3StoreConcurrentWrite(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	newS3TestState := func() *state.State {
+		store, err := state.NewStore("s3://bucket/cctl-state.yaml?endpoint=" + server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state.New(store, kubeclientfake.NewSimpleClientset(), clusterclientfake.NewSimpleClientset(), spclientfake.NewSimpleClientset())
+	}
+
+	first := newS3TestState()
+	second := newS3TestState()
+	if err := first.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: testNamespace,
+		},
+	}
+	if _, err := second.KubeClient.CoreV1().Secrets(testNamespace).Create(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.PullFromAPIs(); err != nil {
+		t.Fatalf("Error writing state: %v", err)
+	}
+	if _, ok := server.Object("bucket", "cctl-state.yaml"); !ok {
+		t.Fatalf("Expected state object to be created")
+	}
+	if err := first.PullFromAPIs(); err == nil {
+		t.Fatalf("Expected write of stale state to fail")
+	}
+
+	third := newS3TestState()
+	if err := third.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := third.KubeClient.CoreV1().Secrets(testNamespace).Get("secret", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected secret written by the second state: %v", err)
+	}
+	if err := third.PullFromAPIs(); err != nil {
+		t.Fatalf("Error writing state read after the change: %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package validate checks that the cctl state is internally consistent.
+package validate
+
+import (
+	"encoding/pem"
+	"fmt"
+	"sort"
+
+	spv1 "github.com/platform9/ssh-provider/pkg/apis/sshprovider/v1alpha1"
+	sputil "github.com/platform9/ssh-provider/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clustercommon "sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	clusterutil "sigs.k8s.io/cluster-api/pkg/util"
+
+	"github.com/platform9/cctl/pkg/credential"
+	"github.com/platform9/cctl/pkg/state/v2"
+)
+
+// Severity describes how serious a violation is.
+type Severity string
+
+const (
+	// SeverityError marks a violation that is likely to make cctl commands
+	// fail or damage the cluster.
+	SeverityError Severity = "error"
+	// SeverityWarning marks a violation that cctl can tolerate.
+	SeverityWarning Severity = "warning"
+)
+
+// Names of the checks.
+const (
+	CheckMachineBinding            = "machine-binding"
+	CheckProvisionedMachineBinding = "provisioned-machine-binding"
+	CheckProviderConfig            = "provider-config"
+	CheckEtcdMembers               = "etcd-members"
+	CheckAPIEndpoints              = "api-endpoints"
+	CheckClusterSecrets            = "cluster-secrets"
+	CheckCredentialSecrets         = "credential-secrets"
+)
+
+// Violation describes one inconsistency in the state.
+type Violation struct {
+	Severity Severity `json:"severity"`
+	Check    string   `json:"check"`
+	// Object identifies the object with the inconsistency, as kind/name.
+	Object  string `json:"object"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s: %s: %s", v.Severity, v.Check, v.Object, v.Message)
+}
+
+// HasErrors returns true if any violation has SeverityError.
+func HasErrors(violations []Violation) bool {
+	for _, v := range violations {
+		if v.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// objects holds the objects of the state, indexed by namespace and name.
+type objects struct {
+	secrets             map[string]corev1.Secret
+	clusters            []clusterv1.Cluster
+	machines            []clusterv1.Machine
+	machinesByName      map[string]clusterv1.Machine
+	provisionedMachines map[string]spv1.ProvisionedMachine
+}
+
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// Validate runs every check on the objects of the state, and returns all
+// violations found. The state must have been pushed to the APIs.
+func Validate(s *v2.State) ([]Violation, error) {
+	o, err := listObjects(s)
+	if err != nil {
+		return nil, err
+	}
+	violations := []Violation{}
+	for _, check := range []func(*objects) []Violation{
+		checkMachineBindings,
+		checkProvisionedMachineBindings,
+		checkEtcdMembers,
+		checkAPIEndpoints,
+		checkClusterSecrets,
+		checkCredentialSecrets,
+	} {
+		violations = append(violations, check(o)...)
+	}
+	return violations, nil
+}
+
+func listObjects(s *v2.State) (*objects, error) {
+	o := objects{
+		secrets:             make(map[string]corev1.Secret),
+		machinesByName:      make(map[string]clusterv1.Machine),
+		provisionedMachines: make(map[string]spv1.ProvisionedMachine),
+	}
+	secretList, err := s.KubeClient.CoreV1().Secrets(corev1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list secrets: %v", err)
+	}
+	for _, secret := range secretList.Items {
+		o.secrets[key(secret.Namespace, secret.Name)] = secret
+	}
+	clusterList, err := s.ClusterClient.ClusterV1alpha1().Clusters(corev1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list clusters: %v", err)
+	}
+	o.clusters = clusterList.Items
+	machineList, err := s.ClusterClient.ClusterV1alpha1().Machines(corev1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list machines: %v", err)
+	}
+	o.machines = machineList.Items
+	for _, machine := range machineList.Items {
+		o.machinesByName[key(machine.Namespace, machine.Name)] = machine
+	}
+	pmList, err := s.SPClient.SshproviderV1alpha1().ProvisionedMachines(corev1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list provisioned machines: %v", err)
+	}
+	for _, pm := range pmList.Items {
+		o.provisionedMachines[key(pm.Namespace, pm.Name)] = pm
+	}
+	return &o, nil
+}
+
+func errorf(check, object, format string, args ...interface{}) Violation {
+	return Violation{Severity: SeverityError, Check: check, Object: object, Message: fmt.Sprintf(format, args...)}
+}
+
+func warningf(check, object, format string, args ...interface{}) Violation {
+	return Violation{Severity: SeverityWarning, Check: check, Object: object, Message: fmt.Sprintf(format, args...)}
+}
+
+func machineObject(machine clusterv1.Machine) string {
+	return "machine/" + machine.Name
+}
+
+func pmObject(pm spv1.ProvisionedMachine) string {
+	return "provisionedmachine/" + pm.Name
+}
+
+func clusterObject(cluster clusterv1.Cluster) string {
+	return "cluster/" + cluster.Name
+}
+
+// checkMachineBindings checks that every machine is bound to a provisioned
+// machine that is bound back to it.
+func checkMachineBindings(o *objects) []Violation {
+	violations := []Violation{}
+	for _, machine := range o.machines {
+		machineSpec, err := sputil.GetMachineSpec(machine)
+		if err != nil {
+			violations = append(violations, errorf(CheckProviderConfig, machineObject(machine), "unable to decode machine spec: %v", err))
+			continue
+		}
+		if machineSpec.ProvisionedMachineName == "" {
+			violations = append(violations, errorf(CheckMachineBinding, machineObject(machine), "machine is not bound to a provisioned machine"))
+			continue
+		}
+		pm, ok := o.provisionedMachines[key(machine.Namespace, machineSpec.ProvisionedMachineName)]
+		if !ok {
+			violations = append(violations, errorf(CheckMachineBinding, machineObject(machine), "provisioned machine %q not found", machineSpec.ProvisionedMachineName))
+			continue
+		}
+		if pm.Status.MachineRef == nil || pm.Status.MachineRef.Name != machine.Name {
+			violations = append(violations, errorf(CheckMachineBinding, machineObject(machine), "provisioned machine %q is not bound back to the machine", pm.Name))
+		}
+	}
+	return violations
+}
+
+// checkProvisionedMachineBindings checks that every bound provisioned machine
+// refers to a machine that exists.
+func checkProvisionedMachineBindings(o *objects) []Violation {
+	violations := []Violation{}
+	for _, pm := range sortedProvisionedMachines(o) {
+		if pm.Status.MachineRef == nil {
+			violations = append(violations, warningf(CheckProvisionedMachineBinding, pmObject(pm), "provisioned machine is not bound to a machine"))
+			continue
+		}
+		if _, ok := o.machinesByName[key(pm.Namespace, pm.Status.MachineRef.Name)]; !ok {
+			violations = append(violations, errorf(CheckProvisionedMachineBinding, pmObject(pm), "machine %q not found", pm.Status.MachineRef.Name))
+		}
+	}
+	return violations
+}
+
+// checkEtcdMembers checks that the etcd members of each cluster match the etcd
+// members of its masters.
+func checkEtcdMembers(o *objects) []Violation {
+	violations := []Violation{}
+	for _, cluster := range o.clusters {
+		clusterStatus, err := sputil.GetClusterStatus(cluster)
+		if err != nil {
+			violations = append(violations, errorf(CheckProviderConfig, clusterObject(cluster), "unable to decode cluster status: %v", err))
+			continue
+		}
+		clusterMembers := make(map[string]spv1.EtcdMember)
+		for _, member := range clusterStatus.EtcdMembers {
+			clusterMembers[member.Name] = member
+		}
+		masterMembers := make(map[string]bool)
+		for _, machine := range mastersInNamespace(o, cluster.Namespace) {
+			machineStatus, err := sputil.GetMachineStatus(machine)
+			if err != nil {
+				violations = append(violations, errorf(CheckProviderConfig, machineObject(machine), "unable to decode machine status: %v", err))
+				continue
+			}
+			if machineStatus.EtcdMember == nil {
+				violations = append(violations, errorf(CheckEtcdMembers, machineObject(machine), "master has no etcd member"))
+				continue
+			}
+			member := *machineStatus.EtcdMember
+			masterMembers[member.Name] = true
+			clusterMember, ok := clusterMembers[member.Name]
+			if !ok {
+				violations = append(violations, errorf(CheckEtcdMembers, machineObject(machine), "etcd member %q is not an etcd member of cluster %q", member.Name, cluster.Name))
+				continue
+			}
+			if clusterMember.ID != member.ID {
+				violations = append(violations, errorf(CheckEtcdMembers, machineObject(machine), "etcd member %q has ID %x, but cluster %q records ID %x", member.Name, member.ID, cluster.Name, clusterMember.ID))
+			}
+		}
+		for _, member := range clusterStatus.EtcdMembers {
+			if !masterMembers[member.Name] {
+				violations = append(violations, errorf(CheckEtcdMembers, clusterObject(cluster), "etcd member %q does not belong to any master", member.Name))
+			}
+		}
+	}
+	return violations
+}
+
+// checkAPIEndpoints checks that a cluster with masters has API endpoints.
+func checkAPIEndpoints(o *objects) []Violation {
+	violations := []Violation{}
+	for _, cluster := range o.clusters {
+		if len(mastersInNamespace(o, cluster.Namespace)) != 0 && len(cluster.Status.APIEndpoints) == 0 {
+			violations = append(violations, errorf(CheckAPIEndpoints, clusterObject(cluster), "cluster has masters, but no API endpoints"))
+		}
+	}
+	return violations
+}
+
+// checkClusterSecrets checks that the secrets referenced by each cluster exist,
+// and that their keys and certificates parse as PEM.
+func checkClusterSecrets(o *objects) []Violation {
+	violations := []Violation{}
+	for _, cluster := range o.clusters {
+		clusterSpec, err := sputil.GetClusterSpec(cluster)
+		if err != nil {
+			violations = append(violations, errorf(CheckProviderConfig, clusterObject(cluster), "unable to decode cluster spec: %v", err))
+			continue
+		}
+		for _, ref := range []struct {
+			field   string
+			secret  *corev1.LocalObjectReference
+			pemKeys []string
+		}{
+			{"etcdCASecret", clusterSpec.EtcdCASecret, []string{"tls.crt", "tls.key"}},
+			{"apiServerCASecret", clusterSpec.APIServerCASecret, []string{"tls.crt", "tls.key"}},
+			{"frontProxyCASecret", clusterSpec.FrontProxyCASecret, []string{"tls.crt", "tls.key"}},
+			{"serviceAccountKeySecret", clusterSpec.ServiceAccountKeySecret, []string{"privatekey", "publickey"}},
+			{"bootstrapTokenSecret", clusterSpec.BootstrapTokenSecret, nil},
+		} {
+			if ref.secret == nil {
+				violations = append(violations, warningf(CheckClusterSecrets, clusterObject(cluster), "%s is not set", ref.field))
+				continue
+			}
+			secret, ok := o.secrets[key(cluster.Namespace, ref.secret.Name)]
+			if !ok {
+				violations = append(violations, errorf(CheckClusterSecrets, clusterObject(cluster), "%s %q not found", ref.field, ref.secret.Name))
+				continue
+			}
+			for _, k := range ref.pemKeys {
+				if err := checkPEM(secret.Data[k]); err != nil {
+					violations = append(violations, errorf(CheckClusterSecrets, "secret/"+secret.Name, "key %q: %v", k, err))
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// checkCredentialSecrets checks that the SSH credential of every provisioned
+// machine exists, and that its private key parses as PEM. Credentials that
+// authenticate with the SSH agent have no private key.
+func checkCredentialSecrets(o *objects) []Violation {
+	violations := []Violation{}
+	for _, pm := range sortedProvisionedMachines(o) {
+		if pm.Spec.SSHConfig == nil {
+			violations = append(violations, errorf(CheckCredentialSecrets, pmObject(pm), "provisioned machine has no SSH configuration"))
+			continue
+		}
+		name := pm.Spec.SSHConfig.CredentialSecret.Name
+		secret, ok := o.secrets[key(pm.Namespace, name)]
+		if !ok {
+			violations = append(violations, errorf(CheckCredentialSecrets, pmObject(pm), "credential secret %q not found", name))
+			continue
+		}
+		cred, err := credential.FromSecret(&secret)
+		if err != nil {
+			violations = append(violations, errorf(CheckCredentialSecrets, "secret/"+secret.Name, "%v", err))
+			continue
+		}
+		if cred.Type == credential.TypeAgent {
+			continue
+		}
+		if err := checkPEM(secret.Data[spv1.CredentialSecretSSHPrivateKeyKey]); err != nil {
+			violations = append(violations, errorf(CheckCredentialSecrets, "secret/"+secret.Name, "key %q: %v", spv1.CredentialSecretSSHPrivateKeyKey, err))
+		}
+	}
+	return violations
+}
+
+func checkPEM(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("missing or empty")
+	}
+	if block, _ := pem.Decode(data); block == nil {
+		return fmt.Errorf("does not contain a PEM block")
+	}
+	return nil
+}
+
+func mastersInNamespace(o *objects, namespace string) []clusterv1.Machine {
+	masters := []clusterv1.Machine{}
+	for _, machine := range o.machines {
+		if machine.Namespace != namespace {
+			continue
+		}
+		if clusterutil.RoleContains(clustercommon.MasterRole, machine.Spec.Roles) {
+			masters = append(masters, machine)
+		}
+	}
+	return masters
+}
+
+func sortedProvisionedMachines(o *objects) []spv1.ProvisionedMachine {
+	keys := make([]string, 0, len(o.provisionedMachines))
+	for k := range o.provisionedMachines {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pms := make([]spv1.ProvisionedMachine, 0, len(keys))
+	for _, k := range keys {
+		pms = append(pms, o.provisionedMachines[k])
+	}
+	return pms
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The cctl authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validate_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	spclientfake "github.com/platform9/ssh-provider/pkg/client/clientset_generated/clientset/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientfake "k8s.io/client-go/kubernetes/fake"
+	clusterclientfake "sigs.k8s.io/cluster-api/pkg/client/clientset_generated/clientset/fake"
+
+	"github.com/platform9/cctl/common"
+	"github.com/platform9/cctl/pkg/credential"
+	"github.com/platform9/cctl/pkg/machineclient"
+	"github.com/platform9/cctl/pkg/state/v2"
+	"github.com/platform9/cctl/pkg/state/validate"
+)
+
+func newTestState(t *testing.T) (*v2.State, func()) {
+	dir, err := ioutil.TempDir("/tmp", "cctl-validate-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	stateBytes, err := ioutil.ReadFile("../util/testdata/v2.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "state.yaml")
+	if err := ioutil.WriteFile(filename, stateBytes, v2.FileMode); err != nil {
+		t.Fatal(err)
+	}
+	s := v2.NewWithFile(filename, kubeclientfake.NewSimpleClientset(), clusterclientfake.NewSimpleClientset(), spclientfake.NewSimpleClientset())
+	if err := s.PushToAPIs(); err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Unlock()
+		os.RemoveAll(dir)
+	}
+}
+
+func checks(violations []validate.Violation) map[string]validate.Severity {
+	m := make(map[string]validate.Severity)
+	for _, v := range violations {
+		m[v.Check] = v.Severity
+	}
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	s, cleanup := newTestState(t)
+	defer cleanup()
+
+	violations, err := validate.Validate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("Expected no violations, found %v", violations)
+	}
+
+	cluster, err := s.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Get(common.DefaultClusterName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.Status.APIEndpoints = nil
+	if _, err := s.ClusterClient.ClusterV1alpha1().Clusters(common.DefaultNamespace).Update(cluster); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Delete(common.DefaultEtcdCASecretName, &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	pmList, err := s.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pm := range pmList.Items {
+		if err := s.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).Delete(pm.Name, &metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	violations, err = validate.Validate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validate.HasErrors(violations) {
+		t.Fatalf("Expected errors, found %v", violations)
+	}
+	actual := checks(violations)
+	for _, check := range []string{validate.CheckAPIEndpoints, validate.CheckClusterSecrets, validate.CheckMachineBinding} {
+		if actual[check] != validate.SeverityError {
+			t.Errorf("Expected an error from check %q, found %v", check, violations)
+		}
+	}
+}
+
+func TestValidateAgentCredential(t *testing.T) {
+	s, cleanup := newTestState(t)
+	defer cleanup()
+
+	cred := credential.Credential{
+		Name:     "agent",
+		Username: "ubuntu",
+		Type:     credential.TypeAgent,
+		Become:   machineclient.EscalationSudo,
+	}
+	if _, err := s.KubeClient.CoreV1().Secrets(common.DefaultNamespace).Create(cred.Secret(common.DefaultNamespace)); err != nil {
+		t.Fatal(err)
+	}
+	pmList, err := s.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pm := range pmList.Items {
+		pm.Spec.SSHConfig.CredentialSecret.Name = cred.Name
+		if _, err := s.SPClient.SshproviderV1alpha1().ProvisionedMachines(common.DefaultNamespace).Update(&pm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	violations, err := validate.Validate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("Expected no violations, found %v", violations)
+	}
+}
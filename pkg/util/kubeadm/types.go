@@ -18,5 +18,17 @@ package kubeadm
 
 // ClusterConfiguration is a subset of the equivalent kubeadm type
 type ClusterConfiguration struct {
-	ControlPlaneEndpoint string `json:"controlPlaneEndpoint,omitempty"`
+	ControlPlaneEndpoint       string            `json:"controlPlaneEndpoint,omitempty"`
+	KubernetesVersion          string            `json:"kubernetesVersion,omitempty"`
+	Networking                 Networking        `json:"networking,omitempty"`
+	APIServerCertSANs          []string          `json:"apiServerCertSANs,omitempty"`
+	APIServerExtraArgs         map[string]string `json:"apiServerExtraArgs,omitempty"`
+	ControllerManagerExtraArgs map[string]string `json:"controllerManagerExtraArgs,omitempty"`
+	SchedulerExtraArgs         map[string]string `json:"schedulerExtraArgs,omitempty"`
+}
+
+type Networking struct {
+	ServiceSubnet string `json:"serviceSubnet,omitempty"`
+	PodSubnet     string `json:"podSubnet,omitempty"`
+	DNSDomain     string `json:"dnsDomain,omitempty"`
 }
@@ -96,6 +96,15 @@ func CreateBootstrapTokenSecret(secretName string) (*corev1.Secret, error) {
 	return btSecret, nil
 }
 
+// CreateSecretWithData returns a secret with the given data.
+func CreateSecretWithData(secretName string, data map[string][]byte) *corev1.Secret {
+	secret := createSecret(secretName)
+	for k, v := range data {
+		secret.Data[k] = v
+	}
+	return secret
+}
+
 func generateCertPair() ([]byte, []byte, error) {
 	var certBytes, keyBytes []byte
 	cert, key, err := common.NewCertificateAuthority()
@@ -129,7 +138,6 @@ func createSecret(name string) *corev1.Secret {
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              name,
-			Namespace:         common.DefaultNamespace,
 			CreationTimestamp: metav1.Now(),
 		},
 		Data: make(map[string][]byte),